@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// accountLabel is the label carrying the --accounts name of a site's metrics
+const accountLabel = "account"
+
+// MetricsClient fetches ISP metrics, from the Ubiquiti cloud API or a local controller
+type MetricsClient interface {
+	GetISPMetricsRange(ctx context.Context, metricType string, begin, end time.Time) (*ISPMetrics, error)
+}
+
+// Account is a Ubiquiti UI account polled with its own API key. The account of
+// --api-key, or of the local controller, has no name.
+type Account struct {
+	Name   string
+	Client MetricsClient
+}
+
+// newAccounts creates a client for --api-key followed by one for every --accounts
+// key, ordered by account name, or the client of the local controller or of the
+// responses being replayed
+func newAccounts(cli *CLI, logger *logrus.Logger) ([]Account, error) {
+	if cli.replay != nil {
+		return []Account{{Client: cli.replay}}, nil
+	}
+	if cli.Source == "local" {
+		if len(cli.Accounts) > 0 {
+			return nil, fmt.Errorf("--accounts requires --source cloud")
+		}
+		client, err := newLocalClient(cli, logger)
+		if err != nil {
+			return nil, err
+		}
+		return []Account{{Client: client}}, nil
+	}
+
+	if cli.ApiKey == "" && len(cli.Accounts) == 0 {
+		return nil, fmt.Errorf("--api-key or --accounts is required")
+	}
+
+	// The accounts share the client's connections, differing only in their API key
+	client, err := newUbiquitiClient(cli, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	if cli.ApiKey != "" {
+		accounts = append(accounts, Account{Client: client})
+	}
+
+	names := make([]string, 0, len(cli.Accounts))
+	for name := range cli.Accounts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, "/+#") {
+			return nil, fmt.Errorf("invalid account name %q, it must be non-empty and not contain /, + or #", name)
+		}
+		if cli.Accounts[name] == "" {
+			return nil, fmt.Errorf("account %q has no API key", name)
+		}
+		accountClient := *client
+		accountClient.apiKey = cli.Accounts[name]
+		accounts = append(accounts, Account{Name: name, Client: &accountClient})
+	}
+	return accounts, nil
+}
+
+// fetchAccounts fetches ISP metrics between begin and end from every account,
+// tagging each site with its account. Zero times are omitted, as for
+// GetISPMetricsRange. The sites of accounts that could be fetched are returned
+// along with the errors of those that could not, and metrics is nil only if
+// every account failed.
+func fetchAccounts(ctx context.Context, accounts []Account, metricType string, begin, end time.Time) (*ISPMetrics, error) {
+	var merged *ISPMetrics
+	var errs []error
+
+	for _, account := range accounts {
+		metrics, err := account.Client.GetISPMetricsRange(ctx, metricType, begin, end)
+		if err != nil {
+			if account.Name != "" {
+				err = fmt.Errorf("account %s: %w", account.Name, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		for i := range metrics.Data {
+			metrics.Data[i].Account = account.Name
+		}
+		if merged == nil {
+			merged = metrics
+		} else {
+			merged.Data = append(merged.Data, metrics.Data...)
+		}
+	}
+
+	return merged, errors.Join(errs...)
+}
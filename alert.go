@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Alert states published on the alert topics
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// AlertRulesFile represents the user supplied alert rules file
+type AlertRulesFile struct {
+	Rules []AlertRule `json:"rules"`
+}
+
+// AlertRule fires for a site once one of its WAN values has breached the threshold
+// for a number of consecutive polls, and resolves on the first poll it no longer does
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	For       int     `json:"for,omitempty"`
+	Severity  string  `json:"severity,omitempty"`
+}
+
+// AlertEvent is published when an alert starts firing or resolves
+type AlertEvent struct {
+	Rule        string            `json:"rule"`
+	State       string            `json:"state"`
+	Severity    string            `json:"severity,omitempty"`
+	SiteId      string            `json:"siteId"`
+	HostId      string            `json:"hostId"`
+	Metric      string            `json:"metric"`
+	Value       int               `json:"value"`
+	Operator    string            `json:"operator"`
+	Threshold   float64           `json:"threshold"`
+	Consecutive int               `json:"consecutive"`
+	Timestamp   string            `json:"timestamp"`
+	FiringSince *time.Time        `json:"firingSince,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	PublishedAt time.Time         `json:"publishedAt"`
+}
+
+// LoadAlertRules reads the alert rules file at path
+func LoadAlertRules(path string) (*AlertRulesFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules file: %w", err)
+	}
+
+	var rules AlertRulesFile
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules file: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("alert rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate alert rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if strings.ContainsAny(rule.Name, "/+#") {
+			return nil, fmt.Errorf("alert rule name %q must not contain /, + or #", rule.Name)
+		}
+		if _, ok := lookupWANValue(WANData{}, rule.Metric); !ok {
+			return nil, fmt.Errorf("alert rule %q has unknown metric %q", rule.Name, rule.Metric)
+		}
+		if _, ok := alertOperators[rule.Operator]; !ok {
+			return nil, fmt.Errorf("alert rule %q has unknown operator %q (use >, >=, < or <=)", rule.Name, rule.Operator)
+		}
+		if rule.For < 0 {
+			return nil, fmt.Errorf("alert rule %q has a negative for", rule.Name)
+		}
+	}
+
+	return &rules, nil
+}
+
+// alertOperators compares a value against a rule's threshold
+var alertOperators = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+}
+
+// alertState tracks one rule for one site
+type alertState struct {
+	consecutive int
+	firingSince *time.Time
+}
+
+// AlertEvaluator evaluates the alert rules against every site's latest period
+type AlertEvaluator struct {
+	rules  []AlertRule
+	states map[string]*alertState
+	logger *logrus.Logger
+}
+
+// NewAlertEvaluator creates an evaluator for rules
+func NewAlertEvaluator(rules *AlertRulesFile, logger *logrus.Logger) *AlertEvaluator {
+	return &AlertEvaluator{
+		rules:  rules.Rules,
+		states: make(map[string]*alertState),
+		logger: logger,
+	}
+}
+
+// setRules replaces the rules, as on a configuration reload. Rules keep their state
+// by name, and the state of removed rules is dropped.
+func (e *AlertEvaluator) setRules(rules *AlertRulesFile) {
+	names := make(map[string]bool, len(rules.Rules))
+	for _, rule := range rules.Rules {
+		names[rule.Name] = true
+	}
+	for key := range e.states {
+		name, _, _ := strings.Cut(key, "/")
+		if !names[name] {
+			delete(e.states, key)
+		}
+	}
+	e.rules = rules.Rules
+}
+
+// Evaluate checks every rule against the latest period of each site in metrics,
+// returning an event for every alert that started firing or resolved
+func (e *AlertEvaluator) Evaluate(metrics *ISPMetrics, sites map[string]Metric) []AlertEvent {
+	var events []AlertEvent
+
+	for _, data := range metrics.Data {
+		site, ok := sites[data.SiteId]
+		if !ok {
+			continue
+		}
+
+		for _, rule := range e.rules {
+			key := rule.Name + "/" + site.SiteId
+			state, ok := e.states[key]
+			if !ok {
+				state = &alertState{}
+				e.states[key] = state
+			}
+
+			value, _ := lookupWANValue(site.WAN, rule.Metric)
+			if alertOperators[rule.Operator](float64(value), rule.Threshold) {
+				state.consecutive++
+			} else {
+				state.consecutive = 0
+			}
+
+			var transition string
+			switch {
+			case state.firingSince == nil && state.consecutive >= max(rule.For, 1):
+				now := time.Now()
+				state.firingSince = &now
+				transition = alertFiring
+			case state.firingSince != nil && state.consecutive == 0:
+				transition = alertResolved
+			default:
+				continue
+			}
+
+			event := AlertEvent{
+				Rule:        rule.Name,
+				State:       transition,
+				Severity:    rule.Severity,
+				SiteId:      site.SiteId,
+				HostId:      site.HostId,
+				Metric:      rule.Metric,
+				Value:       value,
+				Operator:    rule.Operator,
+				Threshold:   rule.Threshold,
+				Consecutive: state.consecutive,
+				Timestamp:   site.Timestamp,
+				FiringSince: state.firingSince,
+				Labels:      site.Labels,
+				PublishedAt: time.Now(),
+			}
+			if transition == alertResolved {
+				state.firingSince = nil
+			}
+
+			entry := e.logger.WithFields(logrus.Fields{
+				"rule":   rule.Name,
+				"siteId": site.SiteId,
+				"metric": rule.Metric,
+				"value":  value,
+			})
+			if transition == alertFiring {
+				entry.Warn("Alert firing")
+			} else {
+				entry.Info("Alert resolved")
+			}
+
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// PublishAlert publishes a retained alert event to baseTopic/alerts/<siteId>/<rule>,
+// so subscribers see the current state of every alert
+func (p *MQTTPublisher) PublishAlert(event AlertEvent, baseTopic string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/alerts/%s/%s", baseTopic, event.SiteId, event.Rule)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic": topic,
+		"state": event.State,
+	}).Debug("Publishing alert to MQTT")
+
+	if err := p.send(topic, 1, true, payload); err != nil {
+		return fmt.Errorf("failed to publish alert to MQTT: %w", err)
+	}
+
+	return nil
+}
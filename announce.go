@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AnnounceConfig represents the announce templates file
+type AnnounceConfig struct {
+	Templates []AnnounceTemplate `json:"templates"`
+}
+
+// AnnounceTemplate describes one discovery/config message published per site.
+// Topic and Payload are Go text/template strings rendered with AnnounceData.
+type AnnounceTemplate struct {
+	Name    string `json:"name"`
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+	QoS     byte   `json:"qos"`
+	Retain  bool   `json:"retain"`
+}
+
+// AnnounceData is the data made available to announce templates
+type AnnounceData struct {
+	BaseTopic         string
+	LatencyTopic      string
+	WANTopic          string
+	AvailabilityTopic string
+	SiteId            string
+	SiteName          string
+	HostId            string
+	ISPName           string
+	ISPAsn            string
+	Labels            map[string]string
+}
+
+type compiledAnnounce struct {
+	name    string
+	topic   *template.Template
+	payload *template.Template
+	qos     byte
+	retain  bool
+}
+
+// Announcer publishes templated discovery messages once for every newly seen site
+type Announcer struct {
+	templates []compiledAnnounce
+	announced map[string]bool
+	publisher *MQTTPublisher
+	baseTopic string
+	logger    *logrus.Logger
+}
+
+// announceFuncs are helper functions available inside announce templates
+var announceFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// LoadAnnounceTemplates reads the announce templates file at path
+func LoadAnnounceTemplates(path string) ([]AnnounceTemplate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read announce file: %w", err)
+	}
+
+	var cfg AnnounceConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse announce file: %w", err)
+	}
+
+	return cfg.Templates, nil
+}
+
+// NewAnnouncer compiles the given announce templates
+func NewAnnouncer(entries []AnnounceTemplate, publisher *MQTTPublisher, baseTopic string, logger *logrus.Logger) (*Announcer, error) {
+	templates, err := compileAnnounceTemplates(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Announcer{
+		templates: templates,
+		announced: make(map[string]bool),
+		publisher: publisher,
+		baseTopic: baseTopic,
+		logger:    logger,
+	}, nil
+}
+
+// compileAnnounceTemplates parses the topic and payload templates of each entry
+func compileAnnounceTemplates(entries []AnnounceTemplate) ([]compiledAnnounce, error) {
+	var templates []compiledAnnounce
+	for i, t := range entries {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("template-%d", i)
+		}
+		if t.Topic == "" {
+			return nil, fmt.Errorf("announce template %q has no topic", name)
+		}
+		if t.QoS > 2 {
+			return nil, fmt.Errorf("announce template %q has invalid qos %d", name, t.QoS)
+		}
+
+		topicTmpl, err := template.New(name + "-topic").Funcs(announceFuncs).Parse(t.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse topic of announce template %q: %w", name, err)
+		}
+		payloadTmpl, err := template.New(name + "-payload").Funcs(announceFuncs).Parse(t.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse payload of announce template %q: %w", name, err)
+		}
+
+		templates = append(templates, compiledAnnounce{
+			name:    name,
+			topic:   topicTmpl,
+			payload: payloadTmpl,
+			qos:     t.QoS,
+			retain:  t.Retain,
+		})
+	}
+	return templates, nil
+}
+
+// AnnounceNew publishes the announce templates for sites that have not been announced yet.
+// The first call covers every site seen at startup; later calls only announce added sites.
+func (a *Announcer) AnnounceNew(metrics []Metric) {
+	for _, m := range metrics {
+		if a.announced[m.SiteId] {
+			continue
+		}
+
+		siteName := m.Labels["site_name"]
+		if siteName == "" {
+			siteName = m.SiteId
+		}
+
+		latencyTopic, err := a.publisher.siteTopic("latency", m)
+		if err != nil {
+			a.logger.WithError(err).WithField("siteId", m.SiteId).Error("Failed to render site topics")
+			continue
+		}
+		wanTopic, err := a.publisher.siteTopic("wan", m)
+		if err != nil {
+			a.logger.WithError(err).WithField("siteId", m.SiteId).Error("Failed to render site topics")
+			continue
+		}
+
+		data := AnnounceData{
+			BaseTopic:         a.baseTopic,
+			LatencyTopic:      latencyTopic,
+			WANTopic:          wanTopic,
+			AvailabilityTopic: availabilityTopic(a.baseTopic),
+			SiteId:            m.SiteId,
+			SiteName:          siteName,
+			HostId:            m.HostId,
+			ISPName:           m.WAN.ISPName,
+			ISPAsn:            m.WAN.ISPAsn,
+			Labels:            m.Labels,
+		}
+
+		ok := true
+		for _, t := range a.templates {
+			if err := a.publish(t, data); err != nil {
+				a.logger.WithError(err).WithFields(logrus.Fields{
+					"siteId":   m.SiteId,
+					"template": t.name,
+				}).Error("Failed to publish announce message")
+				ok = false
+			}
+		}
+
+		// Retry on the next cycle if any template failed
+		if ok {
+			a.announced[m.SiteId] = true
+			a.logger.WithField("siteId", m.SiteId).Info("Announced site")
+		}
+	}
+}
+
+// publish renders a single template for a site and publishes it
+func (a *Announcer) publish(t compiledAnnounce, data AnnounceData) error {
+	var topic, payload bytes.Buffer
+	if err := t.topic.Execute(&topic, data); err != nil {
+		return fmt.Errorf("failed to render topic: %w", err)
+	}
+	if err := t.payload.Execute(&payload, data); err != nil {
+		return fmt.Errorf("failed to render payload: %w", err)
+	}
+
+	return a.publisher.PublishRaw(topic.String(), t.qos, t.retain, payload.Bytes())
+}
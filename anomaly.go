@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Anomaly states published on the anomaly topics
+const (
+	anomalyAnomalous = "anomalous"
+	anomalyNormal    = "normal"
+)
+
+// AnomalyEvent is published when a site's WAN value starts or stops deviating from
+// its rolling baseline
+type AnomalyEvent struct {
+	SiteId      string            `json:"siteId"`
+	HostId      string            `json:"hostId"`
+	Metric      string            `json:"metric"`
+	State       string            `json:"state"`
+	Value       int               `json:"value"`
+	Baseline    float64           `json:"baseline"`
+	StdDev      float64           `json:"stdDev"`
+	ZScore      float64           `json:"zScore"`
+	Timestamp   string            `json:"timestamp"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	PublishedAt time.Time         `json:"publishedAt"`
+}
+
+// baseline is the exponentially weighted mean and variance of one WAN value of a site
+type baseline struct {
+	samples   int
+	mean      float64
+	variance  float64
+	anomalous bool
+}
+
+// update adds value to the baseline with smoothing factor alpha
+func (b *baseline) update(value, alpha float64) {
+	b.samples++
+	if b.samples == 1 {
+		b.mean = value
+		return
+	}
+	diff := value - b.mean
+	incr := alpha * diff
+	b.mean += incr
+	b.variance = (1 - alpha) * (b.variance + diff*incr)
+}
+
+// AnomalyDetector keeps a rolling baseline of selected WAN values per site and
+// reports values deviating from it by more than a z-score threshold
+type AnomalyDetector struct {
+	metrics      []string
+	alpha        float64
+	threshold    float64
+	minDeviation float64
+	warmup       int
+	baselines    map[string]*baseline
+	logger       *logrus.Logger
+}
+
+// NewAnomalyDetector creates a detector for the named WAN values. alpha is the EWMA
+// smoothing factor, threshold the z-score beyond which a value is anomalous and
+// minDeviation the percentage it must also differ from the baseline by. No value is
+// reported before its baseline has seen warmup polls.
+func NewAnomalyDetector(metrics []string, alpha, threshold, minDeviation float64, warmup int, logger *logrus.Logger) (*AnomalyDetector, error) {
+	for _, name := range metrics {
+		if _, ok := lookupWANValue(WANData{}, name); !ok {
+			return nil, fmt.Errorf("unknown anomaly metric %q", name)
+		}
+	}
+	return &AnomalyDetector{
+		metrics:      metrics,
+		alpha:        alpha,
+		threshold:    threshold,
+		minDeviation: minDeviation,
+		warmup:       warmup,
+		baselines:    make(map[string]*baseline),
+		logger:       logger,
+	}, nil
+}
+
+// Evaluate scores the latest period of each site in metrics against its baseline
+// before adding it, returning an event for every value that became or stopped
+// being anomalous
+func (d *AnomalyDetector) Evaluate(metrics *ISPMetrics, sites map[string]Metric) []AnomalyEvent {
+	var events []AnomalyEvent
+
+	for _, data := range metrics.Data {
+		site, ok := sites[data.SiteId]
+		if !ok {
+			continue
+		}
+
+		for _, name := range d.metrics {
+			value, _ := lookupWANValue(site.WAN, name)
+			key := site.SiteId + "/" + name
+			b, ok := d.baselines[key]
+			if !ok {
+				b = &baseline{}
+				d.baselines[key] = b
+			}
+
+			// WAN values are integers, so a steady value still varies by one unit
+			mean, stdDev := b.mean, math.Sqrt(b.variance)
+			z := (float64(value) - mean) / max(stdDev, 1)
+			deviation := math.Abs(float64(value) - mean)
+			anomalous := b.samples >= d.warmup &&
+				math.Abs(z) >= d.threshold &&
+				deviation >= math.Abs(mean)*d.minDeviation/100
+			b.update(float64(value), d.alpha)
+
+			if anomalous == b.anomalous {
+				continue
+			}
+			b.anomalous = anomalous
+
+			event := AnomalyEvent{
+				SiteId:      site.SiteId,
+				HostId:      site.HostId,
+				Metric:      name,
+				State:       anomalyNormal,
+				Value:       value,
+				Baseline:    math.Round(mean*100) / 100,
+				StdDev:      math.Round(stdDev*100) / 100,
+				ZScore:      math.Round(z*100) / 100,
+				Timestamp:   site.Timestamp,
+				Labels:      site.Labels,
+				PublishedAt: time.Now(),
+			}
+
+			entry := d.logger.WithFields(logrus.Fields{
+				"siteId":   site.SiteId,
+				"metric":   name,
+				"value":    value,
+				"baseline": event.Baseline,
+				"zScore":   event.ZScore,
+			})
+			if anomalous {
+				event.State = anomalyAnomalous
+				entry.Warn("WAN value deviates from its baseline")
+			} else {
+				entry.Info("WAN value back within its baseline")
+			}
+
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// PublishAnomaly publishes a retained anomaly event to baseTopic/anomalies/<siteId>/<metric>,
+// so subscribers see whether every value is currently anomalous
+func (p *MQTTPublisher) PublishAnomaly(event AnomalyEvent, baseTopic string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal anomaly: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/anomalies/%s/%s", baseTopic, event.SiteId, event.Metric)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic": topic,
+		"state": event.State,
+	}).Debug("Publishing anomaly to MQTT")
+
+	if err := p.send(topic, 1, true, payload); err != nil {
+		return fmt.Errorf("failed to publish anomaly to MQTT: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy configures exponential backoff for transient API failures
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// backoff returns the delay before retry number attempt (starting at 0), using
+// exponential backoff with full jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << attempt
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay) + 1
+}
+
+// proxyFunc returns the proxy of requests for the proxy URL given by option, or the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables when it is empty
+func proxyFunc(option, proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxy, err := url.Parse(proxyURL)
+	if err != nil || proxy.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q, expected a URL such as http://proxy:3128", option, proxyURL)
+	}
+	return http.ProxyURL(proxy), nil
+}
+
+// newAPITransport returns the transport of the Ubiquiti API and local controller
+// clients, with the --api-* dial, TLS handshake, keep-alive and idle connection options
+func newAPITransport(cli *CLI, tlsConfig *tls.Config) (*http.Transport, error) {
+	for option, value := range map[string]time.Duration{
+		"--api-timeout":               cli.ApiTimeout,
+		"--api-dial-timeout":          cli.ApiDialTimeout,
+		"--api-tls-handshake-timeout": cli.ApiTLSHandshakeTimeout,
+		"--api-keep-alive":            cli.ApiKeepAlive,
+		"--api-idle-conn-timeout":     cli.ApiIdleConnTimeout,
+	} {
+		if value < 0 {
+			return nil, fmt.Errorf("%s must not be negative", option)
+		}
+	}
+	if cli.ApiMaxIdleConns < 0 {
+		return nil, fmt.Errorf("--api-max-idle-conns must not be negative")
+	}
+
+	// A negative keep-alive disables the probes, zero would enable the default
+	keepAlive := cli.ApiKeepAlive
+	if keepAlive == 0 {
+		keepAlive = -1
+	}
+	dialer := &net.Dialer{Timeout: cli.ApiDialTimeout, KeepAlive: keepAlive}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSClientConfig = tlsConfig
+	transport.TLSHandshakeTimeout = cli.ApiTLSHandshakeTimeout
+	transport.MaxIdleConnsPerHost = cli.ApiMaxIdleConns
+	transport.IdleConnTimeout = cli.ApiIdleConnTimeout
+	transport.DisableKeepAlives = cli.ApiMaxIdleConns == 0
+	return transport, nil
+}
+
+// APIStatusError is returned when the API responds with a non-200 status
+type APIStatusError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the parsed Retry-After header, zero when absent
+	RetryAfter time.Duration
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// rateLimitDelay reports whether err is a 429 response and how long to back off,
+// using fallback when the response did not include Retry-After
+func rateLimitDelay(err error, fallback time.Duration) (time.Duration, bool) {
+	var statusErr *APIStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter, true
+	}
+	return fallback, true
+}
+
+// isRetryable reports whether err is a transient failure worth retrying
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *APIStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	// A body that is not the expected JSON will not fix itself on retry
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+
+	// Anything else from the transport (timeouts, resets, DNS) is transient
+	return true
+}
+
+// getJSON performs an authenticated GET request and decodes the JSON response into out,
+// retrying transient failures according to the client's retry policy
+func (c *UbiquitiClient) getJSON(ctx context.Context, url string, out interface{}) (err error) {
+	ctx, span := tracer.Start(ctx, "GET", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", url)))
+	defer func() { endSpan(span, err) }()
+
+	for attempt := 0; ; attempt++ {
+		err = c.getJSONOnce(ctx, url, out)
+		if err == nil || attempt >= c.retry.MaxRetries || !isRetryable(ctx, err) {
+			return err
+		}
+
+		delay := c.retry.backoff(attempt)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("error", err.Error()),
+		))
+		c.logger.WithError(err).WithFields(logrus.Fields{
+			"url":     url,
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Warn("API request failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// getJSONOnce performs a single authenticated GET request
+func (c *UbiquitiClient) getJSONOnce(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("X-API-KEY", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	cacheKey := c.apiKey + " " + url
+	c.cache.prepare(cacheKey, req)
+
+	c.logger.WithField("url", url).Debug("Making API request")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return fmt.Errorf("failed to make request, use --api-tls-ca-file behind a TLS-intercepting proxy: %w", err)
+		}
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	var body []byte
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		c.recorder.Record(url, body)
+		c.cache.store(cacheKey, resp, body)
+	case http.StatusNotModified:
+		cached, ok := c.cache.body(cacheKey)
+		if !ok {
+			return fmt.Errorf("API responded 304 Not Modified to a request that was not conditional")
+		}
+		c.logger.WithField("url", url).Debug("API response not modified, using the cached response")
+		body = cached
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return &APIStatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
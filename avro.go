@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// avroWANSchema is the Avro schema of the Kafka WAN messages, with the field names
+// of the JSON payload
+const avroWANSchema = `{
+  "type": "record",
+  "name": "WANMetric",
+  "namespace": "ubipoller.v1",
+  "fields": [
+    {"name": "siteId", "type": "string"},
+    {"name": "hostId", "type": "string"},
+    {"name": "timestamp", "type": "string"},
+    {"name": "avgLatency", "type": "long"},
+    {"name": "maxLatency", "type": "long"},
+    {"name": "downloadKbps", "type": "long"},
+    {"name": "uploadKbps", "type": "long"},
+    {"name": "packetLoss", "type": "long"},
+    {"name": "uptime", "type": "long"},
+    {"name": "downtime", "type": "long"},
+    {"name": "ispName", "type": "string"},
+    {"name": "ispAsn", "type": "string"},
+    {"name": "labels", "type": {"type": "map", "values": "string"}, "default": {}},
+    {"name": "publishedAt", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "metricType", "type": "string", "default": ""}
+  ]
+}`
+
+// encodeAvroWAN encodes m in the binary encoding of avroWANSchema
+func encodeAvroWAN(m WANMetric) []byte {
+	var b []byte
+	b = appendAvroString(b, m.SiteId)
+	b = appendAvroString(b, m.HostId)
+	b = appendAvroString(b, m.Timestamp)
+	for _, v := range []int{m.AvgLatency, m.MaxLatency, m.DownloadKbps, m.UploadKbps, m.PacketLoss, m.Uptime, m.Downtime} {
+		b = appendAvroLong(b, int64(v))
+	}
+	b = appendAvroString(b, m.ISPName)
+	b = appendAvroString(b, m.ISPAsn)
+
+	// A map is a block of count entries terminated by an empty block
+	if len(m.Labels) > 0 {
+		keys := make([]string, 0, len(m.Labels))
+		for k := range m.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendAvroLong(b, int64(len(keys)))
+		for _, k := range keys {
+			b = appendAvroString(b, k)
+			b = appendAvroString(b, m.Labels[k])
+		}
+	}
+	b = appendAvroLong(b, 0)
+
+	b = appendAvroLong(b, m.PublishedAt.UnixMilli())
+	return appendAvroString(b, m.MetricType)
+}
+
+// appendAvroLong appends a zig-zag varint
+func appendAvroLong(b []byte, v int64) []byte {
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+}
+
+// appendAvroString appends a length-prefixed UTF-8 string
+func appendAvroString(b []byte, s string) []byte {
+	b = appendAvroLong(b, int64(len(s)))
+	return append(b, s...)
+}
+
+// confluentFrame prefixes an encoded value with the Confluent wire format header:
+// a zero magic byte and the big-endian schema ID
+func confluentFrame(schemaID int, value []byte) []byte {
+	out := make([]byte, 5, 5+len(value))
+	binary.BigEndian.PutUint32(out[1:], uint32(schemaID))
+	return append(out, value...)
+}
+
+// SchemaRegistry resolves the ID of the WAN schema in a Confluent Schema Registry.
+// The ID is looked up on first use and cached, and a failed lookup is retried on
+// the next publish.
+type SchemaRegistry struct {
+	url        string
+	subject    string
+	username   string
+	password   string
+	lookupOnly bool
+	httpClient *http.Client
+
+	mu sync.Mutex
+	id int
+}
+
+// NewSchemaRegistry creates a registry client for --kafka-schema-registry-url
+func NewSchemaRegistry(cli *CLI) (*SchemaRegistry, error) {
+	if cli.KafkaSchemaRegistryURL == "" {
+		return nil, fmt.Errorf("schema registry url is required for avro payloads")
+	}
+	subject := cli.KafkaSchemaRegistrySubject
+	if subject == "" {
+		// TopicNameStrategy, the serializers' default
+		subject = cli.KafkaTopic + "-value"
+	}
+	return &SchemaRegistry{
+		url:        strings.TrimSuffix(cli.KafkaSchemaRegistryURL, "/"),
+		subject:    subject,
+		username:   cli.KafkaSchemaRegistryUsername,
+		password:   cli.KafkaSchemaRegistryPassword,
+		lookupOnly: cli.KafkaSchemaRegistryLookupOnly,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// SchemaID returns the ID of the WAN schema under the subject, registering it
+// unless --kafka-schema-registry-lookup-only is set
+func (r *SchemaRegistry) SchemaID(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.id != 0 {
+		return r.id, nil
+	}
+
+	// Looking up first also works with registries that forbid client registration
+	path := "/subjects/" + url.PathEscape(r.subject)
+	id, status, err := r.post(ctx, path)
+	if status == http.StatusNotFound && !r.lookupOnly {
+		id, _, err = r.post(ctx, path+"/versions")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve schema of subject %s: %w", r.subject, err)
+	}
+	r.id = id
+	return id, nil
+}
+
+// post sends the WAN schema to path and returns the schema ID of the response
+func (r *SchemaRegistry) post(ctx context.Context, path string) (int, int, error) {
+	body, err := json.Marshal(map[string]string{
+		"schemaType": "AVRO",
+		"schema":     avroWANSchema,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reach schema registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, resp.StatusCode, fmt.Errorf("schema registry request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, resp.StatusCode, fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	if result.ID == 0 {
+		return 0, resp.StatusCode, fmt.Errorf("schema registry response has no schema id")
+	}
+	return result.ID, resp.StatusCode, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// AWS IoT Core limits, see https://docs.aws.amazon.com/general/latest/gr/iot-core.html
+const (
+	awsIoTMaxClientID    = 128
+	awsIoTMaxTopicBytes  = 256
+	awsIoTMaxTopicLevels = 8
+)
+
+// awsIoTALPN is the ALPN protocol AWS IoT Core requires for MQTT with client
+// certificates on port 443
+const awsIoTALPN = "x-amzn-mqtt-ca"
+
+// checkAWSIoTConfig reports MQTT options AWS IoT Core does not accept
+func checkAWSIoTConfig(cli *CLI) error {
+	for _, broker := range cli.MqttBroker {
+		if !brokerUsesTLS(broker) || strings.HasPrefix(strings.ToLower(broker), "wss://") {
+			return fmt.Errorf("AWS IoT Core requires a tls:// broker URL, e.g. tls://<prefix>-ats.iot.<region>.amazonaws.com:8883")
+		}
+	}
+	if cli.MqttTLSCertFile == "" || cli.MqttTLSKeyFile == "" {
+		return fmt.Errorf("AWS IoT Core requires a client certificate (--mqtt-tls-cert-file and --mqtt-tls-key-file)")
+	}
+	if cli.MqttQoS > 1 {
+		return fmt.Errorf("AWS IoT Core does not support QoS 2, use --mqtt-qos 0 or 1")
+	}
+	if cli.MqttClientID == "" || len(cli.MqttClientID) > awsIoTMaxClientID {
+		return fmt.Errorf("AWS IoT Core requires a client ID of 1 to %d bytes", awsIoTMaxClientID)
+	}
+	if strings.HasPrefix(cli.MqttClientID, "$") {
+		return fmt.Errorf("AWS IoT Core client IDs must not start with $")
+	}
+	return nil
+}
+
+// checkAWSIoTTopic reports topics AWS IoT Core would reject
+func checkAWSIoTTopic(topic string) error {
+	if len(topic) > awsIoTMaxTopicBytes {
+		return fmt.Errorf("topic %q exceeds the AWS IoT Core limit of %d bytes", topic, awsIoTMaxTopicBytes)
+	}
+	if levels := strings.Count(topic, "/") + 1; levels > awsIoTMaxTopicLevels {
+		return fmt.Errorf("topic %q has %d levels, AWS IoT Core allows at most %d", topic, levels, awsIoTMaxTopicLevels)
+	}
+	if strings.HasPrefix(topic, "$") {
+		return fmt.Errorf("topic %q is reserved by AWS IoT Core", topic)
+	}
+	return nil
+}
+
+// brokerPort returns the port of the broker URL, or "" if it has none
+func brokerPort(broker string) string {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return ""
+	}
+	return u.Port()
+}
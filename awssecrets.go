@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// ssmPrefix marks a secret option referencing an SSM parameter by name in the default region
+const ssmPrefix = "ssm:"
+
+// isAWSSecretRef reports whether a secret option references an AWS Secrets Manager
+// secret or SSM parameter instead of holding the secret
+func isAWSSecretRef(value string) bool {
+	return strings.HasPrefix(value, "arn:") || strings.HasPrefix(value, ssmPrefix)
+}
+
+// resolveAWSSecrets replaces the secret options given as arn: or ssm: references with
+// the secret they reference, using the credentials and region of the AWS SDK
+// configuration (environment, shared config, or the EC2 and ECS instance roles)
+func (cli *CLI) resolveAWSSecrets(ctx context.Context) error {
+	options := cli.secretOptions()
+	var names []string
+	for name, value := range options {
+		if isAWSSecretRef(*value) {
+			names = append(names, name)
+		}
+	}
+	var accounts []string
+	for name, key := range cli.Accounts {
+		if isAWSSecretRef(key) {
+			accounts = append(accounts, name)
+		}
+	}
+	if len(names) == 0 && len(accounts) == 0 {
+		return nil
+	}
+	slices.Sort(names)
+	slices.Sort(accounts)
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	secrets := secretsmanager.NewFromConfig(cfg)
+	parameters := ssm.NewFromConfig(cfg)
+
+	for _, name := range names {
+		value, err := resolveAWSSecret(ctx, secrets, parameters, *options[name])
+		if err != nil {
+			return fmt.Errorf("--%s: %w", name, err)
+		}
+		*options[name] = value
+	}
+	for _, name := range accounts {
+		value, err := resolveAWSSecret(ctx, secrets, parameters, cli.Accounts[name])
+		if err != nil {
+			return fmt.Errorf("--accounts %s: %w", name, err)
+		}
+		cli.Accounts[name] = value
+	}
+	return nil
+}
+
+// resolveAWSSecret fetches the secret referenced by ref: an SSM parameter as ssm:<name>
+// or by ARN, or a Secrets Manager secret by ARN, optionally followed by #<key> to pick
+// a key of a JSON secret. SecureString parameters are decrypted.
+func resolveAWSSecret(ctx context.Context, secrets *secretsmanager.Client, parameters *ssm.Client, ref string) (string, error) {
+	if name, ok := strings.CutPrefix(ref, ssmPrefix); ok {
+		return getParameter(ctx, parameters, name, "")
+	}
+
+	ref, key, _ := strings.Cut(ref, "#")
+	parsed, err := arn.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid ARN %q: %w", ref, err)
+	}
+
+	var value string
+	switch parsed.Service {
+	case "ssm":
+		if key != "" {
+			return "", fmt.Errorf("#%s is only supported for Secrets Manager secrets", key)
+		}
+		return getParameter(ctx, parameters, ref, parsed.Region)
+	case "secretsmanager":
+		out, err := secrets.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(ref)},
+			func(o *secretsmanager.Options) { o.Region = parsed.Region })
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %s: %w", ref, err)
+		}
+		value = aws.ToString(out.SecretString)
+	default:
+		return "", fmt.Errorf("unsupported ARN service %q, expected secretsmanager or ssm", parsed.Service)
+	}
+
+	if key != "" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &fields); err != nil {
+			return "", fmt.Errorf("secret %s is not a JSON object: %w", ref, err)
+		}
+		field, ok := fields[key].(string)
+		if !ok {
+			return "", fmt.Errorf("secret %s has no string key %q", ref, key)
+		}
+		value = field
+	}
+	if value == "" {
+		return "", fmt.Errorf("secret %s is empty", ref)
+	}
+	return value, nil
+}
+
+// getParameter fetches and decrypts the SSM parameter name, in region when not empty
+func getParameter(ctx context.Context, parameters *ssm.Client, name, region string) (string, error) {
+	out, err := parameters.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)},
+		func(o *ssm.Options) {
+			if region != "" {
+				o.Region = region
+			}
+		})
+	if err != nil {
+		return "", fmt.Errorf("failed to get parameter %s: %w", name, err)
+	}
+	value := aws.ToString(out.Parameter.Value)
+	if value == "" {
+		return "", fmt.Errorf("parameter %s is empty", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BackfillCmd publishes every historical period within a time range and exits
+type BackfillCmd struct {
+	CLI `kong:"embed"`
+
+	From  time.Time     `kong:"required,help='Start of the range to backfill (RFC 3339, e.g. 2025-09-01T00:00:00Z)'"`
+	To    time.Time     `kong:"help='End of the range to backfill (RFC 3339), defaults to now'"`
+	Chunk time.Duration `kong:"default='24h',help='Time range requested from the API per call'"`
+}
+
+// Run walks the API over the requested range and publishes all periods to the sinks
+func (c *BackfillCmd) Run() error {
+	logger := newLogger(c.LogLevel, c.LogFormat)
+
+	flushTraces, err := initTracing(&c.CLI, logger)
+	if err != nil {
+		return err
+	}
+	defer flushTraces()
+
+	to := c.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if !c.From.Before(to) {
+		return fmt.Errorf("--from must be before --to")
+	}
+	if c.Chunk <= 0 {
+		return fmt.Errorf("--chunk must be positive")
+	}
+
+	// Historical periods are older than the watermarks of the running poller, so the
+	// state file is neither used to filter them nor advanced by them
+	c.StateFile = ""
+	c.exits = true
+
+	app, err := NewApp(&c.CLI, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create application: %w", err)
+	}
+	defer app.close()
+
+	ctx := shutdownContext(logger)
+	if err := app.Backfill(ctx, c.From, to, c.Chunk); err != nil {
+		return err
+	}
+
+	logger.Info("Backfill complete")
+	return nil
+}
+
+// Backfill fetches the range [from, to) in chunks and publishes every period, oldest
+// first, one metric type after the other
+func (a *App) Backfill(ctx context.Context, from, to time.Time, chunk time.Duration) error {
+	for _, metricType := range a.cli.MetricTypes {
+		if err := a.backfillMetricType(ctx, metricType, from, to, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillMetricType backfills the periods of metricType
+func (a *App) backfillMetricType(ctx context.Context, metricType string, from, to time.Time, chunk time.Duration) error {
+	a.logger.WithFields(logrus.Fields{
+		"from":        from,
+		"to":          to,
+		"metric_type": metricType,
+		"sinks":       a.cli.Sinks,
+	}).Info("Starting backfill")
+
+	total := 0
+	for begin := from; begin.Before(to); {
+		end := begin.Add(chunk)
+		if end.After(to) {
+			end = to
+		}
+
+		metrics, err := fetchAccounts(ctx, a.accounts, metricType, begin, end)
+		if err != nil {
+			delay, ok := rateLimitDelay(err, a.cli.RateLimitBackoff)
+			if !ok {
+				return fmt.Errorf("failed to fetch ISP metrics from %s to %s: %w", begin.Format(time.RFC3339), end.Format(time.RFC3339), err)
+			}
+
+			// Retry the same chunk once the rate limit has passed
+			a.logger.WithField("retry_after", delay).Warn("Rate limited by Ubiquiti API, backing off")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		a.siteFilter.Apply(metrics)
+
+		summary := &CycleSummary{}
+		siteMetrics := a.extractMetrics(metrics, true)
+		a.enrichMetrics(ctx, siteMetrics)
+		a.publishMetrics(ctx, siteMetrics, summary)
+		if summary.Errors > 0 {
+			return fmt.Errorf("failed to publish %d periods from %s to %s", summary.Errors, begin.Format(time.RFC3339), end.Format(time.RFC3339))
+		}
+
+		published := len(siteMetrics) - summary.PeriodsDeduplicated
+		total += published
+		a.logger.WithFields(logrus.Fields{
+			"from":    begin,
+			"to":      end,
+			"periods": published,
+		}).Info("Backfilled range")
+
+		begin = end
+	}
+
+	a.logger.WithFields(logrus.Fields{
+		"metric_type": metricType,
+		"periods":     total,
+	}).Info("Backfilled all periods")
+	return nil
+}
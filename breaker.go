@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// APIStatusEvent reports the state of the API circuit breaker
+type APIStatusEvent struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	RetryAt             *time.Time `json:"retryAt,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	Timestamp           time.Time  `json:"timestamp"`
+}
+
+// CircuitBreaker stops polling the API for a cooldown after consecutive failures.
+// Once the cooldown has elapsed a single trial poll decides whether it closes again.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+}
+
+// NewCircuitBreaker creates a breaker opening after threshold consecutive failures.
+// A threshold of 0 disables it.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Success records a successful poll and reports whether the breaker was open
+func (b *CircuitBreaker) Success() bool {
+	wasOpen := b.open()
+	b.failures = 0
+	return wasOpen
+}
+
+// Failure records a failed poll and reports whether the breaker is now open
+func (b *CircuitBreaker) Failure() bool {
+	b.failures++
+	return b.open()
+}
+
+// open reports whether enough consecutive failures have been seen to open the breaker
+func (b *CircuitBreaker) open() bool {
+	return b.threshold > 0 && b.failures >= b.threshold
+}
+
+// PublishAPIStatus publishes a retained circuit breaker event to baseTopic/api-status
+func (p *MQTTPublisher) PublishAPIStatus(event APIStatusEvent, baseTopic string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal API status: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/api-status", baseTopic)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic": topic,
+		"state": event.State,
+	}).Debug("Publishing API status to MQTT")
+
+	if err := p.send(topic, 1, true, payload); err != nil {
+		return fmt.Errorf("failed to publish API status to MQTT: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errQueueFull is returned by MetricQueue.Push when the queue holds its maximum
+var errQueueFull = errors.New("publish buffer full")
+
+// MetricQueue is a FIFO of metrics waiting to be published
+type MetricQueue interface {
+	// Push appends m, returning errQueueFull if the queue is full
+	Push(m Metric) error
+	// Peek returns the oldest metric without removing it
+	Peek() (Metric, bool)
+	// Pop removes the oldest metric
+	Pop() error
+	Len() int
+}
+
+// memoryQueue is a bounded in-memory MetricQueue
+type memoryQueue struct {
+	metrics []Metric
+	max     int
+}
+
+// newMemoryQueue creates an in-memory queue holding up to max metrics
+func newMemoryQueue(max int) *memoryQueue {
+	return &memoryQueue{max: max}
+}
+
+// Push implements MetricQueue
+func (q *memoryQueue) Push(m Metric) error {
+	if len(q.metrics) >= q.max {
+		return errQueueFull
+	}
+	q.metrics = append(q.metrics, m)
+	return nil
+}
+
+// Peek implements MetricQueue
+func (q *memoryQueue) Peek() (Metric, bool) {
+	if len(q.metrics) == 0 {
+		return Metric{}, false
+	}
+	return q.metrics[0], true
+}
+
+// Pop implements MetricQueue
+func (q *memoryQueue) Pop() error {
+	if len(q.metrics) > 0 {
+		q.metrics[0] = Metric{}
+		q.metrics = q.metrics[1:]
+	}
+	return nil
+}
+
+// Len implements MetricQueue
+func (q *memoryQueue) Len() int {
+	return len(q.metrics)
+}
+
+// rejected reports whether err is a rejection the sink repeats whenever the same
+// metric is published again
+func rejected(err error) bool {
+	var r interface{ retryable() bool }
+	return errors.As(err, &r) && !r.retryable()
+}
+
+// BufferedSink queues metrics its sink failed to publish and replays them, in
+// order, before anything newer once the sink accepts publishes again
+type BufferedSink struct {
+	sink   Sink
+	queue  MetricQueue
+	logger *logrus.Logger
+}
+
+// NewBufferedSink wraps sink with queue
+func NewBufferedSink(sink Sink, queue MetricQueue, logger *logrus.Logger) *BufferedSink {
+	return &BufferedSink{
+		sink:   sink,
+		queue:  queue,
+		logger: logger,
+	}
+}
+
+// Name implements Sink
+func (b *BufferedSink) Name() string {
+	return b.sink.Name()
+}
+
+// Publish implements Sink. Every metric is queued first and then published from the
+// queue, so a metric that cannot be published stays queued and counts as accepted.
+// An error is only returned when the metric could not be queued.
+func (b *BufferedSink) Publish(ctx context.Context, metric Metric) error {
+	backlog := b.queue.Len()
+
+	err := b.queue.Push(metric)
+	if errors.Is(err, errQueueFull) {
+		// Make room by replaying the backlog, then queue again
+		if flushErr := b.flush(ctx, backlog); flushErr != nil {
+			return fmt.Errorf("%w, dropping metric: %w", errQueueFull, flushErr)
+		}
+		backlog = 0
+		err = b.queue.Push(metric)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to queue metric: %w", err)
+	}
+
+	if err := b.flush(ctx, backlog); err != nil {
+		b.logger.WithError(err).WithFields(logrus.Fields{
+			"sink":   b.sink.Name(),
+			"siteId": metric.SiteId,
+			"queued": b.queue.Len(),
+		}).Warn("Failed to publish metric, buffering for replay")
+	}
+	return nil
+}
+
+// PublishConcurrent implements ConcurrentSink. Once the backlog is replayed, metrics
+// are published concurrently when the sink supports it and those that fail are queued
+// in their original order. While a backlog remains, every metric is queued behind it
+// as by Publish. A disk queue always queues every metric before it is published, so
+// a crash while publishing cannot lose it.
+func (b *BufferedSink) PublishConcurrent(ctx context.Context, metrics []Metric) []error {
+	errs := make([]error, len(metrics))
+
+	concurrent, ok := b.sink.(ConcurrentSink)
+	_, durable := b.queue.(*diskQueue)
+	if !ok || durable || (b.queue.Len() > 0 && b.flush(ctx, b.queue.Len()) != nil) {
+		for i, metric := range metrics {
+			errs[i] = b.Publish(ctx, metric)
+		}
+		return errs
+	}
+
+	for i, err := range concurrent.PublishConcurrent(ctx, metrics) {
+		if err == nil {
+			continue
+		}
+		if rejected(err) {
+			b.logger.WithError(err).WithFields(logrus.Fields{
+				"sink":   b.sink.Name(),
+				"siteId": metrics[i].SiteId,
+			}).Error("Metric rejected, dropping it")
+			continue
+		}
+		if pushErr := b.queue.Push(metrics[i]); pushErr != nil {
+			errs[i] = fmt.Errorf("failed to queue metric: %w", pushErr)
+			continue
+		}
+		b.logger.WithError(err).WithFields(logrus.Fields{
+			"sink":   b.sink.Name(),
+			"siteId": metrics[i].SiteId,
+			"queued": b.queue.Len(),
+		}).Warn("Failed to publish metric, buffering for replay")
+	}
+	return errs
+}
+
+// flush publishes queued metrics in order until the queue is empty or a publish
+// fails. backlog is the number of metrics queued by earlier failed publishes.
+func (b *BufferedSink) flush(ctx context.Context, backlog int) error {
+	replayed := 0
+	defer func() {
+		if backlog > 0 && replayed > 0 {
+			b.logger.WithFields(logrus.Fields{
+				"sink":     b.sink.Name(),
+				"replayed": min(replayed, backlog),
+				"queued":   b.queue.Len(),
+			}).Info("Replayed buffered metrics")
+		}
+	}()
+
+	for {
+		metric, ok := b.queue.Peek()
+		if !ok {
+			return nil
+		}
+		if err := b.sink.Publish(ctx, metric); err != nil {
+			if !rejected(err) {
+				return err
+			}
+			// Replaying a metric the sink rejects would block the queue forever
+			b.logger.WithError(err).WithFields(logrus.Fields{
+				"sink":   b.sink.Name(),
+				"siteId": metric.SiteId,
+			}).Error("Metric rejected, dropping it from the publish buffer")
+		}
+		// Removed only once published, so a crash in between replays it again
+		if err := b.queue.Pop(); err != nil {
+			return fmt.Errorf("failed to remove published metric from queue: %w", err)
+		}
+		replayed++
+	}
+}
+
+// Close implements Sink
+func (b *BufferedSink) Close() error {
+	n := b.queue.Len()
+	if closer, ok := b.queue.(io.Closer); ok {
+		if n > 0 {
+			b.logger.WithFields(logrus.Fields{
+				"sink":   b.sink.Name(),
+				"queued": n,
+			}).Info("Keeping buffered metrics for the next start")
+		}
+		if err := closer.Close(); err != nil {
+			b.logger.WithError(err).Warn("Failed to close publish queue")
+		}
+	} else if n > 0 {
+		b.logger.WithFields(logrus.Fields{
+			"sink":   b.sink.Name(),
+			"queued": n,
+		}).Warn("Discarding buffered metrics on shutdown")
+	}
+	return b.sink.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/sirupsen/logrus"
+)
+
+// cloudwatchMaxDatums is the number of datums PutMetricData accepts per request
+const cloudwatchMaxDatums = 1000
+
+// cloudwatchUnits maps the WAN values to their CloudWatch units
+var cloudwatchUnits = map[string]types.StandardUnit{
+	"avg_latency_ms": types.StandardUnitMilliseconds,
+	"max_latency_ms": types.StandardUnitMilliseconds,
+	"download_kbps":  types.StandardUnitKilobitsSecond,
+	"upload_kbps":    types.StandardUnitKilobitsSecond,
+	"packet_loss":    types.StandardUnitPercent,
+}
+
+// CloudWatchWriter is a sink putting WAN metrics into CloudWatch as custom metrics,
+// one per WAN value, with the site, host and ISP as dimensions. Credentials and the
+// region come from the default AWS SDK chain (environment, shared config, IAM role).
+type CloudWatchWriter struct {
+	client     *cloudwatch.Client
+	namespace  string
+	dimensions []string
+	logger     *logrus.Logger
+}
+
+// NewCloudWatchWriter creates a writer putting metrics into --cloudwatch-namespace
+func NewCloudWatchWriter(ctx context.Context, cli *CLI, logger *logrus.Logger) (*CloudWatchWriter, error) {
+	if cli.CloudwatchNamespace == "" {
+		return nil, fmt.Errorf("cloudwatch namespace is required")
+	}
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if cli.CloudwatchRegion != "" {
+		opts = append(opts, awsconfig.WithRegion(cli.CloudwatchRegion))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured, set --cloudwatch-region or AWS_REGION")
+	}
+
+	client := cloudwatch.NewFromConfig(cfg, func(o *cloudwatch.Options) {
+		if cli.CloudwatchEndpoint != "" {
+			o.BaseEndpoint = aws.String(cli.CloudwatchEndpoint)
+		}
+	})
+
+	return &CloudWatchWriter{
+		client:     client,
+		namespace:  cli.CloudwatchNamespace,
+		dimensions: cli.CloudwatchDimensions,
+		logger:     logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *CloudWatchWriter) Name() string {
+	return "cloudwatch"
+}
+
+// Publish implements Sink
+func (w *CloudWatchWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink, putting the datums of all metrics in as few
+// requests as possible. Datums are timestamped with the period's metricTime; note
+// that CloudWatch rejects timestamps older than two weeks.
+func (w *CloudWatchWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var datums []types.MetricDatum
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+
+		dimensions := w.metricDimensions(metric)
+		for _, v := range wanValues(metric.WAN) {
+			unit, ok := cloudwatchUnits[v.name]
+			if !ok {
+				unit = types.StandardUnitNone
+			}
+			datums = append(datums, types.MetricDatum{
+				MetricName: aws.String(v.name),
+				Dimensions: dimensions,
+				Timestamp:  aws.Time(metricTime),
+				Value:      aws.Float64(float64(v.value)),
+				Unit:       unit,
+			})
+		}
+	}
+
+	for start := 0; start < len(datums); start += cloudwatchMaxDatums {
+		chunk := datums[start:min(start+cloudwatchMaxDatums, len(datums))]
+
+		w.logger.WithField("datums", len(chunk)).Debug("Putting metric data to CloudWatch")
+
+		_, err := w.client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(w.namespace),
+			MetricData: chunk,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put metric data to CloudWatch: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close implements Sink
+func (w *CloudWatchWriter) Close() error {
+	return nil
+}
+
+// metricDimensions returns the configured dimensions of a metric. Dimensions without
+// a value are left out, as CloudWatch does not accept empty dimension values.
+func (w *CloudWatchWriter) metricDimensions(metric Metric) []types.Dimension {
+	var dimensions []types.Dimension
+	add := func(name, value string) {
+		if value != "" {
+			dimensions = append(dimensions, types.Dimension{Name: aws.String(name), Value: aws.String(value)})
+		}
+	}
+
+	for _, d := range w.dimensions {
+		switch d {
+		case "site":
+			add("SiteId", metric.SiteId)
+		case "site-name":
+			add("SiteName", metric.Labels["site_name"])
+		case "host":
+			add("HostId", metric.HostId)
+		case "isp":
+			add("ISPName", metric.WAN.ISPName)
+		case "metric-type":
+			add("MetricType", metric.MetricType)
+		}
+	}
+	return dimensions
+}
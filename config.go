@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	kongtoml "github.com/alecthomas/kong-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configLoader loads a YAML or TOML configuration file, chosen by file extension.
+// Keys are flag names (e.g. api-key, mqtt-broker), and may be nested by their
+// dash-separated prefix (mqtt: {broker: ...}).
+func configLoader(r io.Reader) (kong.Resolver, error) {
+	name := ""
+	if named, ok := r.(interface{ Name() string }); ok {
+		name = named.Name()
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".toml":
+		return kongtoml.Loader(r)
+	case ".yaml", ".yml", "":
+		return yamlLoader(r)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q (use .yaml, .yml or .toml)", filepath.Ext(name))
+	}
+}
+
+// yamlLoader resolves flags from a YAML document. Flags of a command are looked
+// up under the command's name first and then at the top level, so a flat file
+// works for the default run command.
+func yamlLoader(r io.Reader) (kong.Resolver, error) {
+	config := map[string]interface{}{}
+	if err := yaml.NewDecoder(r).Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("YAML config decode error: %w", err)
+	}
+
+	return kong.ResolverFunc(func(context *kong.Context, parent *kong.Path, flag *kong.Flag) (interface{}, error) {
+		var commandPath []string
+		for n := parent.Node(); n != nil && n.Type != kong.ApplicationNode; n = n.Parent {
+			commandPath = append([]string{n.Name}, commandPath...)
+		}
+
+		if len(commandPath) > 0 {
+			scoped := strings.Split(strings.Join(append(commandPath, flag.Name), "-"), "-")
+			if value := findConfigValue(config, scoped); value != nil {
+				return value, nil
+			}
+		}
+		return findConfigValue(config, strings.Split(flag.Name, "-")), nil
+	}), nil
+}
+
+// findConfigValue looks up a dash-separated flag path in config, allowing any
+// prefix of the path to be a nested mapping
+func findConfigValue(config map[string]interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return nil
+	}
+	if value, ok := config[strings.Join(path, "-")]; ok {
+		return value
+	}
+	for i := 1; i < len(path); i++ {
+		prefix := strings.Join(path[:i], "-")
+		if child, ok := config[prefix].(map[string]interface{}); ok {
+			if value := findConfigValue(child, path[i:]); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
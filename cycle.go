@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CycleSummary describes the outcome of a single fetch-and-publish cycle
+type CycleSummary struct {
+	MetricType          string    `json:"metricType"`
+	StartedAt           time.Time `json:"startedAt"`
+	CompletedAt         time.Time `json:"completedAt"`
+	DurationMs          int64     `json:"durationMs"`
+	APILatencyMs        int64     `json:"apiLatencyMs"`
+	SitesFetched        int       `json:"sitesFetched"`
+	SitesPublished      int       `json:"sitesPublished"`
+	SitesSkipped        int       `json:"sitesSkipped"`
+	PeriodsDeduplicated int       `json:"periodsDeduplicated"`
+	Errors              int       `json:"errors"`
+	Success             bool      `json:"success"`
+	Error               string    `json:"error,omitempty"`
+}
+
+// complete finalizes the summary once the cycle has finished with err
+func (s *CycleSummary) complete(err error) {
+	s.CompletedAt = time.Now()
+	s.DurationMs = s.CompletedAt.Sub(s.StartedAt).Milliseconds()
+	if err != nil {
+		s.Errors++
+		s.Error = err.Error()
+	}
+	s.Success = err == nil && s.Errors == 0
+}
+
+// PublishCycleSummary publishes the cycle summary to baseTopic/cycles
+func (p *MQTTPublisher) PublishCycleSummary(summary CycleSummary, baseTopic string) error {
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cycle summary: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/cycles", baseTopic)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":           topic,
+		"sites_published": summary.SitesPublished,
+		"errors":          summary.Errors,
+		"duration_ms":     summary.DurationMs,
+	}).Debug("Publishing cycle summary to MQTT")
+
+	if err := p.send(topic, 1, false, payload); err != nil {
+		return fmt.Errorf("failed to publish cycle summary to MQTT: %w", err)
+	}
+
+	return nil
+}
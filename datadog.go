@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// datadogGauge is the series type of a gauge in the v2 series API
+const datadogGauge = 3
+
+// datadogSeries is a single series of a v2 series API request
+type datadogSeries struct {
+	Metric string         `json:"metric"`
+	Type   int            `json:"type"`
+	Points []datadogPoint `json:"points"`
+	Tags   []string       `json:"tags"`
+}
+
+// datadogPoint is a single datapoint of a series
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// datadogLabelTag maps a resolver label onto a Datadog tag
+type datadogLabelTag struct {
+	label string
+	tag   string
+}
+
+// DatadogWriter is a sink submitting WAN metrics to the Datadog metrics API as gauges,
+// one per WAN value, tagged with the site, host, metric type and ISP
+type DatadogWriter struct {
+	seriesURL  string
+	apiKey     string
+	prefix     string
+	tags       []string
+	labelTags  []datadogLabelTag
+	httpClient *http.Client
+	logger     *logrus.Logger
+}
+
+// NewDatadogWriter creates a writer submitting to the API of --datadog-site
+func NewDatadogWriter(cli *CLI, logger *logrus.Logger) (*DatadogWriter, error) {
+	if cli.DatadogApiKey == "" {
+		return nil, fmt.Errorf("datadog api key is required")
+	}
+
+	baseURL := cli.DatadogURL
+	if baseURL == "" {
+		if cli.DatadogSite == "" {
+			return nil, fmt.Errorf("datadog site is required")
+		}
+		baseURL = "https://api." + cli.DatadogSite
+	}
+
+	var labelTags []datadogLabelTag
+	for _, mapping := range cli.DatadogLabelTags {
+		label, tag, ok := strings.Cut(mapping, "=")
+		if !ok {
+			tag = label
+		}
+		if label == "" || tag == "" {
+			return nil, fmt.Errorf("invalid datadog label tag %q, expected 'label' or 'label=tag'", mapping)
+		}
+		labelTags = append(labelTags, datadogLabelTag{label: label, tag: tag})
+	}
+
+	return &DatadogWriter{
+		seriesURL: strings.TrimSuffix(baseURL, "/") + "/api/v2/series",
+		apiKey:    cli.DatadogApiKey,
+		prefix:    strings.TrimSuffix(cli.DatadogPrefix, "."),
+		tags:      cli.DatadogTags,
+		labelTags: labelTags,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *DatadogWriter) Name() string {
+	return "datadog"
+}
+
+// Publish implements Sink
+func (w *DatadogWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by submitting the series of all metrics in one
+// request. Points are timestamped with the period's metricTime.
+func (w *DatadogWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var series []datadogSeries
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+
+		tags := w.metricTags(metric)
+		for _, v := range wanValues(metric.WAN) {
+			series = append(series, datadogSeries{
+				Metric: w.prefix + ".wan." + v.name,
+				Type:   datadogGauge,
+				Points: []datadogPoint{{Timestamp: metricTime.Unix(), Value: float64(v.value)}},
+				Tags:   tags,
+			})
+		}
+	}
+
+	if len(series) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string][]datadogSeries{"series": series})
+	if err != nil {
+		return fmt.Errorf("failed to marshal series: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.seriesURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("DD-API-KEY", w.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	w.logger.WithField("series", len(series)).Debug("Submitting series to Datadog")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to submit to Datadog: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("datadog submission failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Close implements Sink
+func (w *DatadogWriter) Close() error {
+	return nil
+}
+
+// metricTags returns the tags of a metric: the built-in site tags, the mapped labels
+// and the static --datadog-tags
+func (w *DatadogWriter) metricTags(metric Metric) []string {
+	tags := []string{
+		"site_id:" + metric.SiteId,
+		"host_id:" + metric.HostId,
+		"metric_type:" + metric.MetricType,
+	}
+	if metric.WAN.ISPName != "" {
+		tags = append(tags, "isp_name:"+metric.WAN.ISPName)
+	}
+	if metric.WAN.ISPAsn != "" {
+		tags = append(tags, "isp_asn:"+metric.WAN.ISPAsn)
+	}
+	for _, m := range w.labelTags {
+		if value := metric.Labels[m.label]; value != "" {
+			tags = append(tags, m.tag+":"+value)
+		}
+	}
+	return append(tags, w.tags...)
+}
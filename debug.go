@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DebugServer serves the net/http/pprof profiling endpoints
+type DebugServer struct {
+	server *http.Server
+}
+
+// NewDebugServer starts serving /debug/pprof/ on addr
+func NewDebugServer(addr string, logger *logrus.Logger) (*DebugServer, error) {
+	// Register on a dedicated mux so profiles are never exposed on the other listeners
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	d := &DebugServer{
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+
+	go func() {
+		if err := d.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Error("Debug server stopped")
+		}
+	}()
+
+	logger.WithField("addr", addr).Warn("Serving pprof debug endpoints")
+	return d, nil
+}
+
+// Close shuts down the HTTP server
+func (d *DebugServer) Close() error {
+	if d == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return d.server.Shutdown(ctx)
+}
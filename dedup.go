@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// PeriodDeduper remembers the last published metricTime of each site and metric type
+// so periods the API returns again on a later poll are not published twice. With a
+// state file the watermarks survive restarts.
+type PeriodDeduper struct {
+	last  map[string]string
+	path  string
+	dirty bool
+}
+
+// periodState is the content of the --state-file, keyed by periodKey
+type periodState struct {
+	LastPublished map[string]string `json:"lastPublished"`
+}
+
+// periodKey identifies the periods of a site's metric type
+func periodKey(m Metric) string {
+	return m.SiteId + "/" + m.MetricType
+}
+
+// NewPeriodDeduper creates a new period deduper, loading the watermarks saved in path
+// by a previous run unless path is empty. Watermarks saved before metric types were
+// told apart, keyed by the site alone, are taken to be of legacyMetricType.
+func NewPeriodDeduper(path, legacyMetricType string) (*PeriodDeduper, error) {
+	d := &PeriodDeduper{
+		last: make(map[string]string),
+		path: path,
+	}
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	var state periodState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	for key, metricTime := range state.LastPublished {
+		if !strings.Contains(key, "/") {
+			key += "/" + legacyMetricType
+		}
+		d.last[key] = metricTime
+	}
+	return d, nil
+}
+
+// Filter returns the metrics that are newer than the last period published for their
+// site and metric type
+func (d *PeriodDeduper) Filter(metrics []Metric) []Metric {
+	var fresh []Metric
+	for _, m := range metrics {
+		last, seen := d.last[periodKey(m)]
+		if seen && !metricTimeAfter(m.Timestamp, last) {
+			continue
+		}
+		fresh = append(fresh, m)
+	}
+	return fresh
+}
+
+// Mark records m as published
+func (d *PeriodDeduper) Mark(m Metric) {
+	key := periodKey(m)
+	if last, seen := d.last[key]; seen && !metricTimeAfter(m.Timestamp, last) {
+		return
+	}
+	d.last[key] = m.Timestamp
+	d.dirty = true
+}
+
+// Save atomically replaces the state file with the current watermarks when any
+// changed since the last save. It does nothing without a state file.
+func (d *PeriodDeduper) Save() error {
+	if d.path == "" || !d.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(periodState{LastPublished: d.last}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	d.dirty = false
+	return nil
+}
+
+// metricTimeAfter reports whether metricTime a is later than b. Times that are not
+// RFC 3339 are compared as strings.
+func metricTimeAfter(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errA != nil || errB != nil {
+		return a > b
+	}
+	return ta.After(tb)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+)
+
+// CounterMetric carries the uptime and downtime of a period alongside their
+// per-interval deltas
+type CounterMetric struct {
+	SiteId        string    `json:"siteId"`
+	HostId        string    `json:"hostId"`
+	Timestamp     string    `json:"timestamp"`
+	Uptime        int       `json:"uptime"`
+	Downtime      int       `json:"downtime"`
+	UptimeDelta   *int      `json:"uptimeDelta"`
+	DowntimeDelta *int      `json:"downtimeDelta"`
+	PublishedAt   time.Time `json:"publishedAt"`
+}
+
+// DeltaTracker computes per-interval deltas of the uptime and downtime fields
+type DeltaTracker struct{}
+
+// NewDeltaTracker creates a new delta tracker
+func NewDeltaTracker() *DeltaTracker {
+	return &DeltaTracker{}
+}
+
+// Evaluate computes counter metrics for the latest period of each site. The API
+// reports uptime and downtime as the seconds within each period rather than as
+// running totals, as the SLA, outage and rollup trackers sum them, so the values of
+// a period are its interval deltas.
+func (t *DeltaTracker) Evaluate(metrics *ISPMetrics) []CounterMetric {
+	var counterMetrics []CounterMetric
+
+	for _, data := range metrics.Data {
+		if len(data.Periods) == 0 {
+			continue
+		}
+
+		latestPeriod := data.Periods[0]
+		wan := latestPeriod.Data.WAN
+		uptimeDelta, downtimeDelta := wan.Uptime, wan.Downtime
+
+		counterMetrics = append(counterMetrics, CounterMetric{
+			SiteId:        data.SiteId,
+			HostId:        data.HostId,
+			Timestamp:     latestPeriod.MetricTime,
+			Uptime:        wan.Uptime,
+			Downtime:      wan.Downtime,
+			UptimeDelta:   &uptimeDelta,
+			DowntimeDelta: &downtimeDelta,
+			PublishedAt:   time.Now(),
+		})
+	}
+
+	return counterMetrics
+}
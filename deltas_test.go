@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// loadFixture decodes an embedded API response fixture
+func loadFixture(t *testing.T, name string) *ISPMetrics {
+	t.Helper()
+	raw, err := fixtures.ReadFile("fixtures/" + name)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var metrics ISPMetrics
+	if err := json.Unmarshal(raw, &metrics); err != nil {
+		t.Fatalf("failed to decode fixture: %v", err)
+	}
+	return &metrics
+}
+
+// withWAN returns a copy of metrics whose latest period has the given time and WAN
+// uptime and downtime
+func withWAN(metrics *ISPMetrics, metricTime string, uptime, downtime int) *ISPMetrics {
+	data := metrics.Data[0]
+	period := data.Periods[0]
+	period.MetricTime = metricTime
+	period.Data.WAN.Uptime, period.Data.WAN.Downtime = uptime, downtime
+	data.Periods = []Period{period}
+	return &ISPMetrics{Data: []MetricData{data}}
+}
+
+func TestDeltaTrackerOutage(t *testing.T) {
+	outage := loadFixture(t, "ea-5m-outage.json")
+
+	tests := []struct {
+		name                     string
+		polls                    []*ISPMetrics
+		wantUptime, wantDowntime int
+	}{
+		{
+			name:         "outage on the first poll",
+			polls:        []*ISPMetrics{outage},
+			wantUptime:   0,
+			wantDowntime: 300,
+		},
+		{
+			name: "continuing outage",
+			polls: []*ISPMetrics{
+				outage,
+				withWAN(outage, "2025-09-21T17:05:00Z", 0, 300),
+			},
+			wantUptime:   0,
+			wantDowntime: 300,
+		},
+		{
+			name: "recovery",
+			polls: []*ISPMetrics{
+				outage,
+				withWAN(outage, "2025-09-21T17:05:00Z", 300, 0),
+			},
+			wantUptime:   300,
+			wantDowntime: 0,
+		},
+		{
+			name: "partial outage",
+			polls: []*ISPMetrics{
+				withWAN(outage, "2025-09-21T16:55:00Z", 300, 0),
+				withWAN(outage, "2025-09-21T17:00:00Z", 120, 180),
+			},
+			wantUptime:   120,
+			wantDowntime: 180,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewDeltaTracker()
+			var counters []CounterMetric
+			for _, poll := range tt.polls {
+				counters = tracker.Evaluate(poll)
+			}
+			if len(counters) != 1 {
+				t.Fatalf("got %d counter metrics, want 1", len(counters))
+			}
+			got := counters[0]
+			if got.UptimeDelta == nil || got.DowntimeDelta == nil {
+				t.Fatalf("deltas are nil: %+v", got)
+			}
+			if *got.UptimeDelta != tt.wantUptime || *got.DowntimeDelta != tt.wantDowntime {
+				t.Errorf("got uptimeDelta %d, downtimeDelta %d, want %d, %d",
+					*got.UptimeDelta, *got.DowntimeDelta, tt.wantUptime, tt.wantDowntime)
+			}
+			if got.Uptime != tt.wantUptime || got.Downtime != tt.wantDowntime {
+				t.Errorf("got uptime %d, downtime %d, want %d, %d", got.Uptime, got.Downtime, tt.wantUptime, tt.wantDowntime)
+			}
+		})
+	}
+}
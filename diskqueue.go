@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// diskQueue is a bounded MetricQueue persisted to dir so queued metrics survive
+// restarts. Metrics are appended as JSON lines to queue.jsonl and queue.offset holds
+// the number of lines already published; both are reset once the queue drains.
+type diskQueue struct {
+	dataPath   string
+	offsetPath string
+	data       *os.File
+	offset     int
+	pending    []Metric
+	max        int
+}
+
+// newDiskQueue opens the queue in dir, loading metrics left by a previous run
+func newDiskQueue(dir string, max int) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	q := &diskQueue{
+		dataPath:   filepath.Join(dir, "queue.jsonl"),
+		offsetPath: filepath.Join(dir, "queue.offset"),
+		max:        max,
+	}
+
+	raw, err := os.ReadFile(q.offsetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read queue offset: %w", err)
+	}
+	if len(raw) > 0 {
+		q.offset, err = strconv.Atoi(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse queue offset: %w", err)
+		}
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	q.data, err = os.OpenFile(q.dataPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue file: %w", err)
+	}
+	return q, nil
+}
+
+// load reads the unpublished metrics from the data file
+func (q *diskQueue) load() error {
+	f, err := os.Open(q.dataPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open queue file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var intact int64
+	for line := 0; scanner.Scan(); line++ {
+		var m Metric
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// A torn final write from a crash; drop it so later appends stay readable
+			if err := os.Truncate(q.dataPath, intact); err != nil {
+				return fmt.Errorf("failed to repair queue file: %w", err)
+			}
+			break
+		}
+		intact += int64(len(scanner.Bytes())) + 1
+		if line >= q.offset {
+			q.pending = append(q.pending, m)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read queue file: %w", err)
+	}
+	return nil
+}
+
+// Push implements MetricQueue, syncing the metric to disk before returning
+func (q *diskQueue) Push(m Metric) error {
+	if len(q.pending) >= q.max {
+		return errQueueFull
+	}
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric: %w", err)
+	}
+	if _, err := q.data.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write queue file: %w", err)
+	}
+	if err := q.data.Sync(); err != nil {
+		return fmt.Errorf("failed to sync queue file: %w", err)
+	}
+
+	q.pending = append(q.pending, m)
+	return nil
+}
+
+// Peek implements MetricQueue
+func (q *diskQueue) Peek() (Metric, bool) {
+	if len(q.pending) == 0 {
+		return Metric{}, false
+	}
+	return q.pending[0], true
+}
+
+// Pop implements MetricQueue, persisting the new offset
+func (q *diskQueue) Pop() error {
+	if len(q.pending) == 0 {
+		return nil
+	}
+	q.pending[0] = Metric{}
+	q.pending = q.pending[1:]
+	q.offset++
+
+	if len(q.pending) == 0 {
+		return q.reset()
+	}
+	return q.writeOffset()
+}
+
+// Len implements MetricQueue
+func (q *diskQueue) Len() int {
+	return len(q.pending)
+}
+
+// Close closes the data file, leaving queued metrics for the next run
+func (q *diskQueue) Close() error {
+	return q.data.Close()
+}
+
+// reset truncates the drained data file so it does not grow forever. The offset is
+// reset first: a crash in between replays published metrics instead of skipping new ones.
+func (q *diskQueue) reset() error {
+	q.offset = 0
+	if err := q.writeOffset(); err != nil {
+		return err
+	}
+	if err := q.data.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate queue file: %w", err)
+	}
+	return nil
+}
+
+// writeOffset atomically replaces the offset file
+func (q *diskQueue) writeOffset() error {
+	tmp := q.offsetPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(q.offset)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write queue offset: %w", err)
+	}
+	if err := os.Rename(tmp, q.offsetPath); err != nil {
+		return fmt.Errorf("failed to write queue offset: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// queuedMetric returns a metric told apart by its timestamp
+func queuedMetric(i int) Metric {
+	return Metric{SiteId: "site", MetricType: "5m", Timestamp: strconv.Itoa(i)}
+}
+
+// openQueue opens the disk queue in dir, failing the test on error
+func openQueue(t *testing.T, dir string, max int) *diskQueue {
+	t.Helper()
+	q, err := newDiskQueue(dir, max)
+	if err != nil {
+		t.Fatalf("failed to open queue: %v", err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// drain pops every queued metric, returning their timestamps in order
+func drain(t *testing.T, q *diskQueue) []string {
+	t.Helper()
+	var got []string
+	for {
+		m, ok := q.Peek()
+		if !ok {
+			return got
+		}
+		got = append(got, m.Timestamp)
+		if err := q.Pop(); err != nil {
+			t.Fatalf("failed to pop: %v", err)
+		}
+	}
+}
+
+func TestDiskQueueReplayOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		// pushes and pops are applied in turn before the queue is reopened
+		pushes, pops []int
+		want         []string
+	}{
+		{name: "empty", want: nil},
+		{name: "all pending", pushes: []int{3}, want: []string{"0", "1", "2"}},
+		{name: "partly published", pushes: []int{5}, pops: []int{2}, want: []string{"2", "3", "4"}},
+		{name: "pushed after publishing", pushes: []int{3, 2}, pops: []int{1}, want: []string{"1", "2", "3", "4"}},
+		{name: "drained then pushed", pushes: []int{2, 2}, pops: []int{2}, want: []string{"2", "3"}},
+		{name: "all published", pushes: []int{3}, pops: []int{3}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := openQueue(t, dir, 100)
+			next := 0
+			for i, n := range tt.pushes {
+				for range n {
+					if err := q.Push(queuedMetric(next)); err != nil {
+						t.Fatalf("failed to push: %v", err)
+					}
+					next++
+				}
+				if i < len(tt.pops) {
+					for range tt.pops[i] {
+						if err := q.Pop(); err != nil {
+							t.Fatalf("failed to pop: %v", err)
+						}
+					}
+				}
+			}
+
+			// Reopening without Close is a crash: every push and pop was synced
+			reopened := openQueue(t, dir, 100)
+			if reopened.Len() != len(tt.want) {
+				t.Errorf("got %d queued after reopening, want %d", reopened.Len(), len(tt.want))
+			}
+			if got := drain(t, reopened); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiskQueueTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, 100)
+	for i := range 2 {
+		if err := q.Push(queuedMetric(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A crash part way through appending the third metric
+	f, err := os.OpenFile(filepath.Join(dir, "queue.jsonl"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"SiteId":"site","Times`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reopened := openQueue(t, dir, 100)
+	if err := reopened.Push(queuedMetric(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The torn line is dropped and the metric pushed after it stays readable
+	again := openQueue(t, dir, 100)
+	if got, want := drain(t, again), []string{"0", "1", "2"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiskQueueCrashDuringReset(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, 100)
+	for i := range 2 {
+		if err := q.Push(queuedMetric(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A crash after the drained queue reset its offset but before it truncated the
+	// data file replays the published metrics rather than losing any
+	if err := os.WriteFile(filepath.Join(dir, "queue.offset"), []byte("0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reopened := openQueue(t, dir, 100)
+	if got, want := drain(t, reopened), []string{"0", "1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiskQueueFull(t *testing.T) {
+	q := openQueue(t, t.TempDir(), 2)
+	for i := range 2 {
+		if err := q.Push(queuedMetric(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Push(queuedMetric(2)); !errors.Is(err, errQueueFull) {
+		t.Fatalf("got %v, want errQueueFull", err)
+	}
+	if err := q.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(queuedMetric(2)); err != nil {
+		t.Fatalf("got %v after making room", err)
+	}
+}
+
+// recordingSink records the timestamps of the metrics it publishes, failing while
+// fail is set. onPublish, if set, is called before each publish.
+type recordingSink struct {
+	published []string
+	fail      bool
+	onPublish func(Metric)
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Publish(_ context.Context, m Metric) error {
+	if s.onPublish != nil {
+		s.onPublish(m)
+	}
+	if s.fail {
+		return errors.New("broker unreachable")
+	}
+	s.published = append(s.published, m.Timestamp)
+	return nil
+}
+
+func (s *recordingSink) PublishConcurrent(ctx context.Context, metrics []Metric) []error {
+	errs := make([]error, len(metrics))
+	for i, m := range metrics {
+		errs[i] = s.Publish(ctx, m)
+	}
+	return errs
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestBufferedSinkDiskReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	// The broker is down: every metric is kept on disk and the publish succeeds
+	down := &recordingSink{fail: true}
+	buffered := NewBufferedSink(down, openQueue(t, dir, 100), logger)
+	for i := range 3 {
+		if err := buffered.Publish(ctx, queuedMetric(i)); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+	}
+	if errs := buffered.PublishConcurrent(ctx, []Metric{queuedMetric(3), queuedMetric(4)}); errors.Join(errs...) != nil {
+		t.Fatalf("concurrent publish: %v", errs)
+	}
+
+	// After a restart the backlog is replayed in order before the new metric
+	up := &recordingSink{}
+	restarted := NewBufferedSink(up, openQueue(t, dir, 100), logger)
+	if err := restarted.Publish(ctx, queuedMetric(5)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0", "1", "2", "3", "4", "5"}; !slices.Equal(up.published, want) {
+		t.Errorf("got %v, want %v", up.published, want)
+	}
+	if n := openQueue(t, dir, 100).Len(); n != 0 {
+		t.Errorf("got %d metrics left on disk, want 0", n)
+	}
+}
+
+func TestBufferedSinkDiskQueuesBeforePublishing(t *testing.T) {
+	dir := t.TempDir()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Whenever a metric reaches the sink, a crash at that moment must find it on disk
+	sink := &recordingSink{}
+	sink.onPublish = func(m Metric) {
+		onDisk := drainCopy(t, dir)
+		if !slices.Contains(onDisk, m.Timestamp) {
+			t.Errorf("metric %s is published before it is on disk (on disk: %v)", m.Timestamp, onDisk)
+		}
+	}
+	buffered := NewBufferedSink(sink, openQueue(t, dir, 100), logger)
+	metrics := []Metric{queuedMetric(0), queuedMetric(1), queuedMetric(2)}
+	if errs := buffered.PublishConcurrent(context.Background(), metrics); errors.Join(errs...) != nil {
+		t.Fatalf("concurrent publish: %v", errs)
+	}
+	if want := []string{"0", "1", "2"}; !slices.Equal(sink.published, want) {
+		t.Errorf("got %v, want %v", sink.published, want)
+	}
+}
+
+// drainCopy returns the timestamps of the metrics queued in dir, as a restarted
+// poller would find them, without changing the queue
+func drainCopy(t *testing.T, dir string) []string {
+	t.Helper()
+	copyDir := t.TempDir()
+	for _, name := range []string{"queue.jsonl", "queue.offset"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(copyDir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return drain(t, openQueue(t, copyDir, 100))
+}
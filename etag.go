@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// responseCacheSize bounds the cached responses, as every backfill chunk has its own URL
+const responseCacheSize = 32
+
+// cachedResponse is a response body with the validators to revalidate it
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// responseCache keeps the last response of each URL that carried an ETag or
+// Last-Modified header, so the next request for it can be made conditional and a
+// 304 Not Modified answered from the cache. It is shared by the clients of all
+// accounts, keyed by API key and URL.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+	order   []string
+}
+
+// newResponseCache creates an empty cache
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cachedResponse)}
+}
+
+// prepare makes req conditional on the cached response for key, if any
+func (c *responseCache) prepare(key string, req *http.Request) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	if cached.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.lastModified)
+	}
+}
+
+// body returns the cached body for key, after a 304 response
+func (c *responseCache) body(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[key]
+	return cached.body, ok
+}
+
+// store caches body for key when resp carries a validator, evicting the oldest
+// entry when the cache is full
+func (c *responseCache) store(key string, resp *http.Response, body []byte) {
+	if c == nil {
+		return
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		if len(c.order) >= responseCacheSize {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = cachedResponse{etag: etag, lastModified: lastModified, body: body}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/sirupsen/logrus"
+)
+
+// eventHubsScope is the Entra ID scope of tokens accepted by Event Hubs
+const eventHubsScope = "https://eventhubs.azure.net/.default"
+
+// eventHubsSASLifetime is how long a generated shared access signature stays valid
+const eventHubsSASLifetime = time.Hour
+
+// eventHubsEvent is a single event of a REST batch send
+type eventHubsEvent struct {
+	Body             string                    `json:"Body"`
+	BrokerProperties eventHubsBrokerProperties `json:"BrokerProperties"`
+}
+
+// eventHubsBrokerProperties are the broker properties of an event
+type eventHubsBrokerProperties struct {
+	PartitionKey string `json:"PartitionKey"`
+}
+
+// EventHubsWriter is a sink sending WAN metrics as JSON events to an Azure Event Hub
+// over its REST send API. Events are keyed by siteId so each site's metrics stay
+// ordered within one partition.
+type EventHubsWriter struct {
+	sendURL    string
+	resource   string
+	keyName    string
+	key        string
+	credential azcore.TokenCredential
+	httpClient *http.Client
+	logger     *logrus.Logger
+}
+
+// NewEventHubsWriter creates a writer authenticating with --eventhubs-connection-string,
+// or with Entra ID (DefaultAzureCredential) when only --eventhubs-namespace is set
+func NewEventHubsWriter(cli *CLI, logger *logrus.Logger) (*EventHubsWriter, error) {
+	w := &EventHubsWriter{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger: logger,
+	}
+
+	namespace := cli.EventhubsNamespace
+	hub := cli.EventhubsName
+	switch {
+	case cli.EventhubsConnectionString != "":
+		fields, err := parseEventHubsConnectionString(cli.EventhubsConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		endpoint, err := url.Parse(fields["Endpoint"])
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid Endpoint in event hubs connection string")
+		}
+		namespace = endpoint.Host
+		if hub == "" {
+			hub = fields["EntityPath"]
+		}
+		w.keyName = fields["SharedAccessKeyName"]
+		w.key = fields["SharedAccessKey"]
+		if w.keyName == "" || w.key == "" {
+			return nil, fmt.Errorf("event hubs connection string requires SharedAccessKeyName and SharedAccessKey")
+		}
+	case namespace != "":
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+		}
+		w.credential = credential
+	default:
+		return nil, fmt.Errorf("event hubs connection string or namespace is required")
+	}
+	if hub == "" {
+		return nil, fmt.Errorf("event hub name is required")
+	}
+
+	if !strings.Contains(namespace, ".") {
+		namespace += ".servicebus.windows.net"
+	}
+	w.resource = fmt.Sprintf("https://%s/%s", namespace, hub)
+	w.sendURL = w.resource + "/messages?api-version=2014-01"
+	if cli.EventhubsURL != "" {
+		w.sendURL = strings.TrimSuffix(cli.EventhubsURL, "/") + "/" + hub + "/messages?api-version=2014-01"
+	}
+	return w, nil
+}
+
+// Name implements Sink
+func (w *EventHubsWriter) Name() string {
+	return "eventhubs"
+}
+
+// Publish implements Sink
+func (w *EventHubsWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink. A batch shares one partition, so the metrics
+// are sent as one batch per site.
+func (w *EventHubsWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	batches := make(map[string][]eventHubsEvent)
+	var sites []string
+	for _, metric := range metrics {
+		payload, err := json.Marshal(newWANMetric(metric))
+		if err != nil {
+			return fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		if _, ok := batches[metric.SiteId]; !ok {
+			sites = append(sites, metric.SiteId)
+		}
+		batches[metric.SiteId] = append(batches[metric.SiteId], eventHubsEvent{
+			Body:             string(payload),
+			BrokerProperties: eventHubsBrokerProperties{PartitionKey: metric.SiteId},
+		})
+	}
+
+	for _, site := range sites {
+		if err := w.send(ctx, batches[site]); err != nil {
+			return fmt.Errorf("failed to send events of site %s: %w", site, err)
+		}
+	}
+	return nil
+}
+
+// Close implements Sink
+func (w *EventHubsWriter) Close() error {
+	return nil
+}
+
+// send posts a batch of events sharing a partition key
+func (w *EventHubsWriter) send(ctx context.Context, events []eventHubsEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal events: %w", err)
+	}
+
+	auth, err := w.authorization(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.sendURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", "application/vnd.microsoft.servicebus.json")
+
+	w.logger.WithFields(logrus.Fields{
+		"events":       len(events),
+		"partitionKey": events[0].BrokerProperties.PartitionKey,
+	}).Debug("Sending events to Event Hubs")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send to Event Hubs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("event hubs request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// authorization returns the Authorization header: a shared access signature for
+// connection strings, otherwise an Entra ID bearer token
+func (w *EventHubsWriter) authorization(ctx context.Context) (string, error) {
+	if w.credential == nil {
+		return eventHubsSAS(w.resource, w.keyName, w.key, time.Now().Add(eventHubsSASLifetime)), nil
+	}
+
+	token, err := w.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{eventHubsScope}})
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure token: %w", err)
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseEventHubsConnectionString splits a Key=Value;... connection string
+func parseEventHubsConnectionString(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid event hubs connection string field %q", part)
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return fields, nil
+}
+
+// eventHubsSAS signs a shared access signature for resource valid until expiry
+func eventHubsSAS(resource, keyName, key string, expiry time.Time) string {
+	encoded := url.QueryEscape(resource)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(encoded + "\n" + se))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s",
+		encoded, url.QueryEscape(sig), se, url.QueryEscape(keyName))
+}
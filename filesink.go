@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fileCSVHeader is the first line of every CSV file
+var fileCSVHeader = []string{
+	"metric_time", "site_id", "host_id", "metric_type", "isp_name", "isp_asn",
+	"avg_latency", "max_latency", "download_kbps", "upload_kbps", "packet_loss", "uptime", "downtime", "published_at",
+}
+
+// FileWriter is a sink appending metrics to a JSONL or CSV file. The file is rotated
+// once it exceeds maxSize or was opened more than rotateEvery ago; rotated files are
+// renamed to <name>-<UTC timestamp><ext> and the oldest beyond maxBackups are removed.
+type FileWriter struct {
+	path        string
+	format      string
+	maxSize     int64
+	rotateEvery time.Duration
+	maxBackups  int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	logger   *logrus.Logger
+}
+
+// NewFileWriter opens --file-path for appending, creating its directory if needed
+func NewFileWriter(cli *CLI, logger *logrus.Logger) (*FileWriter, error) {
+	if cli.FilePath == "" {
+		return nil, fmt.Errorf("file path is required")
+	}
+
+	w := &FileWriter{
+		path:        cli.FilePath,
+		format:      cli.FileFormat,
+		maxSize:     cli.FileMaxSizeMB << 20,
+		rotateEvery: cli.FileRotateInterval,
+		maxBackups:  cli.FileMaxBackups,
+		logger:      logger,
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", w.path, err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Name implements Sink
+func (w *FileWriter) Name() string {
+	return "file"
+}
+
+// Publish implements Sink by appending a single record
+func (w *FileWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by appending and syncing all records at once
+func (w *FileWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, metric := range metrics {
+		if err := w.encode(&buf, metric); err != nil {
+			return err
+		}
+	}
+
+	if w.due(int64(buf.Len())) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(buf.Bytes())
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", w.path, err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", w.path, err)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (w *FileWriter) Close() error {
+	return w.file.Close()
+}
+
+// encode appends metric to buf in the configured format
+func (w *FileWriter) encode(buf *bytes.Buffer, metric Metric) error {
+	if w.format == "csv" {
+		wan := metric.WAN
+		record := []string{
+			metric.Timestamp, metric.SiteId, metric.HostId, metric.MetricType, wan.ISPName, wan.ISPAsn,
+			strconv.Itoa(wan.AvgLatency), strconv.Itoa(wan.MaxLatency), strconv.Itoa(wan.DownloadKbps), strconv.Itoa(wan.UploadKbps),
+			strconv.Itoa(wan.PacketLoss), strconv.Itoa(wan.Uptime), strconv.Itoa(wan.Downtime), metric.PublishedAt.UTC().Format(time.RFC3339),
+		}
+		return writeCSVRecord(buf, record)
+	}
+
+	line, err := json.Marshal(newWANMetric(metric))
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric: %w", err)
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// due reports whether the file must be rotated before appending n more bytes. A file
+// holding nothing but a header is never rotated.
+func (w *FileWriter) due(n int64) bool {
+	if w.size <= w.headerSize() {
+		return false
+	}
+	if w.maxSize > 0 && w.size+n > w.maxSize {
+		return true
+	}
+	return w.rotateEvery > 0 && time.Since(w.openedAt) >= w.rotateEvery
+}
+
+// open opens the file for appending, writing the CSV header to a new file
+func (w *FileWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", w.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat %s: %w", w.path, err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+
+	if w.size == 0 && w.format == "csv" {
+		var buf bytes.Buffer
+		if err := writeCSVRecord(&buf, fileCSVHeader); err != nil {
+			return err
+		}
+		n, err := w.file.Write(buf.Bytes())
+		w.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", w.path, err)
+		}
+	}
+	return nil
+}
+
+// rotate renames the current file aside, opens a new one and prunes old backups
+func (w *FileWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", w.path, err)
+	}
+
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(w.path, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format("20060102T150405Z"), ext)
+	if err := os.Rename(w.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate %s: %w", w.path, err)
+	}
+	w.logger.WithField("file", rotated).Info("Rotated metrics file")
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	if w.maxBackups > 0 {
+		backups, err := filepath.Glob(base + "-*" + ext)
+		if err != nil {
+			return fmt.Errorf("failed to list rotated files: %w", err)
+		}
+		// The UTC timestamps sort lexically
+		sort.Strings(backups)
+		for len(backups) > w.maxBackups {
+			if err := os.Remove(backups[0]); err != nil {
+				w.logger.WithError(err).WithField("file", backups[0]).Warn("Failed to remove rotated metrics file")
+			}
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// headerSize returns the size of the CSV header, or 0 for JSONL
+func (w *FileWriter) headerSize() int64 {
+	if w.format != "csv" {
+		return 0
+	}
+	var buf bytes.Buffer
+	writeCSVRecord(&buf, fileCSVHeader)
+	return int64(buf.Len())
+}
+
+// writeCSVRecord appends a single CSV record to buf
+func writeCSVRecord(buf *bytes.Buffer, record []string) error {
+	writer := csv.NewWriter(buf)
+	if err := writer.Write(record); err != nil {
+		return fmt.Errorf("failed to encode CSV record: %w", err)
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,53 @@
+package main
+
+// SiteFilter limits processing to an allow list of sites and drops denied sites
+type SiteFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+// NewSiteFilter creates a filter from --sites and --exclude-sites. It returns nil
+// when neither list is set so every site is kept.
+func NewSiteFilter(include, exclude []string) *SiteFilter {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+
+	f := &SiteFilter{
+		include: make(map[string]bool),
+		exclude: make(map[string]bool),
+	}
+	for _, siteId := range include {
+		f.include[siteId] = true
+	}
+	for _, siteId := range exclude {
+		f.exclude[siteId] = true
+	}
+	return f
+}
+
+// Allow reports whether siteId passes the filter
+func (f *SiteFilter) Allow(siteId string) bool {
+	if f == nil {
+		return true
+	}
+	if f.exclude[siteId] {
+		return false
+	}
+	return len(f.include) == 0 || f.include[siteId]
+}
+
+// Apply removes the sites that do not pass the filter from metrics
+func (f *SiteFilter) Apply(metrics *ISPMetrics) {
+	if f == nil {
+		return
+	}
+
+	kept := metrics.Data[:0]
+	for _, data := range metrics.Data {
+		if f.Allow(data.SiteId) {
+			kept = append(kept, data)
+		}
+	}
+	metrics.Data = kept
+}
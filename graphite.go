@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// graphiteNodeReplacer removes the path separator and whitespace from values
+// rendered into a Graphite metric path, so each value stays a single node
+var graphiteNodeReplacer = strings.NewReplacer(".", "_", " ", "_", "\t", "_", "\n", "_", "/", "_")
+
+// GraphitePathData is the data made available to --graphite-path-template. Every
+// field but Prefix is sanitized to a single path node.
+type GraphitePathData struct {
+	Prefix     string
+	Metric     string
+	MetricType string
+	SiteId     string
+	SiteName   string
+	HostId     string
+	HostName   string
+	ISPName    string
+	ISPAsn     string
+	Labels     map[string]string
+}
+
+// GraphiteWriter is a sink sending WAN metrics to Carbon over TCP using either the
+// plaintext or the pickle protocol. One datapoint is sent per WAN value, named by the
+// path template and timestamped with the period's metricTime.
+type GraphiteWriter struct {
+	addr     string
+	prefix   string
+	pickle   bool
+	pathTmpl *template.Template
+	timeout  time.Duration
+	logger   *logrus.Logger
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// graphitePoint is a single Graphite datapoint
+type graphitePoint struct {
+	path      string
+	value     int
+	timestamp int64
+}
+
+// parseGraphitePathTemplate compiles the --graphite-path-template option
+func parseGraphitePathTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("graphite").Funcs(announceFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse graphite path template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// NewGraphiteWriter creates a writer sending to --graphite-addr. The connection is
+// opened on the first publish and reopened after a failed write.
+func NewGraphiteWriter(cli *CLI, logger *logrus.Logger) (*GraphiteWriter, error) {
+	if cli.GraphiteAddr == "" {
+		return nil, fmt.Errorf("graphite address is required")
+	}
+
+	tmpl, err := parseGraphitePathTemplate(cli.GraphitePathTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GraphiteWriter{
+		addr:     cli.GraphiteAddr,
+		prefix:   strings.TrimSuffix(cli.GraphitePrefix, "."),
+		pickle:   cli.GraphiteProtocol == "pickle",
+		pathTmpl: tmpl,
+		timeout:  10 * time.Second,
+		logger:   logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *GraphiteWriter) Name() string {
+	return "graphite"
+}
+
+// Publish implements Sink
+func (w *GraphiteWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by sending the datapoints of all metrics in a
+// single write
+func (w *GraphiteWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var points []graphitePoint
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+
+		for _, v := range wanValues(metric.WAN) {
+			path, err := w.renderPath(metric, v.name)
+			if err != nil {
+				return err
+			}
+			points = append(points, graphitePoint{path: path, value: v.value, timestamp: metricTime.Unix()})
+		}
+	}
+
+	if len(points) == 0 {
+		return nil
+	}
+
+	var payload []byte
+	if w.pickle {
+		payload = encodeGraphitePickle(points)
+	} else {
+		var buf bytes.Buffer
+		for _, p := range points {
+			fmt.Fprintf(&buf, "%s %d %d\n", p.path, p.value, p.timestamp)
+		}
+		payload = buf.Bytes()
+	}
+
+	w.logger.WithField("points", len(points)).Debug("Sending datapoints to Graphite")
+
+	return w.write(ctx, payload)
+}
+
+// Close implements Sink
+func (w *GraphiteWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// renderPath executes the path template for one WAN value of a metric
+func (w *GraphiteWriter) renderPath(metric Metric, name string) (string, error) {
+	topic := newTopicData("", name, metric)
+
+	labels := make(map[string]string, len(metric.Labels))
+	for k, v := range metric.Labels {
+		labels[k] = graphiteNodeReplacer.Replace(v)
+	}
+
+	data := GraphitePathData{
+		Prefix:     w.prefix,
+		Metric:     topic.Metric,
+		MetricType: graphiteNodeReplacer.Replace(topic.MetricType),
+		SiteId:     graphiteNodeReplacer.Replace(topic.SiteId),
+		SiteName:   graphiteNodeReplacer.Replace(topic.SiteName),
+		HostId:     graphiteNodeReplacer.Replace(topic.HostId),
+		HostName:   graphiteNodeReplacer.Replace(topic.HostName),
+		ISPName:    graphiteNodeReplacer.Replace(topic.ISPName),
+		ISPAsn:     graphiteNodeReplacer.Replace(topic.ISPAsn),
+		Labels:     labels,
+	}
+
+	var buf bytes.Buffer
+	if err := w.pathTmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render graphite path: %w", err)
+	}
+
+	path := strings.Trim(buf.String(), ".")
+	if path == "" {
+		return "", fmt.Errorf("graphite path template rendered an empty path for site %s", metric.SiteId)
+	}
+	if strings.ContainsAny(path, " \t\n") {
+		return "", fmt.Errorf("graphite path %q for site %s contains whitespace", path, metric.SiteId)
+	}
+	return path, nil
+}
+
+// write sends payload, dialing Carbon if there is no open connection. A failed
+// write closes the connection so the next publish starts on a fresh one.
+func (w *GraphiteWriter) write(ctx context.Context, payload []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		dialer := net.Dialer{Timeout: w.timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", w.addr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to Graphite: %w", err)
+		}
+		w.conn = conn
+	}
+
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return fmt.Errorf("failed to set Graphite write deadline: %w", err)
+	}
+	if _, err := w.conn.Write(payload); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return fmt.Errorf("failed to send to Graphite: %w", err)
+	}
+	return nil
+}
+
+// encodeGraphitePickle encodes points as the length-prefixed pickle (protocol 2)
+// list of (path, (timestamp, value)) tuples read by Carbon's pickle receiver
+func encodeGraphitePickle(points []graphitePoint) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0}) // length header, filled in below
+	buf.Write([]byte{0x80, 2})    // PROTO 2
+	buf.WriteByte(']')            // EMPTY_LIST
+	buf.WriteByte('(')            // MARK
+	for _, p := range points {
+		pickleString(&buf, p.path)
+		pickleInt(&buf, p.timestamp)
+		pickleInt(&buf, int64(p.value))
+		buf.WriteByte(0x86) // TUPLE2 (timestamp, value)
+		buf.WriteByte(0x86) // TUPLE2 (path, datapoint)
+	}
+	buf.WriteByte('e') // APPENDS
+	buf.WriteByte('.') // STOP
+
+	out := buf.Bytes()
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	return out
+}
+
+// pickleString writes s as a BINUNICODE opcode
+func pickleString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('X')
+	binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+// pickleInt writes n as a BININT opcode, or as LONG1 when it does not fit 32 bits
+func pickleInt(buf *bytes.Buffer, n int64) {
+	if n >= -1<<31 && n < 1<<31 {
+		buf.WriteByte('J')
+		binary.Write(buf, binary.LittleEndian, int32(n))
+		return
+	}
+
+	// LONG1 holds a little-endian two's complement integer of up to 255 bytes
+	var raw [8]byte
+	binary.LittleEndian.PutUint64(raw[:], uint64(n))
+	size := 8
+	for size > 1 && ((raw[size-1] == 0 && raw[size-2]&0x80 == 0) || (raw[size-1] == 0xff && raw[size-2]&0x80 != 0)) {
+		size--
+	}
+	buf.WriteByte(0x8a)
+	buf.WriteByte(byte(size))
+	buf.Write(raw[:size])
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// HealthStatus is the body returned by the health endpoints
+type HealthStatus struct {
+	Status         string     `json:"status"`
+	Reason         string     `json:"reason,omitempty"`
+	StartedAt      time.Time  `json:"startedAt"`
+	LastPoll       *time.Time `json:"lastPoll,omitempty"`
+	LastSuccess    *time.Time `json:"lastSuccess,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	MQTTConnected  *bool      `json:"mqttConnected,omitempty"`
+	MaxPollAgeSecs float64    `json:"maxPollAgeSeconds"`
+}
+
+// HealthServer serves /healthz and /readyz from the telemetry of recent poll cycles,
+// along with the telemetry itself on /metrics
+type HealthServer struct {
+	maxPollAge time.Duration
+	telemetry  *Telemetry
+	mqtt       *MQTTPublisher
+	server     *http.Server
+	logger     *logrus.Logger
+}
+
+// NewHealthServer creates a health server and starts serving it on addr. The poller is
+// unhealthy once no poll succeeded for maxPollAge; mqtt may be nil when the mqtt sink is off.
+func NewHealthServer(addr string, maxPollAge time.Duration, telemetry *Telemetry, mqtt *MQTTPublisher, logger *logrus.Logger) (*HealthServer, error) {
+	h := &HealthServer{
+		maxPollAge: maxPollAge,
+		telemetry:  telemetry,
+		mqtt:       mqtt,
+		logger:     logger,
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(telemetry)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// Listen up front so address errors fail startup instead of being logged later
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	h.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := h.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Error("Health server stopped")
+		}
+	}()
+
+	logger.WithField("addr", addr).Info("Serving health endpoints")
+	return h, nil
+}
+
+// Close shuts down the HTTP server
+func (h *HealthServer) Close() error {
+	if h == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return h.server.Shutdown(ctx)
+}
+
+// handleHealthz reports liveness: the poller is alive as long as polls keep succeeding
+func (h *HealthServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := h.status()
+	if reason := h.pollStale(status); reason != "" {
+		status.Status = "unhealthy"
+		status.Reason = reason
+	}
+	h.write(w, status)
+}
+
+// handleReadyz reports readiness: a poll has succeeded recently and MQTT is connected
+func (h *HealthServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := h.status()
+	switch {
+	case status.LastSuccess == nil:
+		status.Status = "unready"
+		status.Reason = "no successful poll yet"
+	case status.MQTTConnected != nil && !*status.MQTTConnected:
+		status.Status = "unready"
+		status.Reason = "not connected to MQTT broker"
+	default:
+		if reason := h.pollStale(status); reason != "" {
+			status.Status = "unready"
+			status.Reason = reason
+		}
+	}
+	h.write(w, status)
+}
+
+// status snapshots the current state
+func (h *HealthServer) status() HealthStatus {
+	snapshot := h.telemetry.Snapshot()
+	status := HealthStatus{
+		Status:         "ok",
+		StartedAt:      snapshot.StartedAt,
+		LastPoll:       snapshot.LastPoll,
+		LastSuccess:    snapshot.LastSuccess,
+		LastError:      snapshot.LastError,
+		MaxPollAgeSecs: h.maxPollAge.Seconds(),
+	}
+	if h.mqtt != nil {
+		connected := h.mqtt.IsConnected()
+		status.MQTTConnected = &connected
+	}
+	return status
+}
+
+// pollStale returns why the last successful poll in status is too old, or "" if it
+// is recent. Before the first success the age is measured from startup.
+func (h *HealthServer) pollStale(status HealthStatus) string {
+	since := status.StartedAt
+	if status.LastSuccess != nil {
+		since = *status.LastSuccess
+	}
+	if age := time.Since(since); age > h.maxPollAge {
+		return fmt.Sprintf("no successful poll for %s", age.Round(time.Second))
+	}
+	return ""
+}
+
+// write encodes status, using 503 for anything but ok
+func (h *HealthServer) write(w http.ResponseWriter, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logger.WithError(err).Debug("Failed to write health status")
+	}
+}
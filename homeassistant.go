@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// haSensor describes a Home Assistant sensor read from the site's WAN topic
+type haSensor struct {
+	key         string
+	name        string
+	field       string
+	unit        string
+	deviceClass string
+	icon        string
+}
+
+// haSensors are the per-site sensors announced to Home Assistant
+var haSensors = []haSensor{
+	{key: "avg_latency", name: "Average Latency", field: "avgLatency", unit: "ms", deviceClass: "duration", icon: "mdi:timer-outline"},
+	{key: "max_latency", name: "Max Latency", field: "maxLatency", unit: "ms", deviceClass: "duration", icon: "mdi:timer-alert-outline"},
+	{key: "download", name: "Download", field: "downloadKbps", unit: "kbit/s", deviceClass: "data_rate", icon: "mdi:download"},
+	{key: "upload", name: "Upload", field: "uploadKbps", unit: "kbit/s", deviceClass: "data_rate", icon: "mdi:upload"},
+	{key: "packet_loss", name: "Packet Loss", field: "packetLoss", unit: "%", icon: "mdi:lan-disconnect"},
+}
+
+// haSensorPayload is the discovery payload announce template for a single sensor.
+// The fmt verbs are filled per sensor, the {{...}} actions per site.
+const haSensorPayload = `{
+  "name": %[1]s,
+  "unique_id": "ubipoller_{{.SiteId}}_%[2]s",
+  "object_id": "ubipoller_{{.SiteId}}_%[2]s",
+  "state_topic": {{json .WANTopic}},
+  "availability_topic": {{json .AvailabilityTopic}},
+  "value_template": "{{"{{"}} value_json.%[3]s {{"}}"}}",
+  "unit_of_measurement": %[4]s,%[5]s
+  "state_class": "measurement",
+  "icon": %[6]s,
+  "device": {
+    "identifiers": ["ubipoller_{{.SiteId}}"],
+    "name": {{json (printf "UniFi %%s" .SiteName)}},
+    "manufacturer": "Ubiquiti",
+    "model": "Site Manager WAN"
+  }
+}`
+
+// homeAssistantTemplates builds the announce templates for Home Assistant MQTT discovery
+func homeAssistantTemplates(prefix string) []AnnounceTemplate {
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+
+	var templates []AnnounceTemplate
+	for _, sensor := range haSensors {
+		deviceClass := ""
+		if sensor.deviceClass != "" {
+			deviceClass = "\n  \"device_class\": " + quote(sensor.deviceClass) + ","
+		}
+
+		templates = append(templates, AnnounceTemplate{
+			Name:    "homeassistant-" + sensor.key,
+			Topic:   fmt.Sprintf("%s/sensor/ubipoller_{{.SiteId}}/%s/config", prefix, sensor.key),
+			Payload: fmt.Sprintf(haSensorPayload, quote(sensor.name), sensor.key, sensor.field, quote(sensor.unit), deviceClass, quote(sensor.icon)),
+			QoS:     1,
+			Retain:  true,
+		})
+	}
+	return templates
+}
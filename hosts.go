@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HostMetric describes a host (UniFi console) and the state of its devices
+type HostMetric struct {
+	HostId          string `json:"hostId"`
+	Name            string `json:"name"`
+	Hostname        string `json:"hostname"`
+	Type            string `json:"type"`
+	IPAddress       string `json:"ipAddress"`
+	Model           string `json:"model"`
+	ModelShortname  string `json:"modelShortname"`
+	FirmwareVersion string `json:"firmwareVersion"`
+	State           string `json:"state"`
+	Online          bool   `json:"online"`
+	// UptimeSeconds is the uptime of the console device, nil when the devices API does not list it
+	UptimeSeconds *int64    `json:"uptimeSeconds"`
+	Devices       int       `json:"devices"`
+	DevicesOnline int       `json:"devicesOnline"`
+	Account       string    `json:"account,omitempty"`
+	PublishedAt   time.Time `json:"publishedAt"`
+}
+
+// fetchHosts fetches the hosts and devices of every account from the Ubiquiti API
+// under apiRoot. The hosts of accounts that could be fetched are returned along with
+// the errors of those that could not.
+func fetchHosts(ctx context.Context, accounts []Account, apiRoot string) ([]HostMetric, error) {
+	apiRoot = strings.TrimSuffix(apiRoot, "/")
+
+	var hostMetrics []HostMetric
+	var errs []error
+	for _, account := range accounts {
+		client, ok := account.Client.(*UbiquitiClient)
+		if !ok {
+			continue
+		}
+
+		hosts, err := client.GetHosts(ctx, apiRoot+"/hosts")
+		if err == nil {
+			var devices *DevicesResponse
+			if devices, err = client.GetDevices(ctx, apiRoot+"/devices"); err == nil {
+				hostMetrics = append(hostMetrics, buildHostMetrics(hosts, devices, account.Name)...)
+				continue
+			}
+		}
+		if account.Name != "" {
+			err = fmt.Errorf("account %s: %w", account.Name, err)
+		}
+		errs = append(errs, err)
+	}
+
+	return hostMetrics, errors.Join(errs...)
+}
+
+// buildHostMetrics combines every host with its devices. The model, firmware and
+// uptime are those of the console device when listed, else the host's own report.
+func buildHostMetrics(hosts *HostsResponse, devices *DevicesResponse, account string) []HostMetric {
+	devicesByHost := make(map[string][]Device, len(devices.Data))
+	for _, group := range devices.Data {
+		devicesByHost[group.HostId] = append(devicesByHost[group.HostId], group.Devices...)
+	}
+
+	now := time.Now()
+	hostMetrics := make([]HostMetric, 0, len(hosts.Data))
+	for _, host := range hosts.Data {
+		hostMetric := HostMetric{
+			HostId:          host.ID,
+			Name:            host.ReportedState.Name,
+			Hostname:        host.ReportedState.Hostname,
+			Type:            host.Type,
+			IPAddress:       host.IPAddress,
+			Model:           host.ReportedState.Hardware.Name,
+			ModelShortname:  host.ReportedState.Hardware.Shortname,
+			FirmwareVersion: host.ReportedState.Version,
+			State:           host.ReportedState.State,
+			Online:          host.ReportedState.State == "connected",
+			Account:         account,
+			PublishedAt:     now,
+		}
+
+		for _, device := range devicesByHost[host.ID] {
+			hostMetric.Devices++
+			if device.Status == "online" {
+				hostMetric.DevicesOnline++
+			}
+			if !device.IsConsole {
+				continue
+			}
+			if device.Model != "" {
+				hostMetric.Model, hostMetric.ModelShortname = device.Model, device.Shortname
+			}
+			if device.Version != "" {
+				hostMetric.FirmwareVersion = device.Version
+			}
+			if device.StartupTime != nil && !device.StartupTime.IsZero() {
+				uptime := int64(now.Sub(*device.StartupTime).Seconds())
+				hostMetric.UptimeSeconds = &uptime
+			}
+		}
+
+		hostMetrics = append(hostMetrics, hostMetric)
+	}
+	return hostMetrics
+}
+
+// PublishHost publishes a retained host metric to baseTopic/hosts/<hostId>
+func (p *MQTTPublisher) PublishHost(hostMetric HostMetric, baseTopic string) error {
+	payload, err := json.Marshal(hostMetric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal host metric: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/hosts/%s", baseTopic, hostMetric.HostId)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":  topic,
+		"online": hostMetric.Online,
+	}).Debug("Publishing host metric to MQTT")
+
+	if err := p.send(topic, 1, true, payload); err != nil {
+		return fmt.Errorf("failed to publish host metric to MQTT: %w", err)
+	}
+
+	return nil
+}
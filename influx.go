@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InfluxWriter is a sink writing WAN metrics to InfluxDB v2 using the line protocol write API
+type InfluxWriter struct {
+	writeURL    string
+	token       string
+	measurement string
+	httpClient  *http.Client
+	logger      *logrus.Logger
+}
+
+// NewInfluxWriter creates a writer for the given InfluxDB v2 server, org and bucket
+func NewInfluxWriter(serverURL, org, bucket, token, measurement string, logger *logrus.Logger) (*InfluxWriter, error) {
+	if serverURL == "" {
+		return nil, fmt.Errorf("influx url is required")
+	}
+	if org == "" || bucket == "" {
+		return nil, fmt.Errorf("influx org and bucket are required")
+	}
+
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "s")
+
+	return &InfluxWriter{
+		writeURL:    fmt.Sprintf("%s/api/v2/write?%s", strings.TrimSuffix(serverURL, "/"), query.Encode()),
+		token:       token,
+		measurement: measurement,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *InfluxWriter) Name() string {
+	return "influx"
+}
+
+// Publish implements Sink by writing a single point
+func (w *InfluxWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by writing all metrics in one request. Each
+// point is timestamped with its metricTime, so rewriting a period overwrites it.
+func (w *InfluxWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var body bytes.Buffer
+	points := 0
+
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+		w.writePoint(&body, metric, metricTime)
+		points++
+	}
+
+	if points == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.writeURL, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Token "+w.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	w.logger.WithField("points", points).Debug("Writing points to InfluxDB")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB write failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// Close implements Sink
+func (w *InfluxWriter) Close() error {
+	return nil
+}
+
+// writePoint appends a single line protocol point to buf
+func (w *InfluxWriter) writePoint(buf *bytes.Buffer, metric Metric, metricTime time.Time) {
+	wan := metric.WAN
+	buf.WriteString(escapeInfluxKey(w.measurement))
+	writeInfluxTag(buf, "site_id", metric.SiteId)
+	writeInfluxTag(buf, "host_id", metric.HostId)
+	writeInfluxTag(buf, "metric_type", metric.MetricType)
+	writeInfluxTag(buf, "isp_name", wan.ISPName)
+	writeInfluxTag(buf, "isp_asn", wan.ISPAsn)
+
+	fmt.Fprintf(buf, " avg_latency=%di,max_latency=%di,download_kbps=%di,upload_kbps=%di,packet_loss=%di,uptime=%di,downtime=%di %d\n",
+		wan.AvgLatency, wan.MaxLatency, wan.DownloadKbps, wan.UploadKbps, wan.PacketLoss, wan.Uptime, wan.Downtime, metricTime.Unix())
+}
+
+// writeInfluxTag appends ",key=value" to buf, omitting empty values which line protocol does not allow
+func writeInfluxTag(buf *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	buf.WriteByte(',')
+	buf.WriteString(escapeInfluxKey(key))
+	buf.WriteByte('=')
+	buf.WriteString(escapeInfluxKey(value))
+}
+
+// influxKeyEscaper escapes measurement names, tag keys and tag values
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+func escapeInfluxKey(s string) string {
+	return influxKeyEscaper.Replace(s)
+}
+
+// encodeInfluxPayload renders a per-site payload as a line protocol point for
+// --payload-format influx. Labels become tags and the point is timestamped with
+// the period's metricTime in nanoseconds, the default precision of Telegraf's
+// influx parser.
+func encodeInfluxPayload(v interface{}) ([]byte, error) {
+	var measurement, siteId, hostId, timestamp, ispName, ispAsn string
+	var labels map[string]string
+	var fields []string
+	intField := func(name string, value int) {
+		fields = append(fields, fmt.Sprintf("%s=%di", name, value))
+	}
+
+	switch m := v.(type) {
+	case LatencyMetric:
+		measurement, siteId, hostId, timestamp = "ubiquiti_latency", m.SiteId, m.HostId, m.Timestamp
+		ispName, ispAsn, labels = m.ISPName, m.ISPAsn, m.Labels
+		intField("avg_latency", m.AvgLatency)
+		intField("max_latency", m.MaxLatency)
+	case WANMetric:
+		measurement, siteId, hostId, timestamp = "ubiquiti_wan", m.SiteId, m.HostId, m.Timestamp
+		ispName, ispAsn, labels = m.ISPName, m.ISPAsn, m.Labels
+		intField("avg_latency", m.AvgLatency)
+		intField("max_latency", m.MaxLatency)
+		intField("download_kbps", m.DownloadKbps)
+		intField("upload_kbps", m.UploadKbps)
+		intField("packet_loss", m.PacketLoss)
+		intField("uptime", m.Uptime)
+		intField("downtime", m.Downtime)
+	case PlanMetric:
+		measurement, siteId, hostId, timestamp = "ubiquiti_plan", m.SiteId, m.HostId, m.Timestamp
+		intField("download_kbps", m.DownloadKbps)
+		intField("upload_kbps", m.UploadKbps)
+		intField("contracted_download_kbps", m.ContractedDownloadKbps)
+		intField("contracted_upload_kbps", m.ContractedUploadKbps)
+		fields = append(fields,
+			fmt.Sprintf("download_attainment=%g", m.DownloadAttainment),
+			fmt.Sprintf("upload_attainment=%g", m.UploadAttainment),
+			fmt.Sprintf("under_delivering=%t", m.UnderDelivering),
+		)
+		intField("consecutive_under", m.ConsecutiveUnder)
+		fields = append(fields, fmt.Sprintf("chronic_under_delivery=%t", m.ChronicUnderDelivery))
+	case CounterMetric:
+		measurement, siteId, hostId, timestamp = "ubiquiti_counters", m.SiteId, m.HostId, m.Timestamp
+		intField("uptime", m.Uptime)
+		intField("downtime", m.Downtime)
+		if m.UptimeDelta != nil {
+			intField("uptime_delta", *m.UptimeDelta)
+		}
+		if m.DowntimeDelta != nil {
+			intField("downtime_delta", *m.DowntimeDelta)
+		}
+	default:
+		return nil, fmt.Errorf("no line protocol encoding for payload type %T", v)
+	}
+
+	metricTime, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metricTime %q: %w", timestamp, err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(measurement)
+	writeInfluxTag(&buf, "site_id", siteId)
+	writeInfluxTag(&buf, "host_id", hostId)
+	writeInfluxTag(&buf, "isp_name", ispName)
+	writeInfluxTag(&buf, "isp_asn", ispAsn)
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch k {
+		case "site_id", "host_id", "isp_name", "isp_asn":
+			// Labels cannot replace the built-in tags
+			continue
+		}
+		writeInfluxTag(&buf, k, labels[k])
+	}
+	fmt.Fprintf(&buf, " %s %d", strings.Join(fields, ","), metricTime.UnixNano())
+	return buf.Bytes(), nil
+}
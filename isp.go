@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ISPChangeEvent is published when the ISP name or ASN of a site changes, e.g. on
+// failover to a backup WAN
+type ISPChangeEvent struct {
+	SiteId          string            `json:"siteId"`
+	HostId          string            `json:"hostId"`
+	PreviousISPName string            `json:"previousIspName"`
+	PreviousISPAsn  string            `json:"previousIspAsn"`
+	ISPName         string            `json:"ispName"`
+	ISPAsn          string            `json:"ispAsn"`
+	PreviousSince   string            `json:"previousSince"`
+	Timestamp       string            `json:"timestamp"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	PublishedAt     time.Time         `json:"publishedAt"`
+}
+
+// ispState is the ISP of a site as of its latest period
+type ispState struct {
+	name       string
+	asn        string
+	since      string
+	metricTime time.Time
+}
+
+// ISPChangeTracker tracks the ISP name and ASN of every site
+type ISPChangeTracker struct {
+	states map[string]*ispState
+	logger *logrus.Logger
+}
+
+// NewISPChangeTracker creates a tracker without any known ISPs
+func NewISPChangeTracker(logger *logrus.Logger) *ISPChangeTracker {
+	return &ISPChangeTracker{
+		states: make(map[string]*ispState),
+		logger: logger,
+	}
+}
+
+// Evaluate walks the periods of every site in metrics newer than the last one seen,
+// oldest first, returning an event for every change of ISP name or ASN. The first
+// period of a site only records its ISP, and periods without either are skipped.
+func (t *ISPChangeTracker) Evaluate(metrics *ISPMetrics, sites map[string]Metric) []ISPChangeEvent {
+	var events []ISPChangeEvent
+
+	for _, data := range metrics.Data {
+		state, known := t.states[data.SiteId]
+
+		for i := len(data.Periods) - 1; i >= 0; i-- {
+			period := data.Periods[i]
+			wan := period.Data.WAN
+			if wan.ISPName == "" && wan.ISPAsn == "" {
+				continue
+			}
+			metricTime, err := time.Parse(time.RFC3339, period.MetricTime)
+			if err != nil {
+				continue
+			}
+
+			if !known {
+				state = &ispState{name: wan.ISPName, asn: wan.ISPAsn, since: period.MetricTime, metricTime: metricTime}
+				t.states[data.SiteId] = state
+				known = true
+				continue
+			}
+			if !metricTime.After(state.metricTime) {
+				continue
+			}
+			state.metricTime = metricTime
+			if wan.ISPName == state.name && wan.ISPAsn == state.asn {
+				continue
+			}
+
+			event := ISPChangeEvent{
+				SiteId:          data.SiteId,
+				HostId:          data.HostId,
+				PreviousISPName: state.name,
+				PreviousISPAsn:  state.asn,
+				ISPName:         wan.ISPName,
+				ISPAsn:          wan.ISPAsn,
+				PreviousSince:   state.since,
+				Timestamp:       period.MetricTime,
+				Labels:          sites[data.SiteId].Labels,
+				PublishedAt:     time.Now(),
+			}
+			state.name, state.asn, state.since = wan.ISPName, wan.ISPAsn, period.MetricTime
+
+			t.logger.WithFields(logrus.Fields{
+				"siteId":          data.SiteId,
+				"previousIspName": event.PreviousISPName,
+				"previousIspAsn":  event.PreviousISPAsn,
+				"ispName":         event.ISPName,
+				"ispAsn":          event.ISPAsn,
+			}).Warn("Site ISP changed")
+
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// PublishISPChange publishes a retained ISP change event to baseTopic/isp-changes/<siteId>,
+// so subscribers see the latest change of every site
+func (p *MQTTPublisher) PublishISPChange(event ISPChangeEvent, baseTopic string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ISP change: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/isp-changes/%s", baseTopic, event.SiteId)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":   topic,
+		"ispName": event.ISPName,
+	}).Debug("Publishing ISP change to MQTT")
+
+	if err := p.send(topic, 1, true, payload); err != nil {
+		return fmt.Errorf("failed to publish ISP change to MQTT: %w", err)
+	}
+
+	return nil
+}
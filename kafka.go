@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/sirupsen/logrus"
+)
+
+// KafkaWriter is a sink producing WAN metrics as messages keyed by siteId, so all
+// periods of a site land on the same partition in order. Avro messages carry the
+// schema ID of a Confluent Schema Registry.
+type KafkaWriter struct {
+	writer        *kafka.Writer
+	payloadFormat string
+	registry      *SchemaRegistry
+	logger        *logrus.Logger
+}
+
+// NewKafkaWriter creates a producer for --kafka-brokers and --kafka-topic
+func NewKafkaWriter(cli *CLI, logger *logrus.Logger) (*KafkaWriter, error) {
+	if len(cli.KafkaBrokers) == 0 {
+		return nil, fmt.Errorf("kafka brokers are required")
+	}
+	if cli.KafkaTopic == "" {
+		return nil, fmt.Errorf("kafka topic is required")
+	}
+
+	mechanism, err := newKafkaSASLMechanism(cli.KafkaSASLMechanism, cli.KafkaUsername, cli.KafkaPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if cli.KafkaTLS || cli.KafkaTLSCAFile != "" || cli.KafkaTLSInsecure {
+		tlsConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: cli.KafkaTLSInsecure,
+		}
+		if cli.KafkaTLSCAFile != "" {
+			pool, err := loadCertPool(cli.KafkaTLSCAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	var registry *SchemaRegistry
+	if cli.PayloadFormat == "avro" {
+		registry, err = NewSchemaRegistry(cli)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &KafkaWriter{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cli.KafkaBrokers...),
+			Topic:        cli.KafkaTopic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// Each cycle is written as one batch, so don't wait for more messages
+			BatchTimeout: 10 * time.Millisecond,
+			Transport: &kafka.Transport{
+				SASL: mechanism,
+				TLS:  tlsConfig,
+			},
+		},
+		payloadFormat: cli.PayloadFormat,
+		registry:      registry,
+		logger:        logger,
+	}, nil
+}
+
+// newKafkaSASLMechanism returns the SASL mechanism selected by --kafka-sasl-mechanism
+func newKafkaSASLMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch name {
+	case "none":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unknown kafka SASL mechanism %q", name)
+	}
+}
+
+// Name implements Sink
+func (w *KafkaWriter) Name() string {
+	return "kafka"
+}
+
+// Publish implements Sink by producing a single message
+func (w *KafkaWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by producing all metrics in one request
+func (w *KafkaWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var schemaID int
+	if w.registry != nil {
+		id, err := w.registry.SchemaID(ctx)
+		if err != nil {
+			return err
+		}
+		schemaID = id
+	}
+
+	messages := make([]kafka.Message, 0, len(metrics))
+	for _, metric := range metrics {
+		var payload []byte
+		if w.registry != nil {
+			payload = confluentFrame(schemaID, encodeAvroWAN(newWANMetric(metric)))
+		} else {
+			var err error
+			payload, err = marshalPayload(w.payloadFormat, newWANMetric(metric))
+			if err != nil {
+				return fmt.Errorf("failed to marshal metric: %w", err)
+			}
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(metric.SiteId),
+			Value: payload,
+		})
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	w.logger.WithField("messages", len(messages)).Debug("Producing messages to Kafka")
+
+	if err := w.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to write to Kafka: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (w *KafkaWriter) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// ListSitesCmd prints the sites the API key can see, to help pick --sites filters
+type ListSitesCmd struct {
+	CLI `kong:"embed"`
+
+	Output string `kong:"default='table',enum='table,json',help='Output format (table, json)'"`
+}
+
+// SiteSummary describes a site and its latest period for list-sites
+type SiteSummary struct {
+	SiteId     string `json:"siteId"`
+	HostId     string `json:"hostId"`
+	Account    string `json:"account,omitempty"`
+	ISPName    string `json:"ispName"`
+	ISPAsn     string `json:"ispAsn"`
+	MetricTime string `json:"metricTime,omitempty"`
+	AvgLatency *int   `json:"avgLatency,omitempty"`
+}
+
+// Run fetches the latest ISP metrics and prints one line per site
+func (c *ListSitesCmd) Run() error {
+	logger := newLogger(c.LogLevel, c.LogFormat)
+	accounts, err := newAccounts(&c.CLI, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(shutdownContext(logger), 2*time.Minute)
+	defer cancel()
+
+	metrics, err := fetchAccounts(ctx, accounts, c.MetricTypes[0], time.Time{}, time.Time{})
+	if metrics == nil {
+		return fmt.Errorf("failed to fetch ISP metrics: %w", err)
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Failed to fetch the sites of some accounts")
+	}
+
+	sites := summarizeSites(metrics)
+	if c.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sites)
+	}
+	return writeSiteTable(os.Stdout, sites)
+}
+
+// summarizeSites returns a summary of every site in metrics, including sites without
+// periods, in API order
+func summarizeSites(metrics *ISPMetrics) []SiteSummary {
+	sites := make([]SiteSummary, 0, len(metrics.Data))
+	for _, data := range metrics.Data {
+		site := SiteSummary{
+			SiteId:  data.SiteId,
+			HostId:  data.HostId,
+			Account: data.Account,
+		}
+		// The most recent period is the first one in the array
+		if len(data.Periods) > 0 {
+			latest := data.Periods[0]
+			site.ISPName = latest.Data.WAN.ISPName
+			site.ISPAsn = latest.Data.WAN.ISPAsn
+			site.MetricTime = latest.MetricTime
+			site.AvgLatency = &latest.Data.WAN.AvgLatency
+		}
+		sites = append(sites, site)
+	}
+	return sites
+}
+
+// writeSiteTable prints sites as an aligned table
+func writeSiteTable(w io.Writer, sites []SiteSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SITE ID\tHOST ID\tACCOUNT\tISP\tASN\tMETRIC TIME\tAVG LATENCY")
+	for _, site := range sites {
+		latency := "-"
+		if site.AvgLatency != nil {
+			latency = fmt.Sprintf("%d ms", *site.AvgLatency)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			site.SiteId, site.HostId, dashIfEmpty(site.Account), dashIfEmpty(site.ISPName), dashIfEmpty(site.ISPAsn), dashIfEmpty(site.MetricTime), latency)
+	}
+	return tw.Flush()
+}
+
+// dashIfEmpty returns "-" for an empty table cell
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unifiOSPrefix is the path of the Network application behind a UniFi OS console (UDM, UCG, Cloud Key Gen2)
+const unifiOSPrefix = "/proxy/network"
+
+// LocalClient polls the WAN health of a local UniFi Network controller or UniFi OS
+// console, for when api.ui.com or the internet uplink is unavailable
+type LocalClient struct {
+	baseURL    string
+	apiKey     string
+	username   string
+	password   string
+	sites      []string
+	httpClient *http.Client
+	retry      RetryPolicy
+	recorder   *ResponseRecorder
+	logger     *logrus.Logger
+
+	mu       sync.Mutex
+	prefix   string
+	loggedIn bool
+}
+
+// localResponse is the envelope of every local controller API response
+type localResponse struct {
+	Meta struct {
+		RC  string `json:"rc"`
+		Msg string `json:"msg"`
+	} `json:"meta"`
+	Data json.RawMessage `json:"data"`
+}
+
+// localSite is a site of the local controller
+type localSite struct {
+	ID   string `json:"_id"`
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+}
+
+// localHealth is a subsystem of a site's health. Only the wan subsystem is used.
+type localHealth struct {
+	Subsystem       string  `json:"subsystem"`
+	Status          string  `json:"status"`
+	GatewayMAC      string  `json:"gw_mac"`
+	ISPName         string  `json:"isp_name"`
+	ISPOrganization string  `json:"isp_organization"`
+	Latency         int     `json:"latency"`
+	RxBytesRate     float64 `json:"rx_bytes-r"`
+	TxBytesRate     float64 `json:"tx_bytes-r"`
+	UptimeStats     map[string]struct {
+		Availability   float64 `json:"availability"`
+		LatencyAverage int     `json:"latency_average"`
+	} `json:"uptime_stats"`
+}
+
+// newLocalClient creates the client of the controller at --local-url
+func newLocalClient(cli *CLI, logger *logrus.Logger) (*LocalClient, error) {
+	if cli.LocalURL == "" {
+		return nil, fmt.Errorf("--source local requires --local-url")
+	}
+	if _, err := url.ParseRequestURI(cli.LocalURL); err != nil {
+		return nil, fmt.Errorf("invalid --local-url: %w", err)
+	}
+	if cli.LocalApiKey == "" && (cli.LocalUsername == "" || cli.LocalPassword == "") {
+		return nil, fmt.Errorf("--source local requires --local-api-key or --local-username and --local-password")
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	// Consoles ship with a self-signed certificate
+	tlsConfig, err := newHTTPTLSConfig(cli.LocalTLSCAFile, cli.LocalInsecure)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newAPITransport(cli, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalClient{
+		baseURL:  strings.TrimSuffix(cli.LocalURL, "/"),
+		apiKey:   cli.LocalApiKey,
+		username: cli.LocalUsername,
+		password: cli.LocalPassword,
+		sites:    cli.LocalSites,
+		httpClient: &http.Client{
+			Timeout:   cli.ApiTimeout,
+			Transport: transport,
+			Jar:       jar,
+		},
+		retry: RetryPolicy{
+			MaxRetries: cli.ApiRetries,
+			BaseDelay:  cli.ApiRetryBase,
+			MaxDelay:   cli.ApiRetryMax,
+		},
+		recorder: newResponseRecorder(cli.RecordDir, logger),
+		logger:   logger,
+	}, nil
+}
+
+// GetISPMetricsRange reads the current WAN health of every site and returns it as a
+// single period per site, aligned to the metricType period. A controller only
+// reports current health, so begin and end must be zero.
+func (c *LocalClient) GetISPMetricsRange(ctx context.Context, metricType string, begin, end time.Time) (*ISPMetrics, error) {
+	if !begin.IsZero() || !end.IsZero() {
+		return nil, fmt.Errorf("a local controller only reports current WAN health, fetching a range requires the Ubiquiti cloud API")
+	}
+	period, err := parseDays(metricType)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported metric type %q", metricType)
+	}
+	metricTime := time.Now().UTC().Truncate(period).Format(time.RFC3339)
+
+	var sites []localSite
+	if err := c.getJSON(ctx, "/api/self/sites", &sites); err != nil {
+		return nil, fmt.Errorf("failed to list controller sites: %w", err)
+	}
+
+	metrics := &ISPMetrics{}
+	for _, site := range sites {
+		if len(c.sites) > 0 && !slices.Contains(c.sites, site.Name) {
+			continue
+		}
+
+		var health []localHealth
+		if err := c.getJSON(ctx, "/api/s/"+url.PathEscape(site.Name)+"/stat/health", &health); err != nil {
+			return nil, fmt.Errorf("failed to fetch health of site %s: %w", site.Name, err)
+		}
+
+		data := MetricData{MetricType: metricType, SiteId: site.ID}
+		for _, subsystem := range health {
+			if subsystem.Subsystem != "wan" {
+				continue
+			}
+			data.HostId = subsystem.GatewayMAC
+			data.Periods = []Period{{
+				Data:       PeriodData{WAN: localWANData(subsystem)},
+				MetricTime: metricTime,
+				Version:    "local",
+			}}
+		}
+		metrics.Data = append(metrics.Data, data)
+	}
+
+	return metrics, nil
+}
+
+// localWANData maps the wan health of a site onto the fields of the cloud API
+func localWANData(health localHealth) WANData {
+	wan := WANData{
+		AvgLatency:   health.Latency,
+		MaxLatency:   health.Latency,
+		DownloadKbps: int(math.Round(health.RxBytesRate * 8 / 1000)),
+		UploadKbps:   int(math.Round(health.TxBytesRate * 8 / 1000)),
+		ISPName:      health.ISPName,
+		Uptime:       100,
+	}
+	if wan.ISPName == "" {
+		wan.ISPName = health.ISPOrganization
+	}
+	// The monitor availability is the share of probes answered
+	if stats, ok := health.UptimeStats["WAN"]; ok {
+		if wan.AvgLatency == 0 {
+			wan.AvgLatency = stats.LatencyAverage
+			wan.MaxLatency = stats.LatencyAverage
+		}
+		wan.PacketLoss = int(math.Round(100 - stats.Availability))
+	}
+	if health.Status != "ok" {
+		wan.Uptime, wan.Downtime = 0, 100
+	}
+	return wan
+}
+
+// getJSON performs an authenticated GET request of path below the Network application
+// and decodes the data of the response into out, retrying transient failures
+func (c *LocalClient) getJSON(ctx context.Context, path string, out interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := c.getJSONOnce(ctx, path, out)
+		if err == nil || attempt >= c.retry.MaxRetries || !isRetryable(ctx, err) {
+			return err
+		}
+
+		delay := c.retry.backoff(attempt)
+		c.logger.WithError(err).WithFields(logrus.Fields{
+			"path":    path,
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Warn("Controller request failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// getJSONOnce performs a single GET request, logging in first and once more when the
+// session has expired
+func (c *LocalClient) getJSONOnce(ctx context.Context, path string, out interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loggedIn {
+		if err := c.login(ctx); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, c.prefix+path, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && c.apiKey == "" {
+		resp.Body.Close()
+		c.logger.Debug("Controller session expired, logging in again")
+		if err := c.login(ctx); err != nil {
+			return err
+		}
+		if resp, err = c.do(ctx, http.MethodGet, c.prefix+path, nil); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			c.loggedIn = false
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return &APIStatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	c.recorder.Record(c.baseURL+c.prefix+path, body)
+
+	var envelope localResponse
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if envelope.Meta.RC != "" && envelope.Meta.RC != "ok" {
+		return fmt.Errorf("controller returned %s: %s", envelope.Meta.RC, envelope.Meta.Msg)
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// login authenticates with the controller and detects whether it is a UniFi OS
+// console or a standalone Network application. An API key needs no session and is
+// only supported by UniFi OS.
+func (c *LocalClient) login(ctx context.Context) error {
+	if c.apiKey != "" {
+		c.prefix, c.loggedIn = unifiOSPrefix, true
+		return nil
+	}
+
+	credentials, err := json.Marshal(map[string]interface{}{
+		"username":   c.username,
+		"password":   c.password,
+		"rememberMe": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	prefix := unifiOSPrefix
+	resp, err := c.do(ctx, http.MethodPost, "/api/auth/login", credentials)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		// A standalone Network application has no UniFi OS login
+		resp.Body.Close()
+		prefix = ""
+		resp, err = c.do(ctx, http.MethodPost, "/api/login", credentials)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		statusErr := &APIStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("controller rejected --local-username and --local-password: %w", statusErr)
+		}
+		return fmt.Errorf("failed to log in to controller: %w", statusErr)
+	}
+
+	c.prefix, c.loggedIn = prefix, true
+	c.logger.WithFields(logrus.Fields{"url": c.baseURL, "unifi_os": prefix != ""}).Info("Logged in to UniFi controller")
+	return nil
+}
+
+// do sends a request to path on the controller
+func (c *LocalClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-KEY", c.apiKey)
+	}
+
+	c.logger.WithFields(logrus.Fields{"method": method, "path": path}).Debug("Making controller request")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return nil, fmt.Errorf("failed to make request, use --local-tls-ca-file or --local-insecure for a self-signed console certificate: %w", err)
+		}
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SiteMetadataFile represents the user supplied per-site metadata file
+type SiteMetadataFile struct {
+	Sites map[string]SiteMetadata `json:"sites"`
+}
+
+// SiteMetadata holds user supplied information about a single site
+type SiteMetadata struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Plan   *ISPPlan          `json:"plan,omitempty"`
+}
+
+// ISPPlan describes the speeds a site is contracted to receive from its ISP
+type ISPPlan struct {
+	DownloadKbps int `json:"downloadKbps"`
+	UploadKbps   int `json:"uploadKbps"`
+}
+
+// LoadSiteMetadata reads the site metadata file at path
+func LoadSiteMetadata(path string) (*SiteMetadataFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read site metadata file: %w", err)
+	}
+
+	var md SiteMetadataFile
+	if err := json.Unmarshal(raw, &md); err != nil {
+		return nil, fmt.Errorf("failed to parse site metadata file: %w", err)
+	}
+
+	for siteId, site := range md.Sites {
+		if site.Plan == nil {
+			continue
+		}
+		if site.Plan.DownloadKbps < 0 || site.Plan.UploadKbps < 0 {
+			return nil, fmt.Errorf("site %s has a negative contracted speed", siteId)
+		}
+	}
+
+	return &md, nil
+}
+
+// Site returns the metadata for siteId, if any
+func (m *SiteMetadataFile) Site(siteId string) (SiteMetadata, bool) {
+	if m == nil {
+		return SiteMetadata{}, false
+	}
+	site, ok := m.Sites[siteId]
+	return site, ok
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// checkMetricTypes validates the --metric-type list and the --metric-type-interval
+// overrides
+func checkMetricTypes(cli *CLI) error {
+	if len(cli.MetricTypes) == 0 {
+		return fmt.Errorf("--metric-type must name at least one metric type")
+	}
+	seen := make(map[string]bool, len(cli.MetricTypes))
+	for _, metricType := range cli.MetricTypes {
+		if seen[metricType] {
+			return fmt.Errorf("--metric-type lists %s more than once", metricType)
+		}
+		seen[metricType] = true
+		if period, err := parseDays(metricType); err != nil || period <= 0 {
+			return fmt.Errorf("invalid --metric-type %q, expected a period such as 5m, 1h or 1d", metricType)
+		}
+	}
+	for metricType, interval := range cli.MetricTypeIntervals {
+		if !seen[metricType] {
+			return fmt.Errorf("--metric-type-interval sets %s, which is not a --metric-type", metricType)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("--metric-type-interval of %s must be positive", metricType)
+		}
+	}
+	return nil
+}
+
+// checkMetricTypeTopics rejects a topic template that would publish the periods of
+// several metric types to the same topics
+func checkMetricTypeTopics(metricTypes []string, topicTemplate string) error {
+	if len(metricTypes) > 1 && !strings.Contains(topicTemplate, ".MetricType") {
+		return fmt.Errorf("polling several metric types requires a --mqtt-topic-template with {{.MetricType}}, e.g. '{{.BaseTopic}}/{{.SiteId}}/{{.MetricType}}/{{.Metric}}'")
+	}
+	return nil
+}
+
+// metricTypeInterval returns how often metricType is polled: its --metric-type-interval,
+// else every poll but no more than twelve times per period, as a new period of a
+// longer metric type only completes every hour or day
+func metricTypeInterval(cli *CLI, metricType string) time.Duration {
+	if interval, ok := cli.MetricTypeIntervals[metricType]; ok {
+		return interval
+	}
+	period, _ := parseDays(metricType)
+	return max(cli.pollInterval(), period/12)
+}
+
+// metricSchedule tracks when each metric type is next polled. Polls happen on the
+// ticks of --interval or --schedule, so longer intervals are rounded to a multiple
+// of the ticks.
+type metricSchedule struct {
+	cli  *CLI
+	next map[string]time.Time
+}
+
+// newMetricSchedule creates a schedule with every metric type due immediately
+func newMetricSchedule(cli *CLI) *metricSchedule {
+	return &metricSchedule{
+		cli:  cli,
+		next: make(map[string]time.Time),
+	}
+}
+
+// due reports whether metricType is to be polled at now and, if so, schedules its
+// next poll
+func (s *metricSchedule) due(metricType string, now time.Time) bool {
+	if now.Before(s.next[metricType]) {
+		return false
+	}
+	// Half a tick of slack keeps timer jitter from deferring a poll by a whole tick
+	s.next[metricType] = now.Add(metricTypeInterval(s.cli, metricType) - s.cli.pollInterval()/2)
+	return true
+}
+
+// retry makes metricType due again, after its poll was cut short by a backoff
+func (s *metricSchedule) retry(metricType string) {
+	delete(s.next, metricType)
+}
+
+// reset makes every metric type due again
+func (s *metricSchedule) reset() {
+	clear(s.next)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mockISPs are the ISPs the mock sites are connected to, with their nominal
+// throughput in kbps and latency in ms
+var mockISPs = []struct {
+	name, asn             string
+	download, upload, rtt int
+}{
+	{"Example Cable", "64500", 50000, 10000, 10},
+	{"Example Fiber", "64501", 500000, 500000, 4},
+	{"Example DSL", "64502", 20000, 2000, 25},
+	{"Example LTE", "64503", 30000, 8000, 45},
+}
+
+// mockMetricTypes are the metric types served and the length of their periods
+var mockMetricTypes = map[string]time.Duration{
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// MockServerCmd serves generated ISP metrics in the format of the EA API, for testing
+// a pipeline without a UI account
+type MockServerCmd struct {
+	Listen        string        `kong:"default=':8080',help='Listen address of the mock API, polled with --api-url http://<addr>/ea/isp-metrics'"`
+	SiteCount     int           `kong:"default='3',help='Number of sites served'"`
+	Periods       int           `kong:"default='12',help='Periods per site returned when no time range is requested'"`
+	Seed          uint64        `kong:"default='1',help='Seed of the generated sites and values, the same seed serves the same data'"`
+	PageSize      int           `kong:"help='Sites per response page, followed by a nextToken, all sites in one response when 0'"`
+	RequireApiKey string        `kong:"help='API key the X-API-KEY header must carry, any key is accepted when empty'"`
+	OutageRate    float64       `kong:"default='0.02',help='Fraction of periods in which a site is down (0-1)'"`
+	ErrorRate     float64       `kong:"help='Fraction of requests answered with a 500 error (0-1)'"`
+	RateLimitRate float64       `kong:"help='Fraction of requests answered with 429 Too Many Requests (0-1)'"`
+	RetryAfter    time.Duration `kong:"default='5s',help='Retry-After of the 429 responses'"`
+	Latency       time.Duration `kong:"help='Delay before every response'"`
+	LogLevel      string        `kong:"default='info',help='Log level (debug, info, warn, error)'"`
+	LogFormat     string        `kong:"default='text',enum='text,json',help='Log output format (text, json)'"`
+}
+
+// Run serves the mock API until interrupted
+func (c *MockServerCmd) Run() error {
+	logger := newLogger(c.LogLevel, c.LogFormat)
+
+	if c.SiteCount < 1 || c.Periods < 1 {
+		return fmt.Errorf("--site-count and --periods must be positive")
+	}
+	if c.PageSize < 0 {
+		return fmt.Errorf("--page-size must not be negative")
+	}
+	for name, rate := range map[string]float64{"--outage-rate": c.OutageRate, "--error-rate": c.ErrorRate, "--rate-limit-rate": c.RateLimitRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ea/isp-metrics/{metricType}", func(w http.ResponseWriter, r *http.Request) {
+		c.handleISPMetrics(w, r, logger)
+	})
+
+	listener, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", c.Listen, err)
+	}
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx := shutdownContext(logger)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.WithFields(logrus.Fields{
+		"addr":  listener.Addr().String(),
+		"sites": c.SiteCount,
+	}).Info("Serving mock ISP metrics API")
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handleISPMetrics answers a request for the periods of every site, injecting the
+// configured latency and errors
+func (c *MockServerCmd) handleISPMetrics(w http.ResponseWriter, r *http.Request, logger *logrus.Logger) {
+	entry := logger.WithFields(logrus.Fields{"method": r.Method, "url": r.URL.String()})
+
+	if c.Latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(c.Latency):
+		}
+	}
+
+	if c.RequireApiKey != "" && r.Header.Get("X-API-KEY") != c.RequireApiKey {
+		entry.Debug("Rejecting request without the API key")
+		writeMockError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if rand.Float64() < c.ErrorRate {
+		entry.Debug("Injecting server error")
+		writeMockError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if rand.Float64() < c.RateLimitRate {
+		entry.Debug("Injecting rate limit")
+		w.Header().Set("Retry-After", strconv.Itoa(int(c.RetryAfter.Round(time.Second).Seconds())))
+		writeMockError(w, http.StatusTooManyRequests, "Too many requests")
+		return
+	}
+
+	metricType := r.PathValue("metricType")
+	length, ok := mockMetricTypes[metricType]
+	if !ok {
+		writeMockError(w, http.StatusBadRequest, fmt.Sprintf("unsupported metric type %q", metricType))
+		return
+	}
+
+	// Without a range the latest completed periods are returned, newest first as by the API
+	end := time.Now().UTC().Truncate(length)
+	begin := end.Add(-time.Duration(c.Periods) * length)
+	for param, bound := range map[string]*time.Time{"beginTimestamp": &begin, "endTimestamp": &end} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeMockError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s: %v", param, err))
+			return
+		}
+		*bound = t.UTC()
+	}
+
+	// A page lists the sites from the offset carried by nextToken
+	first, last := 0, c.SiteCount
+	if token := r.URL.Query().Get("nextToken"); token != "" {
+		offset, err := strconv.Atoi(token)
+		if err != nil || offset <= 0 || offset >= c.SiteCount {
+			writeMockError(w, http.StatusBadRequest, fmt.Sprintf("invalid nextToken %q", token))
+			return
+		}
+		first = offset
+	}
+	if c.PageSize > 0 {
+		last = min(first+c.PageSize, c.SiteCount)
+	}
+
+	metrics := ISPMetrics{
+		Data:           make([]MetricData, 0, last-first),
+		HTTPStatusCode: http.StatusOK,
+		TraceID:        fmt.Sprintf("%032x", rand.Uint64()),
+	}
+	if last < c.SiteCount {
+		metrics.NextToken = strconv.Itoa(last)
+	}
+	for i := first; i < last; i++ {
+		data := MetricData{
+			MetricType: metricType,
+			Periods:    []Period{},
+			SiteId:     fmt.Sprintf("%024x", c.Seed<<16|uint64(i+1)),
+			HostId:     fmt.Sprintf("%060X:%d", c.Seed<<16|uint64(i+1), 1000000000+i+1),
+		}
+		for start := end.Add(-length).Truncate(length); !start.Before(begin); start = start.Add(-length) {
+			data.Periods = append(data.Periods, c.mockPeriod(i, start, length))
+		}
+		metrics.Data = append(metrics.Data, data)
+	}
+
+	// The ETag covers the periods and the next page but not the trace ID, so it only
+	// changes with a new period
+	data, err := json.Marshal([]interface{}{metrics.Data, metrics.NextToken})
+	if err != nil {
+		writeMockError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	etag := fmt.Sprintf(`"%x"`, hash.Sum64())
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		entry.Debug("Serving mock ISP metrics not modified")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	entry.WithFields(logrus.Fields{
+		"sites":     len(metrics.Data),
+		"nextToken": metrics.NextToken,
+	}).Debug("Serving mock ISP metrics")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// mockPeriod generates the period of site starting at start. Values are derived from
+// the seed, the site and the period, so every poll within a period returns the same.
+func (c *MockServerCmd) mockPeriod(site int, start time.Time, length time.Duration) Period {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d/%d", c.Seed, site)
+	rng := rand.New(rand.NewPCG(hash.Sum64(), uint64(start.Unix())))
+	isp := mockISPs[site%len(mockISPs)]
+
+	wan := WANData{
+		ISPName: isp.name,
+		ISPAsn:  isp.asn,
+	}
+	if rng.Float64() < c.OutageRate {
+		wan.PacketLoss = 100
+		wan.Downtime = int(length.Seconds())
+	} else {
+		wan.AvgLatency = isp.rtt * (80 + rng.IntN(40)) / 100
+		wan.MaxLatency = wan.AvgLatency + rng.IntN(2*isp.rtt+1)
+		wan.DownloadKbps = isp.download * (60 + rng.IntN(50)) / 100
+		wan.UploadKbps = isp.upload * (60 + rng.IntN(50)) / 100
+		if rng.Float64() < 0.1 {
+			wan.PacketLoss = 1 + rng.IntN(3)
+		}
+		wan.Uptime = 100
+	}
+
+	return Period{
+		Data:       PeriodData{WAN: wan},
+		MetricTime: start.Format(time.RFC3339),
+		Version:    "2",
+	}
+}
+
+// writeMockError writes an error response shaped like those of the API
+func writeMockError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":           http.StatusText(status),
+		"httpStatusCode": status,
+		"message":        message,
+	})
+}
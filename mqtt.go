@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// LatencyMetric represents simplified latency data for MQTT publishing
+type LatencyMetric struct {
+	SiteId      string            `json:"siteId"`
+	SiteName    string            `json:"siteName,omitempty"`
+	HostId      string            `json:"hostId"`
+	HostName    string            `json:"hostName,omitempty"`
+	Timestamp   string            `json:"timestamp"`
+	MetricType  string            `json:"metricType"`
+	AvgLatency  int               `json:"avgLatency"`
+	MaxLatency  int               `json:"maxLatency"`
+	ISPName     string            `json:"ispName"`
+	ISPAsn      string            `json:"ispAsn"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	PublishedAt time.Time         `json:"publishedAt"`
+}
+
+// WANMetric represents the full WAN data of a site's latest period for MQTT publishing
+type WANMetric struct {
+	SiteId       string            `json:"siteId"`
+	SiteName     string            `json:"siteName,omitempty"`
+	HostId       string            `json:"hostId"`
+	HostName     string            `json:"hostName,omitempty"`
+	Timestamp    string            `json:"timestamp"`
+	MetricType   string            `json:"metricType"`
+	AvgLatency   int               `json:"avgLatency"`
+	MaxLatency   int               `json:"maxLatency"`
+	DownloadKbps int               `json:"downloadKbps"`
+	UploadKbps   int               `json:"uploadKbps"`
+	PacketLoss   int               `json:"packetLoss"`
+	Uptime       int               `json:"uptime"`
+	Downtime     int               `json:"downtime"`
+	ISPName      string            `json:"ispName"`
+	ISPAsn       string            `json:"ispAsn"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	PublishedAt  time.Time         `json:"publishedAt"`
+}
+
+// Payloads published to the availability topic
+const (
+	availabilityOnline  = "online"
+	availabilityOffline = "offline"
+)
+
+// schemaTopic returns the topic carrying the protobuf payload schema
+func schemaTopic(baseTopic string) string {
+	return baseTopic + "/schema"
+}
+
+// availabilityTopic returns the topic carrying the poller's online/offline status
+func availabilityTopic(baseTopic string) string {
+	return baseTopic + "/status"
+}
+
+// userProperty is an MQTT 5 user property
+type userProperty struct {
+	key   string
+	value string
+}
+
+// messageProperties are the MQTT 5 properties of a published message, dropped on
+// MQTT 3.1.1 connections
+type messageProperties struct {
+	expiry time.Duration
+	user   []userProperty
+}
+
+// retainedMessage is a message published retained with QoS 1 on every (re)connect
+type retainedMessage struct {
+	topic   string
+	payload []byte
+}
+
+// mqttConn is a broker connection speaking MQTT 3.1.1 or MQTT 5
+type mqttConn interface {
+	// publish sends a message and waits for the broker to acknowledge it
+	publish(topic string, qos byte, retain bool, payload []byte, props *messageProperties) error
+	// isOpen reports whether the connection is currently up
+	isOpen() bool
+	// disconnect closes the connection, without publishing the will
+	disconnect()
+}
+
+// mqtt3Conn is an MQTT 3.1.1 connection, which reconnects automatically
+type mqtt3Conn struct {
+	client mqtt.Client
+}
+
+func (c *mqtt3Conn) publish(topic string, qos byte, retain bool, payload []byte, props *messageProperties) error {
+	token := c.client.Publish(topic, qos, retain, payload)
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (c *mqtt3Conn) isOpen() bool {
+	return c.client.IsConnectionOpen()
+}
+
+func (c *mqtt3Conn) disconnect() {
+	c.client.Disconnect(250)
+}
+
+// reconnectTracker logs and counts the reconnect attempts of a connection, numbering
+// them per outage, and remembers which of the --mqtt-broker URLs was dialed last
+type reconnectTracker struct {
+	telemetry *Telemetry
+	logger    *logrus.Logger
+	attempts  atomic.Int64
+	broker    atomic.Value // string
+}
+
+// dialing records the broker a connection attempt is made to
+func (r *reconnectTracker) dialing(broker *url.URL) {
+	r.broker.Store(broker.Redacted())
+}
+
+// lost records a lost connection
+func (r *reconnectTracker) lost(err error) {
+	if r.telemetry != nil {
+		r.telemetry.RecordMQTTConnectionLost()
+	}
+	entry := logrus.NewEntry(r.logger)
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Error("Lost connection to MQTT broker")
+}
+
+// attempt records an attempt to reconnect
+func (r *reconnectTracker) attempt() {
+	if r.telemetry != nil {
+		r.telemetry.RecordMQTTReconnect()
+	}
+	r.logger.WithField("attempt", r.attempts.Add(1)).Warn("Reconnecting to MQTT broker")
+}
+
+// connected logs a (re)connect, ending the current outage
+func (r *reconnectTracker) connected(fields logrus.Fields) {
+	entry := r.logger.WithFields(fields)
+	if broker, ok := r.broker.Load().(string); ok {
+		entry = entry.WithField("broker", broker)
+	}
+	if n := r.attempts.Swap(0); n > 0 {
+		entry = entry.WithField("attempts", n)
+	}
+	entry.Info("Connected to MQTT broker")
+}
+
+// MQTTPublisher handles MQTT publishing
+type MQTTPublisher struct {
+	conn             mqttConn
+	topic            string
+	topicTemplate    *template.Template
+	payloadTemplates map[string]*template.Template
+	payloadFormat    string
+	qos              byte
+	retain           bool
+	payloadLogger    *PayloadLogger
+	gzipThreshold    int
+	gzipSuffix       string
+	awsIoT           bool
+	mqtt5            bool
+	messageExpiry    time.Duration
+	limiter          *publishLimiter
+	dryRun           io.Writer
+	logger           *logrus.Logger
+}
+
+// NewMQTTPublisher creates a new MQTT publisher. With --dry-run it prints every
+// message to stdout instead of connecting to the broker.
+func NewMQTTPublisher(cli *CLI, telemetry *Telemetry, logger *logrus.Logger) (*MQTTPublisher, error) {
+	topicTemplate, err := parseTopicTemplate(cli.MqttTopicTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var payloadTemplates map[string]*template.Template
+	if cli.MqttPayloadTemplates != "" {
+		payloadTemplates, err = LoadPayloadTemplates(cli.MqttPayloadTemplates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limiter, err := newPublishLimiter(cli.MqttMaxRate, cli.MqttPublishDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	var payloadLogger *PayloadLogger
+	if cli.LogPayloads {
+		payloadLogger = NewPayloadLogger(cli.LogPayloadsSample, cli.LogPayloadsChangesOnly, logger)
+	}
+
+	publisher := &MQTTPublisher{
+		topic:            cli.MqttTopic,
+		topicTemplate:    topicTemplate,
+		payloadTemplates: payloadTemplates,
+		payloadFormat:    cli.PayloadFormat,
+		qos:              byte(cli.MqttQoS),
+		retain:           cli.MqttRetain,
+		payloadLogger:    payloadLogger,
+		gzipThreshold:    cli.MqttGzipThreshold,
+		gzipSuffix:       cli.MqttGzipTopicSuffix,
+		awsIoT:           cli.MqttAwsIot,
+		mqtt5:            cli.MqttVersion == "5",
+		messageExpiry:    cli.MqttMessageExpiry,
+		limiter:          limiter,
+		logger:           logger,
+	}
+	if cli.DryRun {
+		publisher.dryRun = os.Stdout
+		return publisher, nil
+	}
+
+	var schema []byte
+	if cli.PublishPayloadSchema {
+		schema, err = payloadSchemaSet()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode payload schema: %w", err)
+		}
+	}
+
+	// Consumers watching the status topic see "offline" when the poller goes away.
+	// The online status runs on every (re)connect, replacing the retained will message.
+	statusTopic := availabilityTopic(cli.MqttTopic)
+	will := retainedMessage{topic: statusTopic, payload: []byte(availabilityOffline)}
+	birth := []retainedMessage{{topic: statusTopic, payload: []byte(availabilityOnline)}}
+	if schema != nil {
+		birth = append(birth, retainedMessage{topic: schemaTopic(cli.MqttTopic), payload: schema})
+	}
+
+	reconnects := &reconnectTracker{telemetry: telemetry, logger: logger}
+	if publisher.mqtt5 {
+		publisher.conn, err = newMQTT5Conn(cli, cli.MqttClientID, &will, birth, reconnects)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	}
+
+	opts, err := newMQTTClientOptions(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
+		logger.WithFields(logrus.Fields{
+			"topic":   msg.Topic(),
+			"payload": string(msg.Payload()),
+		}).Debug("Received message")
+	})
+
+	opts.SetBinaryWill(will.topic, will.payload, 1, true)
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		reconnects.connected(nil)
+		for _, msg := range birth {
+			client.Publish(msg.topic, 1, true, msg.payload)
+		}
+	})
+
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		reconnects.lost(err)
+	})
+	opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+		reconnects.attempt()
+	})
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsConfig *tls.Config) *tls.Config {
+		reconnects.dialing(broker)
+		return tlsConfig
+	})
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if cli.MqttConnectRetry {
+		// The token completes once connected, metrics are buffered until then
+		logger.WithField("brokers", strings.Join(cli.MqttBroker, ", ")).Info("Connecting to MQTT broker in the background")
+	} else if token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	publisher.conn = &mqtt3Conn{client: client}
+	return publisher, nil
+}
+
+// newMQTTClientOptions returns the broker, credential and TLS options shared by every client
+func newMQTTClientOptions(cli *CLI) (*mqtt.ClientOptions, error) {
+	if cli.MqttAwsIot {
+		if err := checkAWSIoTConfig(cli); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := mqtt.NewClientOptions()
+	// paho tries the brokers in order on every (re)connect, so the first is preferred
+	for _, broker := range cli.MqttBroker {
+		opts.AddBroker(broker)
+	}
+	opts.SetClientID(cli.MqttClientID)
+	opts.SetCleanSession(cli.MqttCleanSession)
+	opts.SetOrderMatters(cli.MqttOrderMatters)
+	opts.SetConnectTimeout(cli.MqttConnectTimeout)
+	opts.SetConnectRetry(cli.MqttConnectRetry)
+	opts.SetConnectRetryInterval(cli.MqttConnectRetryInterval)
+	opts.SetAutoReconnect(cli.MqttAutoReconnect)
+	opts.SetMaxReconnectInterval(cli.MqttMaxReconnectInterval)
+
+	if cli.MqttUsername != "" {
+		opts.SetUsername(cli.MqttUsername)
+	}
+	if cli.MqttPassword != "" {
+		opts.SetPassword(cli.MqttPassword)
+	}
+
+	tlsConfig, err := newMQTTTLSConfig(cli)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure MQTT TLS: %w", err)
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	ws, err := newMQTTWebsocketConfig(cli)
+	if err != nil {
+		return nil, err
+	}
+	opts.SetHTTPHeaders(ws.header)
+	opts.SetWebsocketOptions(&mqtt.WebsocketOptions{Proxy: ws.proxy})
+	return opts, nil
+}
+
+// Publish publishes metrics to MQTT (legacy method - kept for compatibility)
+func (p *MQTTPublisher) Publish(metrics *ISPMetrics) error {
+	payload, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":        p.topic,
+		"payload_size": len(payload),
+	}).Debug("Publishing metrics to MQTT")
+
+	if err := p.send(p.topic, p.qos, p.retain, payload); err != nil {
+		return fmt.Errorf("failed to publish to MQTT: %w", err)
+	}
+
+	return nil
+}
+
+// PublishLatency publishes latency metric to the site's topic
+func (p *MQTTPublisher) PublishLatency(latencyMetric LatencyMetric, site Metric) error {
+	payload, err := p.encodePayload("latency", latencyMetric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal latency metric: %w", err)
+	}
+
+	topic, err := p.siteTopic("latency", site)
+	if err != nil {
+		return err
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"siteId":       latencyMetric.SiteId,
+		"avgLatency":   latencyMetric.AvgLatency,
+		"maxLatency":   latencyMetric.MaxLatency,
+		"payload_size": len(payload),
+	}).Debug("Publishing latency metric to MQTT")
+
+	if err := p.sendSite(topic, site, payload); err != nil {
+		return fmt.Errorf("failed to publish latency to MQTT: %w", err)
+	}
+
+	return nil
+}
+
+// PublishWAN publishes full WAN metrics to the site's topic
+func (p *MQTTPublisher) PublishWAN(wanMetric WANMetric, site Metric) error {
+	payload, err := p.encodePayload("wan", wanMetric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal WAN metric: %w", err)
+	}
+
+	topic, err := p.siteTopic("wan", site)
+	if err != nil {
+		return err
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"siteId":       wanMetric.SiteId,
+		"payload_size": len(payload),
+	}).Debug("Publishing WAN metric to MQTT")
+
+	if err := p.sendSite(topic, site, payload); err != nil {
+		return fmt.Errorf("failed to publish WAN metric to MQTT: %w", err)
+	}
+
+	return nil
+}
+
+// PublishPlan publishes plan attainment to the site's topic
+func (p *MQTTPublisher) PublishPlan(planMetric PlanMetric, site Metric) error {
+	payload, err := p.encodePayload("plan", planMetric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan metric: %w", err)
+	}
+
+	topic, err := p.siteTopic("plan", site)
+	if err != nil {
+		return err
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":              topic,
+		"siteId":             planMetric.SiteId,
+		"downloadAttainment": planMetric.DownloadAttainment,
+		"uploadAttainment":   planMetric.UploadAttainment,
+		"payload_size":       len(payload),
+	}).Debug("Publishing plan metric to MQTT")
+
+	if err := p.sendSite(topic, site, payload); err != nil {
+		return fmt.Errorf("failed to publish plan to MQTT: %w", err)
+	}
+
+	return nil
+}
+
+// PublishCounters publishes counter values and deltas to the site's topic
+func (p *MQTTPublisher) PublishCounters(counterMetric CounterMetric, site Metric) error {
+	payload, err := p.encodePayload("counters", counterMetric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal counter metric: %w", err)
+	}
+
+	topic, err := p.siteTopic("counters", site)
+	if err != nil {
+		return err
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"siteId":       counterMetric.SiteId,
+		"payload_size": len(payload),
+	}).Debug("Publishing counter metric to MQTT")
+
+	if err := p.sendSite(topic, site, payload); err != nil {
+		return fmt.Errorf("failed to publish counters to MQTT: %w", err)
+	}
+
+	return nil
+}
+
+// PublishScalars publishes each WAN value of a site to its own topic, named after the
+// value, with the plain number as payload
+func (p *MQTTPublisher) PublishScalars(site Metric) error {
+	values := wanValues(site.WAN)
+	for _, v := range values {
+		topic, err := p.siteTopic(v.name, site)
+		if err != nil {
+			return err
+		}
+		if err := p.sendSite(topic, site, []byte(strconv.Itoa(v.value))); err != nil {
+			return fmt.Errorf("failed to publish %s to MQTT: %w", v.name, err)
+		}
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"siteId": site.SiteId,
+		"values": len(values),
+	}).Debug("Published scalar metrics to MQTT")
+	return nil
+}
+
+// siteTopic renders the topic of metric (latency, wan, plan, counters, or a scalar
+// value name) for a site
+func (p *MQTTPublisher) siteTopic(metric string, site Metric) (string, error) {
+	return renderTopic(p.topicTemplate, newTopicData(p.topic, metric, site))
+}
+
+// PublishRaw publishes a pre-rendered payload to an arbitrary topic
+func (p *MQTTPublisher) PublishRaw(topic string, qos byte, retain bool, payload []byte) error {
+	p.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"retain":       retain,
+		"payload_size": len(payload),
+	}).Debug("Publishing raw message to MQTT")
+
+	if err := p.send(topic, qos, retain, payload); err != nil {
+		return fmt.Errorf("failed to publish to MQTT: %w", err)
+	}
+
+	return nil
+}
+
+// sendSite publishes a site's metric payload, carrying the site in MQTT 5 user properties
+func (p *MQTTPublisher) sendSite(topic string, site Metric, payload []byte) error {
+	return p.publish(topic, p.qos, p.retain, payload, &messageProperties{
+		expiry: p.messageExpiry,
+		user: []userProperty{
+			{key: "siteId", value: site.SiteId},
+			{key: "metricType", value: site.MetricType},
+		},
+	})
+}
+
+// send publishes payload and waits for the broker to acknowledge it
+func (p *MQTTPublisher) send(topic string, qos byte, retain bool, payload []byte) error {
+	return p.publish(topic, qos, retain, payload, nil)
+}
+
+// publish publishes payload with props and waits for the broker to acknowledge it
+func (p *MQTTPublisher) publish(topic string, qos byte, retain bool, payload []byte, props *messageProperties) error {
+	body := payload
+	compressed := p.gzipThreshold > 0 && len(payload) > p.gzipThreshold
+	if compressed {
+		var err error
+		body, err = gzipPayload(payload)
+		if err != nil {
+			return err
+		}
+		// Consumers subscribed over MQTT 3.1.1 see no message properties, so the topic tells them to decompress
+		topic += p.gzipSuffix
+	}
+
+	if p.awsIoT {
+		if err := checkAWSIoTTopic(topic); err != nil {
+			return err
+		}
+	}
+
+	if p.dryRun != nil {
+		flags := fmt.Sprintf("qos=%d retain=%t", qos, retain)
+		if compressed {
+			flags += fmt.Sprintf(" gzip=%d bytes", len(body))
+		}
+		if p.mqtt5 && props != nil {
+			for _, prop := range props.user {
+				flags += fmt.Sprintf(" %s=%s", prop.key, prop.value)
+			}
+			if props.expiry > 0 {
+				flags += fmt.Sprintf(" expiry=%s", props.expiry)
+			}
+		}
+		_, err := fmt.Fprintf(p.dryRun, "%s (%s) %s\n", topic, flags, payload)
+		return err
+	}
+
+	// While reconnecting paho silently discards QoS 0 messages, so fail instead
+	if !p.conn.isOpen() {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+
+	p.limiter.wait()
+	if err := p.conn.publish(topic, qos, retain, body, props); err != nil {
+		return err
+	}
+	p.payloadLogger.Log(topic, payload)
+	return nil
+}
+
+// gzipPayload compresses a payload exceeding --mqtt-gzip-threshold
+func gzipPayload(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// IsConnected reports whether the client currently has a connection to the broker
+func (p *MQTTPublisher) IsConnected() bool {
+	if p.dryRun != nil {
+		return true
+	}
+	return p.conn.isOpen()
+}
+
+// Disconnect disconnects from MQTT broker
+func (p *MQTTPublisher) Disconnect() {
+	if p.dryRun != nil {
+		return
+	}
+	p.logger.Info("Disconnecting from MQTT broker")
+
+	// A clean disconnect does not trigger the will, so report offline explicitly
+	if err := p.send(availabilityTopic(p.topic), 1, true, []byte(availabilityOffline)); err != nil {
+		p.logger.WithError(err).Warn("Failed to publish offline status")
+	}
+	p.conn.disconnect()
+}
+
+// newLatencyMetric builds the latency payload for a metric
+func newLatencyMetric(m Metric) LatencyMetric {
+	return LatencyMetric{
+		SiteId:      m.SiteId,
+		SiteName:    m.Labels["site_name"],
+		HostId:      m.HostId,
+		HostName:    m.Labels["host_name"],
+		Timestamp:   m.Timestamp,
+		MetricType:  m.MetricType,
+		AvgLatency:  m.WAN.AvgLatency,
+		MaxLatency:  m.WAN.MaxLatency,
+		ISPName:     m.WAN.ISPName,
+		ISPAsn:      m.WAN.ISPAsn,
+		Labels:      m.Labels,
+		PublishedAt: m.PublishedAt,
+	}
+}
+
+// newWANMetric builds the full WAN payload for a metric
+func newWANMetric(m Metric) WANMetric {
+	return WANMetric{
+		SiteId:       m.SiteId,
+		SiteName:     m.Labels["site_name"],
+		HostId:       m.HostId,
+		HostName:     m.Labels["host_name"],
+		Timestamp:    m.Timestamp,
+		MetricType:   m.MetricType,
+		AvgLatency:   m.WAN.AvgLatency,
+		MaxLatency:   m.WAN.MaxLatency,
+		DownloadKbps: m.WAN.DownloadKbps,
+		UploadKbps:   m.WAN.UploadKbps,
+		PacketLoss:   m.WAN.PacketLoss,
+		Uptime:       m.WAN.Uptime,
+		Downtime:     m.WAN.Downtime,
+		ISPName:      m.WAN.ISPName,
+		ISPAsn:       m.WAN.ISPAsn,
+		Labels:       m.Labels,
+		PublishedAt:  m.PublishedAt,
+	}
+}
+
+// MQTTSink publishes metrics to per-site MQTT topics
+type MQTTSink struct {
+	publisher  *MQTTPublisher
+	publishWAN bool
+	topicMode  string
+	workers    int
+}
+
+// NewMQTTSink creates a sink publishing the latency topic, and the WAN topic when
+// publishWAN is set. topicMode (object, scalar, both) selects whether these payloads,
+// a topic per WAN value, or both are published. Up to workers sites are published
+// at the same time.
+func NewMQTTSink(publisher *MQTTPublisher, publishWAN bool, topicMode string, workers int) *MQTTSink {
+	return &MQTTSink{
+		publisher:  publisher,
+		publishWAN: publishWAN,
+		topicMode:  topicMode,
+		workers:    workers,
+	}
+}
+
+// Name implements Sink
+func (s *MQTTSink) Name() string {
+	return "mqtt"
+}
+
+// Publish implements Sink
+func (s *MQTTSink) Publish(ctx context.Context, metric Metric) error {
+	if s.topicMode != "scalar" {
+		if err := s.publisher.PublishLatency(newLatencyMetric(metric), metric); err != nil {
+			return err
+		}
+		if s.publishWAN {
+			if err := s.publisher.PublishWAN(newWANMetric(metric), metric); err != nil {
+				return err
+			}
+		}
+	}
+	if s.topicMode != "object" {
+		return s.publisher.PublishScalars(metric)
+	}
+	return nil
+}
+
+// PublishConcurrent implements ConcurrentSink, so a slow broker acknowledging every
+// message does not add up over many sites
+func (s *MQTTSink) PublishConcurrent(ctx context.Context, metrics []Metric) []error {
+	return publishConcurrently(ctx, metrics, s.workers, s.Publish)
+}
+
+// Close implements Sink
+func (s *MQTTSink) Close() error {
+	s.publisher.Disconnect()
+	return nil
+}
+
+// MQTTBatchSink is the MQTTSink of --mqtt-batch, publishing all metrics of a poll as
+// one JSON array to a single topic instead of a message per site
+type MQTTBatchSink struct {
+	*MQTTSink
+	// topic is --mqtt-batch-topic, or empty for <mqtt-topic>/batch
+	topic string
+}
+
+// PublishBatch implements BatchSink
+func (s *MQTTBatchSink) PublishBatch(ctx context.Context, metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	payloads := make([]WANMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		payloads = append(payloads, newWANMetric(metric))
+	}
+	payload, err := json.Marshal(payloads)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	topic := s.topic
+	if topic == "" {
+		topic = s.publisher.topic + "/batch"
+	}
+	return s.publisher.PublishRaw(topic, s.publisher.qos, s.publisher.retain, payload)
+}
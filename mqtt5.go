@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/sirupsen/logrus"
+)
+
+// mqtt5Timeout bounds connecting to the broker and waiting for a publish to be acknowledged
+const mqtt5Timeout = 30 * time.Second
+
+// MQTT 5 reason codes, see section 2.4 of the MQTT 5 specification
+const (
+	mqttReasonUnspecified          = 0x80
+	mqttReasonMalformedPacket      = 0x81
+	mqttReasonProtocolError        = 0x82
+	mqttReasonImplementationError  = 0x83
+	mqttReasonUnsupportedVersion   = 0x84
+	mqttReasonBadCredentials       = 0x86
+	mqttReasonNotAuthorized        = 0x87
+	mqttReasonServerUnavailable    = 0x88
+	mqttReasonServerBusy           = 0x89
+	mqttReasonBanned               = 0x8A
+	mqttReasonServerShuttingDown   = 0x8B
+	mqttReasonTopicNameInvalid     = 0x90
+	mqttReasonPacketIDInUse        = 0x91
+	mqttReasonPacketTooLarge       = 0x95
+	mqttReasonQuotaExceeded        = 0x97
+	mqttReasonPayloadFormatInvalid = 0x99
+	mqttReasonRetainNotSupported   = 0x9A
+	mqttReasonQoSNotSupported      = 0x9B
+	mqttReasonUseAnotherServer     = 0x9C
+	mqttReasonServerMoved          = 0x9D
+	mqttReasonConnectionRateLimit  = 0x9F
+)
+
+// mqttReasonNames are the names of the reason codes a broker may reject a connection or publish with
+var mqttReasonNames = map[byte]string{
+	mqttReasonUnspecified:          "unspecified error",
+	mqttReasonMalformedPacket:      "malformed packet",
+	mqttReasonProtocolError:        "protocol error",
+	mqttReasonImplementationError:  "implementation specific error",
+	mqttReasonUnsupportedVersion:   "unsupported protocol version",
+	mqttReasonBadCredentials:       "bad user name or password",
+	mqttReasonNotAuthorized:        "not authorized",
+	mqttReasonServerUnavailable:    "server unavailable",
+	mqttReasonServerBusy:           "server busy",
+	mqttReasonBanned:               "banned",
+	mqttReasonServerShuttingDown:   "server shutting down",
+	mqttReasonTopicNameInvalid:     "topic name invalid",
+	mqttReasonPacketIDInUse:        "packet identifier in use",
+	mqttReasonPacketTooLarge:       "packet too large",
+	mqttReasonQuotaExceeded:        "quota exceeded",
+	mqttReasonPayloadFormatInvalid: "payload format invalid",
+	mqttReasonRetainNotSupported:   "retain not supported",
+	mqttReasonQoSNotSupported:      "QoS not supported",
+	mqttReasonUseAnotherServer:     "use another server",
+	mqttReasonServerMoved:          "server moved",
+	mqttReasonConnectionRateLimit:  "connection rate exceeded",
+}
+
+// MQTTReasonError is returned when an MQTT 5 broker rejects a connection or publish
+// with a reason code
+type MQTTReasonError struct {
+	Op         string
+	ReasonCode byte
+	// Reason is the broker's optional reason string
+	Reason string
+}
+
+func (e *MQTTReasonError) Error() string {
+	name, ok := mqttReasonNames[e.ReasonCode]
+	if !ok {
+		name = "unknown reason"
+	}
+	msg := fmt.Sprintf("broker rejected %s with reason code 0x%02X (%s)", e.Op, e.ReasonCode, name)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if hint := e.hint(); hint != "" {
+		msg += ", " + hint
+	}
+	return msg
+}
+
+// hint names the option most likely at fault for reason codes caused by the configuration
+func (e *MQTTReasonError) hint() string {
+	switch e.ReasonCode {
+	case mqttReasonUnsupportedVersion:
+		return "the broker does not support MQTT 5, use --mqtt-version 3.1.1"
+	case mqttReasonBadCredentials:
+		return "check --mqtt-username and --mqtt-password"
+	case mqttReasonNotAuthorized:
+		return "check the broker ACL for --mqtt-client-id and the topic"
+	case mqttReasonPacketTooLarge:
+		return "lower --mqtt-gzip-threshold to compress large payloads"
+	case mqttReasonRetainNotSupported:
+		return "disable --mqtt-retain"
+	case mqttReasonQoSNotSupported:
+		return "lower --mqtt-qos"
+	}
+	return ""
+}
+
+// retryable reports whether the broker may accept the same message when sent again.
+// Rejections caused by the message or the configuration repeat on every attempt.
+func (e *MQTTReasonError) retryable() bool {
+	switch e.ReasonCode {
+	case mqttReasonMalformedPacket, mqttReasonProtocolError, mqttReasonBadCredentials,
+		mqttReasonNotAuthorized, mqttReasonBanned, mqttReasonTopicNameInvalid,
+		mqttReasonPacketTooLarge, mqttReasonPayloadFormatInvalid,
+		mqttReasonRetainNotSupported, mqttReasonQoSNotSupported:
+		return false
+	}
+	return true
+}
+
+// topicAliases assigns MQTT 5 topic aliases to the first topics published on a
+// connection, so later publishes to them send a two-byte alias instead of the topic.
+// Aliases are scoped to a connection and reset whenever a new one is made.
+type topicAliases struct {
+	limit uint16
+	// persistent is set when QoS 1 and 2 messages outlive the connection in the session,
+	// as paho resends them verbatim and an alias would not be known on the new connection
+	persistent bool
+
+	mu      sync.Mutex
+	max     uint16
+	aliases map[string]uint16
+}
+
+// reset drops every alias, allowing up to the smaller of max and the configured limit
+func (t *topicAliases) reset(max uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.max = min(max, t.limit)
+	t.aliases = make(map[string]uint16)
+}
+
+// hook is a paho publish hook replacing the topic by its alias once the broker knows it
+func (t *topicAliases) hook(p *paho.Publish) {
+	if t.persistent && p.QoS > 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.Properties == nil {
+		p.Properties = &paho.PublishProperties{}
+	}
+	if alias, ok := t.aliases[p.Topic]; ok {
+		p.Properties.TopicAlias = paho.Uint16(alias)
+		p.Topic = ""
+		return
+	}
+	// The first publish carries both topic and alias, registering the alias with the broker
+	if len(t.aliases) < int(t.max) {
+		alias := uint16(len(t.aliases) + 1)
+		t.aliases[p.Topic] = alias
+		p.Properties.TopicAlias = paho.Uint16(alias)
+	}
+}
+
+// mqtt5Conn is an MQTT 5 connection, which reconnects automatically
+type mqtt5Conn struct {
+	cm            *autopaho.ConnectionManager
+	aliases       *topicAliases
+	open          atomic.Bool
+	connectedOnce atomic.Bool
+}
+
+// newMQTT5Conn connects to the broker over MQTT 5, registering will (if set) and
+// publishing birth on every (re)connect. Unless --mqtt-connect-retry is set, it waits
+// for the first connection and fails if the broker rejects it.
+func newMQTT5Conn(cli *CLI, clientID string, will *retainedMessage, birth []retainedMessage, reconnects *reconnectTracker) (*mqtt5Conn, error) {
+	logger := reconnects.logger
+
+	if cli.MqttAwsIot {
+		if err := checkAWSIoTConfig(cli); err != nil {
+			return nil, err
+		}
+	}
+
+	brokers, err := parseBrokerURLs(cli.MqttBroker)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newMQTTTLSConfig(cli)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure MQTT TLS: %w", err)
+	}
+	ws, err := newMQTTWebsocketConfig(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &mqtt5Conn{
+		aliases: &topicAliases{
+			limit:      uint16(cli.MqttTopicAliases),
+			persistent: cli.MqttSessionExpiry > 0,
+		},
+	}
+	connectErr := make(chan error, len(brokers))
+
+	cfg := autopaho.ClientConfig{
+		// Every (re)connect tries the brokers in order, so the first is preferred
+		ServerUrls: brokers,
+		TlsCfg:     tlsConfig,
+		WebSocketCfg: &autopaho.WebSocketConfig{
+			Dialer: ws.dialer,
+			Header: ws.requestHeader,
+		},
+		KeepAlive:      30,
+		ConnectTimeout: cli.MqttConnectTimeout,
+		// Only the first connection starts clean, reconnects resume the session
+		CleanStartOnInitialConnection: cli.MqttCleanSession,
+		SessionExpiryInterval:         uint32(cli.MqttSessionExpiry.Seconds()),
+		// Until the first connection, attempts are spaced by --mqtt-connect-retry-interval
+		ReconnectBackoff: func(attempt int) time.Duration {
+			if attempt <= 0 {
+				return 0
+			}
+			if !conn.connectedOnce.Load() {
+				return cli.MqttConnectRetryInterval
+			}
+			return RetryPolicy{BaseDelay: time.Second, MaxDelay: cli.MqttMaxReconnectInterval}.backoff(attempt - 1)
+		},
+		// The first packets of a new connection must not use the previous connection's aliases
+		ConnectPacketBuilder: func(cp *paho.Connect, u *url.URL) (*paho.Connect, error) {
+			if conn.connectedOnce.Load() {
+				reconnects.attempt()
+			}
+			reconnects.dialing(u)
+			conn.aliases.reset(0)
+			return cp, nil
+		},
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+			conn.open.Store(true)
+			conn.connectedOnce.Store(true)
+			var aliasMax uint16
+			if connack.Properties != nil && connack.Properties.TopicAliasMaximum != nil {
+				aliasMax = *connack.Properties.TopicAliasMaximum
+			}
+			conn.aliases.reset(aliasMax)
+			reconnects.connected(logrus.Fields{"topic_alias_maximum": aliasMax})
+
+			// Callbacks must not block, so publish the birth messages asynchronously
+			go func() {
+				for _, msg := range birth {
+					if err := conn.publish(msg.topic, 1, true, msg.payload, nil); err != nil {
+						logger.WithError(err).WithField("topic", msg.topic).Warn("Failed to publish connect message")
+					}
+				}
+			}()
+		},
+		OnConnectionDown: func() bool {
+			conn.open.Store(false)
+			reconnects.lost(nil)
+			return cli.MqttAutoReconnect
+		},
+		OnConnectError: func(err error) {
+			err = mqttConnectError(err)
+			logger.WithError(err).Warn("Failed to connect to MQTT broker")
+			select {
+			case connectErr <- err:
+			default:
+			}
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				fields := logrus.Fields{"reason_code": fmt.Sprintf("0x%02X", d.ReasonCode)}
+				if d.Properties != nil && d.Properties.ReasonString != "" {
+					fields["reason"] = d.Properties.ReasonString
+				}
+				logger.WithFields(fields).Error("MQTT broker closed the connection")
+			},
+			OnClientError: func(err error) {
+				logger.WithError(err).Error("MQTT client error")
+			},
+			PublishHook: conn.aliases.hook,
+		},
+	}
+	if cli.MqttUsername != "" || cli.MqttPassword != "" {
+		cfg.SetUsernamePassword(cli.MqttUsername, []byte(cli.MqttPassword))
+	}
+	if will != nil {
+		cfg.SetWillMessage(will.topic, will.payload, 1, true)
+	}
+
+	cm, err := autopaho.NewConnection(context.Background(), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	conn.cm = cm
+
+	if cli.MqttConnectRetry {
+		// Publishes fail until connected, so metrics are buffered until then
+		logger.WithField("brokers", strings.Join(cli.MqttBroker, ", ")).Info("Connecting to MQTT broker in the background")
+		return conn, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout+time.Duration(len(brokers))*cli.MqttConnectTimeout)
+	defer cancel()
+	connected := make(chan error, 1)
+	go func() {
+		connected <- cm.AwaitConnection(ctx)
+	}()
+
+	// Only fail once every broker refused the first connection
+	var errs []error
+	for {
+		select {
+		case err := <-connected:
+			if err == nil {
+				return conn, nil
+			}
+			conn.disconnect()
+			return nil, fmt.Errorf("timed out connecting to MQTT broker %s", strings.Join(cli.MqttBroker, ", "))
+		case err := <-connectErr:
+			if errs = append(errs, err); len(errs) < len(brokers) {
+				continue
+			}
+			conn.disconnect()
+			return nil, fmt.Errorf("failed to connect to MQTT broker: %w", errors.Join(errs...))
+		}
+	}
+}
+
+// parseBrokerURLs parses the --mqtt-broker URLs
+func parseBrokerURLs(brokers []string) ([]*url.URL, error) {
+	urls := make([]*url.URL, 0, len(brokers))
+	for _, broker := range brokers {
+		u, err := url.Parse(broker)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MQTT broker URL: %w", err)
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// mqttConnectError converts a CONNACK rejection into an MQTTReasonError
+func mqttConnectError(err error) error {
+	var connackErr *autopaho.ConnackError
+	if errors.As(err, &connackErr) {
+		return &MQTTReasonError{Op: "connect", ReasonCode: connackErr.ReasonCode, Reason: connackErr.Reason}
+	}
+	return err
+}
+
+func (c *mqtt5Conn) publish(topic string, qos byte, retain bool, payload []byte, props *messageProperties) error {
+	pub := &paho.Publish{
+		Topic:      topic,
+		QoS:        qos,
+		Retain:     retain,
+		Payload:    payload,
+		Properties: &paho.PublishProperties{},
+	}
+	if props != nil {
+		if props.expiry > 0 {
+			pub.Properties.MessageExpiry = paho.Uint32(uint32(props.expiry.Seconds()))
+		}
+		for _, prop := range props.user {
+			pub.Properties.User.Add(prop.key, prop.value)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mqtt5Timeout)
+	defer cancel()
+
+	// Reason codes are only returned for QoS 1 and 2, a QoS 0 publish cannot be rejected
+	resp, err := c.cm.Publish(ctx, pub)
+	if resp != nil && resp.ReasonCode >= mqttReasonUnspecified {
+		reasonErr := &MQTTReasonError{Op: "publish to " + topic, ReasonCode: resp.ReasonCode}
+		if resp.Properties != nil {
+			reasonErr.Reason = resp.Properties.ReasonString
+		}
+		return reasonErr
+	}
+	return err
+}
+
+func (c *mqtt5Conn) isOpen() bool {
+	return c.open.Load()
+}
+
+func (c *mqtt5Conn) disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = c.cm.Disconnect(ctx)
+}
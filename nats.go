@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+// NATSPublisher is a sink publishing WAN metrics as JSON or CBOR to a subject per site,
+// optionally through JetStream so every message is persisted before it counts as sent
+type NATSPublisher struct {
+	conn          *nats.Conn
+	js            jetstream.JetStream
+	subject       string
+	payloadFormat string
+	logger        *logrus.Logger
+}
+
+// NewNATSPublisher connects to --nats-url
+func NewNATSPublisher(cli *CLI, logger *logrus.Logger) (*NATSPublisher, error) {
+	if cli.NatsURL == "" {
+		return nil, fmt.Errorf("nats url is required")
+	}
+	if cli.NatsSubject == "" {
+		return nil, fmt.Errorf("nats subject is required")
+	}
+
+	opts := []nats.Option{
+		nats.Name(cli.MqttClientID),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.WithError(err).Error("Lost connection to NATS")
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logger.WithField("url", conn.ConnectedUrl()).Info("Reconnected to NATS")
+		}),
+	}
+	if cli.NatsCreds != "" {
+		opts = append(opts, nats.UserCredentials(cli.NatsCreds))
+	}
+	if cli.NatsToken != "" {
+		opts = append(opts, nats.Token(cli.NatsToken))
+	}
+	if cli.NatsUsername != "" {
+		opts = append(opts, nats.UserInfo(cli.NatsUsername, cli.NatsPassword))
+	}
+	if cli.NatsTLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(cli.NatsTLSCAFile))
+	}
+
+	conn, err := nats.Connect(cli.NatsURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	logger.WithField("url", conn.ConnectedUrl()).Info("Connected to NATS")
+
+	p := &NATSPublisher{
+		conn:          conn,
+		subject:       cli.NatsSubject,
+		payloadFormat: cli.PayloadFormat,
+		logger:        logger,
+	}
+	if cli.NatsJetStream {
+		p.js, err = jetstream.New(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Name implements Sink
+func (p *NATSPublisher) Name() string {
+	return "nats"
+}
+
+// Publish implements Sink, publishing to <subject>.<siteId>.wan
+func (p *NATSPublisher) Publish(ctx context.Context, metric Metric) error {
+	payload, err := marshalPayload(p.payloadFormat, newWANMetric(metric))
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric: %w", err)
+	}
+
+	subject := fmt.Sprintf("%s.%s.wan", p.subject, metric.SiteId)
+
+	p.logger.WithField("subject", subject).Debug("Publishing metric to NATS")
+
+	if p.js != nil {
+		// Deduplicates republished periods within the stream's duplicate window
+		_, err := p.js.Publish(ctx, subject, payload, jetstream.WithMsgID(metric.SiteId+"/"+metric.Timestamp))
+		if err != nil {
+			return fmt.Errorf("failed to publish to JetStream: %w", err)
+		}
+		return nil
+	}
+
+	if err := p.conn.Publish(subject, payload); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink, flushing buffered messages before disconnecting
+func (p *NATSPublisher) Close() error {
+	defer p.conn.Close()
+	if err := p.conn.FlushTimeout(5 * time.Second); err != nil {
+		return fmt.Errorf("failed to flush NATS messages: %w", err)
+	}
+	return nil
+}
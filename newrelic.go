@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newRelicEndpoints are the Metric API endpoints of the New Relic data center regions
+var newRelicEndpoints = map[string]string{
+	"us": "https://metric-api.newrelic.com/metric/v1",
+	"eu": "https://metric-api.eu.newrelic.com/metric/v1",
+}
+
+// newRelicPayload is a single block of a Metric API request
+type newRelicPayload struct {
+	Common  newRelicCommon   `json:"common"`
+	Metrics []newRelicMetric `json:"metrics"`
+}
+
+// newRelicCommon holds the attributes shared by every metric of a block
+type newRelicCommon struct {
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// newRelicMetric is a single gauge datapoint
+type newRelicMetric struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Value      int               `json:"value"`
+	Timestamp  int64             `json:"timestamp"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// NewRelicWriter is a sink sending WAN metrics to the New Relic Metric API as gauges,
+// one per WAN value, with the site, host, ISP and resolver labels as attributes
+type NewRelicWriter struct {
+	url        string
+	licenseKey string
+	prefix     string
+	attributes map[string]string
+	httpClient *http.Client
+	logger     *logrus.Logger
+}
+
+// NewNewRelicWriter creates a writer sending to the Metric API of --newrelic-region
+func NewNewRelicWriter(cli *CLI, logger *logrus.Logger) (*NewRelicWriter, error) {
+	if cli.NewrelicLicenseKey == "" {
+		return nil, fmt.Errorf("new relic license key is required")
+	}
+
+	url := cli.NewrelicURL
+	if url == "" {
+		url = newRelicEndpoints[cli.NewrelicRegion]
+	}
+
+	attributes := make(map[string]string, len(cli.NewrelicAttributes))
+	for _, attr := range cli.NewrelicAttributes {
+		key, value, ok := strings.Cut(attr, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid new relic attribute %q, expected 'key=value'", attr)
+		}
+		attributes[key] = value
+	}
+
+	return &NewRelicWriter{
+		url:        url,
+		licenseKey: cli.NewrelicLicenseKey,
+		prefix:     strings.TrimSuffix(cli.NewrelicPrefix, "."),
+		attributes: attributes,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *NewRelicWriter) Name() string {
+	return "newrelic"
+}
+
+// Publish implements Sink
+func (w *NewRelicWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by sending the gauges of all metrics in one
+// request. Gauges are timestamped with the period's metricTime.
+func (w *NewRelicWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var gauges []newRelicMetric
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+
+		attributes := newRelicAttributes(metric)
+		for _, v := range wanValues(metric.WAN) {
+			gauges = append(gauges, newRelicMetric{
+				Name:       w.prefix + ".wan." + v.name,
+				Type:       "gauge",
+				Value:      v.value,
+				Timestamp:  metricTime.UnixMilli(),
+				Attributes: attributes,
+			})
+		}
+	}
+
+	if len(gauges) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal([]newRelicPayload{{
+		Common:  newRelicCommon{Attributes: w.attributes},
+		Metrics: gauges,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Api-Key", w.licenseKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	w.logger.WithField("gauges", len(gauges)).Debug("Sending metrics to New Relic")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send to New Relic: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("new relic request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Close implements Sink
+func (w *NewRelicWriter) Close() error {
+	return nil
+}
+
+// newRelicAttributes returns the per-site attributes of a metric. Resolver labels
+// are added under their own names; the built-in attributes take precedence.
+func newRelicAttributes(metric Metric) map[string]string {
+	attributes := make(map[string]string, len(metric.Labels)+5)
+	for k, v := range metric.Labels {
+		attributes[k] = v
+	}
+	attributes["siteId"] = metric.SiteId
+	attributes["hostId"] = metric.HostId
+	attributes["metricType"] = metric.MetricType
+	if metric.WAN.ISPName != "" {
+		attributes["ispName"] = metric.WAN.ISPName
+	}
+	if metric.WAN.ISPAsn != "" {
+		attributes["ispAsn"] = metric.WAN.ISPAsn
+	}
+	return attributes
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Exit codes of a --once run
+const (
+	exitPollFailed     = 1
+	exitPublishPartial = 2
+)
+
+// exitError carries the process exit code for an error returned to kong
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// ExitCode implements kong.ExitCoder
+func (e *exitError) ExitCode() int {
+	return e.code
+}
+
+// RunOnce performs a single fetch-and-publish cycle of every metric type and closes
+// the sinks. It exits with exitPollFailed if any cycle failed and exitPublishPartial
+// if they completed but some metrics could not be published.
+func (a *App) RunOnce(ctx context.Context) error {
+	var errs []error
+	publishErrors := 0
+	for _, metricType := range a.cli.MetricTypes {
+		a.logger.WithField("metric_type", metricType).Info("Running a single poll")
+
+		summary, err := a.fetchAndPublishMetrics(ctx, metricType)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		publishErrors += summary.Errors
+	}
+	a.close()
+
+	if len(errs) > 0 {
+		return &exitError{code: exitPollFailed, err: errors.Join(errs...)}
+	}
+	if publishErrors > 0 {
+		return &exitError{
+			code: exitPublishPartial,
+			err:  fmt.Errorf("poll completed with %d publish errors", publishErrors),
+		}
+	}
+	return nil
+}
+
+// OnceCmd polls and publishes a single time, like run --once
+type OnceCmd struct {
+	CLI `kong:"embed"`
+}
+
+// Run performs a single poll and exits with its outcome
+func (c *OnceCmd) Run() error {
+	c.Once = true
+	return c.CLI.Run()
+}
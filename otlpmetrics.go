@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// otlpUnits maps the WAN values to their UCUM units
+var otlpUnits = map[string]string{
+	"avg_latency_ms": "ms",
+	"max_latency_ms": "ms",
+	"download_kbps":  "kbit/s",
+	"upload_kbps":    "kbit/s",
+	"packet_loss":    "%",
+}
+
+// OTLPMetricsExporter is a sink exporting WAN metrics as OpenTelemetry gauges over
+// OTLP/gRPC or OTLP/HTTP. Metrics are exported directly rather than recorded through
+// a meter, so every datapoint keeps the period's metricTime.
+type OTLPMetricsExporter struct {
+	exporter sdkmetric.Exporter
+	resource *resource.Resource
+	scope    instrumentation.Scope
+	logger   *logrus.Logger
+}
+
+// NewOTLPMetricsExporter creates an exporter sending to --otlp-metrics-endpoint.
+// Headers, certificates and compression can be set with the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+func NewOTLPMetricsExporter(ctx context.Context, cli *CLI, logger *logrus.Logger) (*OTLPMetricsExporter, error) {
+	if cli.OtlpMetricsEndpoint == "" {
+		return nil, fmt.Errorf("otlp metrics endpoint is required")
+	}
+
+	var exporter sdkmetric.Exporter
+	var err error
+	switch cli.OtlpMetricsProtocol {
+	case "grpc":
+		exporter, err = otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpointURL(cli.OtlpMetricsEndpoint))
+	default:
+		exporter, err = otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(cli.OtlpMetricsEndpoint))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+	}
+
+	res, err := newOtelResource(ctx, cli.OtelServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OTLPMetricsExporter{
+		exporter: exporter,
+		resource: res,
+		scope: instrumentation.Scope{
+			Name:    "github.com/aaronwald/ubipoller",
+			Version: version,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Name implements Sink
+func (e *OTLPMetricsExporter) Name() string {
+	return "otlp"
+}
+
+// Publish implements Sink
+func (e *OTLPMetricsExporter) Publish(ctx context.Context, metric Metric) error {
+	return e.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by exporting one gauge per WAN value, holding a
+// datapoint for every site
+func (e *OTLPMetricsExporter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	gauges := make(map[string][]metricdata.DataPoint[int64])
+	var names []string
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			e.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+
+		attrs := otlpAttributes(metric)
+		for _, v := range wanValues(metric.WAN) {
+			if _, ok := gauges[v.name]; !ok {
+				names = append(names, v.name)
+			}
+			gauges[v.name] = append(gauges[v.name], metricdata.DataPoint[int64]{
+				Attributes: attrs,
+				Time:       metricTime,
+				Value:      int64(v.value),
+			})
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	scope := metricdata.ScopeMetrics{Scope: e.scope}
+	for _, name := range names {
+		scope.Metrics = append(scope.Metrics, metricdata.Metrics{
+			Name: "ubipoller.wan." + name,
+			Unit: otlpUnits[name],
+			Data: metricdata.Gauge[int64]{DataPoints: gauges[name]},
+		})
+	}
+
+	e.logger.WithField("sites", len(gauges[names[0]])).Debug("Exporting metrics over OTLP")
+
+	if err := e.exporter.Export(ctx, &metricdata.ResourceMetrics{
+		Resource:     e.resource,
+		ScopeMetrics: []metricdata.ScopeMetrics{scope},
+	}); err != nil {
+		return fmt.Errorf("failed to export metrics over OTLP: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (e *OTLPMetricsExporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return e.exporter.Shutdown(ctx)
+}
+
+// otlpAttributes returns the datapoint attributes of a metric: every resolver label
+// plus the site, host, metric type and ISP
+func otlpAttributes(metric Metric) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(metric.Labels)+5)
+	for k, v := range metric.Labels {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+
+	// NewSet keeps the last value of duplicate keys, so labels cannot replace these
+	kvs = append(kvs,
+		attribute.String("site_id", metric.SiteId),
+		attribute.String("host_id", metric.HostId),
+		attribute.String("metric_type", metric.MetricType),
+	)
+	if metric.WAN.ISPName != "" {
+		kvs = append(kvs, attribute.String("isp_name", metric.WAN.ISPName))
+	}
+	if metric.WAN.ISPAsn != "" {
+		kvs = append(kvs, attribute.String("isp_asn", metric.WAN.ISPAsn))
+	}
+	return attribute.NewSet(kvs...)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Outage states published on the outage topics
+const (
+	outageStarted = "started"
+	outageEnded   = "ended"
+)
+
+// OutageEvent is published when a site starts reporting downtime and again when
+// its periods are free of downtime
+type OutageEvent struct {
+	SiteId          string            `json:"siteId"`
+	HostId          string            `json:"hostId"`
+	State           string            `json:"state"`
+	StartedAt       string            `json:"startedAt"`
+	EndedAt         string            `json:"endedAt,omitempty"`
+	DurationSeconds *int              `json:"durationSeconds,omitempty"`
+	Periods         int               `json:"periods"`
+	Downtime        int               `json:"downtime"`
+	ISPName         string            `json:"ispName"`
+	ISPAsn          string            `json:"ispAsn"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	PublishedAt     time.Time         `json:"publishedAt"`
+}
+
+// outageState is the outage of a site in progress, if any, as of its latest period
+type outageState struct {
+	metricTime time.Time
+	startedAt  time.Time
+	periods    int
+	downtime   int
+}
+
+// OutageDetector detects transitions of every site between periods without and
+// with downtime
+type OutageDetector struct {
+	states map[string]*outageState
+	logger *logrus.Logger
+}
+
+// NewOutageDetector creates a detector without any known sites
+func NewOutageDetector(logger *logrus.Logger) *OutageDetector {
+	return &OutageDetector{
+		states: make(map[string]*outageState),
+		logger: logger,
+	}
+}
+
+// Evaluate walks the periods of every site in metrics newer than the last one seen,
+// oldest first, returning an event for every outage that started or ended. Only the
+// latest period of a site is checked on the first poll, so outages that ended before
+// the poller started are not replayed.
+func (d *OutageDetector) Evaluate(metrics *ISPMetrics, sites map[string]Metric) []OutageEvent {
+	var events []OutageEvent
+
+	for _, data := range metrics.Data {
+		state, ok := d.states[data.SiteId]
+		periods := data.Periods
+		if !ok {
+			state = &outageState{}
+			d.states[data.SiteId] = state
+			periods = periods[:min(len(periods), 1)]
+		}
+
+		for i := len(periods) - 1; i >= 0; i-- {
+			period := periods[i]
+			metricTime, err := time.Parse(time.RFC3339, period.MetricTime)
+			if err != nil || !metricTime.After(state.metricTime) {
+				continue
+			}
+			state.metricTime = metricTime
+
+			wan := period.Data.WAN
+			down := wan.Downtime > 0
+			if down {
+				state.periods++
+				state.downtime += wan.Downtime
+			}
+
+			event := OutageEvent{
+				SiteId:      data.SiteId,
+				HostId:      data.HostId,
+				Periods:     state.periods,
+				Downtime:    state.downtime,
+				ISPName:     wan.ISPName,
+				ISPAsn:      wan.ISPAsn,
+				Labels:      sites[data.SiteId].Labels,
+				PublishedAt: time.Now(),
+			}
+			entry := d.logger.WithFields(logrus.Fields{
+				"siteId":   data.SiteId,
+				"downtime": state.downtime,
+			})
+
+			switch {
+			case down && state.startedAt.IsZero():
+				state.startedAt = metricTime
+				event.State = outageStarted
+				event.StartedAt = period.MetricTime
+				entry.Warn("WAN outage started")
+			case !down && !state.startedAt.IsZero():
+				duration := int(metricTime.Sub(state.startedAt).Seconds())
+				event.State = outageEnded
+				event.StartedAt = state.startedAt.Format(time.RFC3339)
+				event.EndedAt = period.MetricTime
+				event.DurationSeconds = &duration
+				entry.WithField("durationSeconds", duration).Info("WAN outage ended")
+				*state = outageState{metricTime: metricTime}
+			default:
+				continue
+			}
+
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// PublishOutage publishes a retained outage event to baseTopic/outages/<siteId>,
+// so subscribers see whether every site is currently in an outage
+func (p *MQTTPublisher) PublishOutage(event OutageEvent, baseTopic string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outage: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/outages/%s", baseTopic, event.SiteId)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic": topic,
+		"state": event.State,
+	}).Debug("Publishing outage to MQTT")
+
+	if err := p.send(topic, 1, true, payload); err != nil {
+		return fmt.Errorf("failed to publish outage to MQTT: %w", err)
+	}
+
+	return nil
+}
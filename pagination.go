@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// apiMaxPages bounds the pages followed for a single request, in case the API keeps
+// returning a next page token
+const apiMaxPages = 1000
+
+// Pagination is the paging information of a list response. The API returns a
+// nextToken while more data follows, requested by passing it back as a query parameter.
+type Pagination struct {
+	NextToken string `json:"nextToken,omitempty"`
+}
+
+// nextPage returns the token of the following page, empty on the last page
+func (p Pagination) nextPage() string {
+	return p.NextToken
+}
+
+// pagedResponse is a list response the API may split into pages
+type pagedResponse[T any] interface {
+	*T
+	nextPage() string
+	// appendPage appends the data of a following page
+	appendPage(page *T)
+}
+
+// getAllPages fetches rawURL and every following page, returning the data of all
+// pages in one response. Each page is retried on its own, so a failure part way
+// does not fetch the earlier pages again.
+func getAllPages[T any, P pagedResponse[T]](ctx context.Context, c *UbiquitiClient, rawURL string) (*T, error) {
+	var all T
+	if err := c.getJSON(ctx, rawURL, &all); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for pages, token := 1, P(&all).nextPage(); token != ""; pages++ {
+		if seen[token] || pages >= apiMaxPages {
+			return nil, fmt.Errorf("API pagination of %s did not end after %d pages", rawURL, pages)
+		}
+		seen[token] = true
+
+		pageURL, err := withPageToken(rawURL, token)
+		if err != nil {
+			return nil, err
+		}
+		var page T
+		if err := c.getJSON(ctx, pageURL, &page); err != nil {
+			return nil, fmt.Errorf("failed to fetch page %d: %w", pages+1, err)
+		}
+		P(&all).appendPage(&page)
+		token = P(&page).nextPage()
+
+		c.logger.WithFields(logrus.Fields{
+			"url":  rawURL,
+			"page": pages + 1,
+		}).Debug("Fetched next API page")
+	}
+	return &all, nil
+}
+
+// withPageToken returns rawURL requesting the page named by token
+func withPageToken(rawURL, token string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid API URL %q: %w", rawURL, err)
+	}
+	query := u.Query()
+	query.Set("nextToken", token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// appendPage implements pagedResponse
+func (m *ISPMetrics) appendPage(page *ISPMetrics) {
+	m.Data = append(m.Data, page.Data...)
+	m.NextToken = ""
+}
+
+// appendPage implements pagedResponse
+func (s *SitesResponse) appendPage(page *SitesResponse) {
+	s.Data = append(s.Data, page.Data...)
+	s.NextToken = ""
+}
+
+// appendPage implements pagedResponse
+func (h *HostsResponse) appendPage(page *HostsResponse) {
+	h.Data = append(h.Data, page.Data...)
+	h.NextToken = ""
+}
+
+// appendPage implements pagedResponse
+func (d *DevicesResponse) appendPage(page *DevicesResponse) {
+	d.Data = append(d.Data, page.Data...)
+	d.NextToken = ""
+}
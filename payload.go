@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"text/template"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborEncoder encodes payloads with deterministic map key order, so identical
+// metrics always produce identical bytes
+var cborEncoder = func() cbor.EncMode {
+	mode, err := cbor.EncOptions{Sort: cbor.SortCoreDeterministic, Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// cborDecoder decodes CBOR maps with string keys, so decoded payloads can be
+// compared and re-encoded as JSON
+var cborDecoder = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// marshalPayload encodes v in the --payload-format format. Field names are the
+// same as in the JSON payloads.
+func marshalPayload(format string, v interface{}) ([]byte, error) {
+	switch format {
+	case "cbor":
+		return cborEncoder.Marshal(v)
+	case "protobuf":
+		return marshalProtobuf(v)
+	case "influx":
+		return encodeInfluxPayload(v)
+	case "avro":
+		return nil, fmt.Errorf("avro payloads are only supported by the kafka sink")
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// payloadMetrics are the per-site messages whose body can be templated
+var payloadMetrics = []string{"latency", "wan", "plan", "counters"}
+
+// PayloadTemplatesFile represents the payload templates file, mapping a metric
+// (latency, wan, plan, counters) to the Go template rendering its message body
+type PayloadTemplatesFile struct {
+	Templates map[string]string `json:"templates"`
+}
+
+// LoadPayloadTemplates reads and compiles the payload templates file at path
+func LoadPayloadTemplates(path string) (map[string]*template.Template, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload templates file: %w", err)
+	}
+
+	var file PayloadTemplatesFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse payload templates file: %w", err)
+	}
+
+	templates := make(map[string]*template.Template)
+	for metric, text := range file.Templates {
+		if !isPayloadMetric(metric) {
+			return nil, fmt.Errorf("unknown payload template %q, expected one of %v", metric, payloadMetrics)
+		}
+		tmpl, err := template.New(metric + "-payload").Funcs(announceFuncs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse payload template %q: %w", metric, err)
+		}
+		templates[metric] = tmpl
+	}
+	return templates, nil
+}
+
+// isPayloadMetric reports whether metric accepts a payload template
+func isPayloadMetric(metric string) bool {
+	for _, m := range payloadMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// encodePayload renders v with the payload template configured for metric,
+// falling back to its --payload-format encoding
+func (p *MQTTPublisher) encodePayload(metric string, v interface{}) ([]byte, error) {
+	tmpl, ok := p.payloadTemplates[metric]
+	if !ok {
+		return marshalPayload(p.payloadFormat, v)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, v); err != nil {
+		return nil, fmt.Errorf("failed to render payload template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PayloadLogger logs full published payloads, sampled to keep log volume bounded
+type PayloadLogger struct {
+	sampleEvery int
+	changesOnly bool
+	logger      *logrus.Logger
+
+	mu    sync.Mutex
+	count map[string]int
+	last  map[string]string
+}
+
+// NewPayloadLogger creates a payload logger that logs one in every sampleEvery
+// payloads per topic, or only payloads that differ from the last one on the topic
+// when changesOnly is set
+func NewPayloadLogger(sampleEvery int, changesOnly bool, logger *logrus.Logger) *PayloadLogger {
+	if sampleEvery < 1 {
+		sampleEvery = 1
+	}
+	return &PayloadLogger{
+		sampleEvery: sampleEvery,
+		changesOnly: changesOnly,
+		logger:      logger,
+		count:       make(map[string]int),
+		last:        make(map[string]string),
+	}
+}
+
+// Log records payload for topic if it passes the sampling rules
+func (l *PayloadLogger) Log(topic string, payload []byte) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.changesOnly {
+		key := comparablePayload(payload)
+		if previous, ok := l.last[topic]; ok && previous == key {
+			return
+		}
+		l.last[topic] = key
+	}
+
+	l.count[topic]++
+	if (l.count[topic]-1)%l.sampleEvery != 0 {
+		return
+	}
+
+	fields := logrus.Fields{
+		"topic":   topic,
+		"payload": string(payload),
+	}
+	if !utf8.Valid(payload) {
+		// Binary payloads (cbor, protobuf) are logged base64 encoded
+		fields["payload"] = base64.StdEncoding.EncodeToString(payload)
+		fields["encoding"] = "base64"
+	}
+	l.logger.WithFields(fields).Info("Published payload")
+}
+
+// comparablePayload strips fields that change on every publish so that only
+// changes in the underlying values are detected
+func comparablePayload(payload []byte) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		if err := cborDecoder.Unmarshal(payload, &fields); err != nil {
+			return string(payload)
+		}
+	}
+	delete(fields, "publishedAt")
+
+	normalized, err := json.Marshal(fields)
+	if err != nil {
+		return string(payload)
+	}
+	return string(normalized)
+}
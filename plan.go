@@ -0,0 +1,117 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PlanMetric compares measured throughput against a site's contracted plan
+type PlanMetric struct {
+	SiteId                 string    `json:"siteId"`
+	HostId                 string    `json:"hostId"`
+	Timestamp              string    `json:"timestamp"`
+	DownloadKbps           int       `json:"downloadKbps"`
+	UploadKbps             int       `json:"uploadKbps"`
+	ContractedDownloadKbps int       `json:"contractedDownloadKbps"`
+	ContractedUploadKbps   int       `json:"contractedUploadKbps"`
+	DownloadAttainment     float64   `json:"downloadAttainment"`
+	UploadAttainment       float64   `json:"uploadAttainment"`
+	UnderDelivering        bool      `json:"underDelivering"`
+	ConsecutiveUnder       int       `json:"consecutiveUnder"`
+	ChronicUnderDelivery   bool      `json:"chronicUnderDelivery"`
+	PublishedAt            time.Time `json:"publishedAt"`
+}
+
+// PlanTracker computes plan attainment per site and tracks chronic under-delivery
+type PlanTracker struct {
+	metadata    *SiteMetadataFile
+	threshold   float64
+	chronicPoll int
+	consecutive map[string]int
+	logger      *logrus.Logger
+}
+
+// NewPlanTracker creates a plan tracker. threshold is the attainment percentage
+// below which a site is considered under-delivering, and chronicPolls is the
+// number of consecutive under-delivering polls before it is flagged as chronic.
+func NewPlanTracker(metadata *SiteMetadataFile, threshold float64, chronicPolls int, logger *logrus.Logger) *PlanTracker {
+	return &PlanTracker{
+		metadata:    metadata,
+		threshold:   threshold,
+		chronicPoll: chronicPolls,
+		consecutive: make(map[string]int),
+		logger:      logger,
+	}
+}
+
+// Evaluate computes plan metrics from the latest period of every site with a declared plan
+func (t *PlanTracker) Evaluate(metrics *ISPMetrics) []PlanMetric {
+	var planMetrics []PlanMetric
+
+	for _, data := range metrics.Data {
+		if len(data.Periods) == 0 {
+			continue
+		}
+		site, ok := t.metadata.Site(data.SiteId)
+		if !ok || site.Plan == nil {
+			continue
+		}
+
+		latestPeriod := data.Periods[0]
+		wan := latestPeriod.Data.WAN
+
+		planMetric := PlanMetric{
+			SiteId:                 data.SiteId,
+			HostId:                 data.HostId,
+			Timestamp:              latestPeriod.MetricTime,
+			DownloadKbps:           wan.DownloadKbps,
+			UploadKbps:             wan.UploadKbps,
+			ContractedDownloadKbps: site.Plan.DownloadKbps,
+			ContractedUploadKbps:   site.Plan.UploadKbps,
+			DownloadAttainment:     attainment(wan.DownloadKbps, site.Plan.DownloadKbps),
+			UploadAttainment:       attainment(wan.UploadKbps, site.Plan.UploadKbps),
+			PublishedAt:            time.Now(),
+		}
+
+		// A direction only counts when it has both a contract and a measurement
+		under := false
+		if site.Plan.DownloadKbps > 0 && wan.DownloadKbps > 0 && planMetric.DownloadAttainment < t.threshold {
+			under = true
+		}
+		if site.Plan.UploadKbps > 0 && wan.UploadKbps > 0 && planMetric.UploadAttainment < t.threshold {
+			under = true
+		}
+
+		if under {
+			t.consecutive[data.SiteId]++
+		} else {
+			t.consecutive[data.SiteId] = 0
+		}
+
+		planMetric.UnderDelivering = under
+		planMetric.ConsecutiveUnder = t.consecutive[data.SiteId]
+		planMetric.ChronicUnderDelivery = t.consecutive[data.SiteId] >= t.chronicPoll
+
+		if planMetric.ChronicUnderDelivery {
+			t.logger.WithFields(logrus.Fields{
+				"siteId":             data.SiteId,
+				"downloadAttainment": planMetric.DownloadAttainment,
+				"uploadAttainment":   planMetric.UploadAttainment,
+				"consecutive":        planMetric.ConsecutiveUnder,
+			}).Warn("Site is chronically under-delivering on its ISP plan")
+		}
+
+		planMetrics = append(planMetrics, planMetric)
+	}
+
+	return planMetrics
+}
+
+// attainment returns measured as a percentage of contracted, or 0 without a contract
+func attainment(measured, contracted int) float64 {
+	if contracted <= 0 {
+		return 0
+	}
+	return float64(measured) / float64(contracted) * 100
+}
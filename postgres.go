@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+// postgresMigrations create and evolve the metrics table, in order. Applied versions
+// are recorded in ubipoller_schema_migrations; only ever append to this list.
+var postgresMigrations = []func(table string) string{
+	func(table string) string {
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	time          TIMESTAMPTZ NOT NULL,
+	site_id       TEXT        NOT NULL,
+	host_id       TEXT        NOT NULL,
+	metric_type   TEXT        NOT NULL,
+	isp_name      TEXT,
+	isp_asn       TEXT,
+	avg_latency   INTEGER     NOT NULL,
+	max_latency   INTEGER     NOT NULL,
+	download_kbps INTEGER     NOT NULL,
+	upload_kbps   INTEGER     NOT NULL,
+	packet_loss   INTEGER     NOT NULL,
+	uptime        INTEGER     NOT NULL,
+	downtime      INTEGER     NOT NULL,
+	labels        JSONB,
+	PRIMARY KEY (site_id, metric_type, time)
+)`, table)
+	},
+}
+
+// PostgresWriter is a sink inserting one row per site and period into a PostgreSQL
+// table, turned into a hypertable when the TimescaleDB extension is installed
+type PostgresWriter struct {
+	pool   *pgxpool.Pool
+	name   string
+	table  string
+	logger *logrus.Logger
+}
+
+// NewPostgresWriter connects to --postgres-url and migrates the metrics table
+func NewPostgresWriter(ctx context.Context, cli *CLI, logger *logrus.Logger) (*PostgresWriter, error) {
+	if cli.PostgresURL == "" {
+		return nil, fmt.Errorf("postgres url is required")
+	}
+	if cli.PostgresTable == "" {
+		return nil, fmt.Errorf("postgres table is required")
+	}
+
+	config, err := pgxpool.ParseConfig(cli.PostgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse postgres url: %w", err)
+	}
+	config.ConnConfig.RuntimeParams["application_name"] = cli.MqttClientID
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+
+	w := &PostgresWriter{
+		pool:   pool,
+		name:   cli.PostgresTable,
+		table:  pgx.Identifier{cli.PostgresTable}.Sanitize(),
+		logger: logger,
+	}
+	if err := w.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// migrate applies pending migrations in a transaction, then converts the table to a
+// hypertable if TimescaleDB is available
+func (w *PostgresWriter) migrate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := w.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS ubipoller_schema_migrations (
+	table_name TEXT        NOT NULL,
+	version    INTEGER     NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (table_name, version)
+)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	// Serialize migrations of concurrently starting pollers
+	if _, err := tx.Exec(ctx, `LOCK TABLE ubipoller_schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock migrations table: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM ubipoller_schema_migrations WHERE table_name = $1`, w.name).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.Exec(ctx, postgresMigrations[i](w.table)); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO ubipoller_schema_migrations (table_name, version) VALUES ($1, $2)`, w.name, i+1); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+		w.logger.WithFields(logrus.Fields{
+			"table":   w.name,
+			"version": i + 1,
+		}).Info("Applied PostgreSQL schema migration")
+	}
+
+	var timescale bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&timescale); err != nil {
+		return fmt.Errorf("failed to detect TimescaleDB: %w", err)
+	}
+	if timescale {
+		if _, err := tx.Exec(ctx, `SELECT create_hypertable($1::text::regclass, 'time', if_not_exists => TRUE, migrate_data => TRUE)`, w.table); err != nil {
+			return fmt.Errorf("failed to create hypertable: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migrations: %w", err)
+	}
+	return nil
+}
+
+// Name implements Sink
+func (w *PostgresWriter) Name() string {
+	return "postgres"
+}
+
+// Publish implements Sink by inserting a single row
+func (w *PostgresWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by inserting all metrics in one batch. Rows are
+// keyed by site, metric type and metricTime, so re-inserting a period updates it.
+func (w *PostgresWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	query := fmt.Sprintf(`INSERT INTO %s (time, site_id, host_id, metric_type, isp_name, isp_asn,
+	avg_latency, max_latency, download_kbps, upload_kbps, packet_loss, uptime, downtime, labels)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+ON CONFLICT (site_id, metric_type, time) DO UPDATE SET
+	host_id = EXCLUDED.host_id, isp_name = EXCLUDED.isp_name, isp_asn = EXCLUDED.isp_asn,
+	avg_latency = EXCLUDED.avg_latency, max_latency = EXCLUDED.max_latency,
+	download_kbps = EXCLUDED.download_kbps, upload_kbps = EXCLUDED.upload_kbps,
+	packet_loss = EXCLUDED.packet_loss, uptime = EXCLUDED.uptime, downtime = EXCLUDED.downtime,
+	labels = EXCLUDED.labels`, w.table)
+
+	batch := &pgx.Batch{}
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+
+		var labels []byte
+		if len(metric.Labels) > 0 {
+			labels, err = json.Marshal(metric.Labels)
+			if err != nil {
+				return fmt.Errorf("failed to marshal labels: %w", err)
+			}
+		}
+
+		wan := metric.WAN
+		batch.Queue(query, metricTime, metric.SiteId, metric.HostId, metric.MetricType, nullIfEmpty(wan.ISPName), nullIfEmpty(wan.ISPAsn),
+			wan.AvgLatency, wan.MaxLatency, wan.DownloadKbps, wan.UploadKbps, wan.PacketLoss, wan.Uptime, wan.Downtime, labels)
+	}
+
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	w.logger.WithField("rows", batch.Len()).Debug("Writing rows to PostgreSQL")
+
+	if err := w.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to write to PostgreSQL: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (w *PostgresWriter) Close() error {
+	w.pool.Close()
+	return nil
+}
+
+// nullIfEmpty maps an empty string to SQL NULL
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// wanGauge describes a single WAN field exported as a Prometheus gauge
+type wanGauge struct {
+	desc  *prometheus.Desc
+	value func(wan WANData) float64
+}
+
+// promSample is the latest metric received for a site and metric type
+type promSample struct {
+	metric     Metric
+	updatedAt  time.Time
+	staleAfter time.Duration
+}
+
+// PrometheusExporter is a sink exposing the latest WAN metrics of every site on /metrics
+type PrometheusExporter struct {
+	gauges     []wanGauge
+	staleAfter time.Duration
+	server     *http.Server
+	logger     *logrus.Logger
+
+	mu      sync.RWMutex
+	samples map[string]promSample
+}
+
+// NewPrometheusExporter creates an exporter and starts serving /metrics on addr.
+// Sites that have not been published for staleAfter, or three periods of their metric
+// type if longer, are no longer exported. The poller's own telemetry is served
+// alongside the WAN gauges.
+func NewPrometheusExporter(addr string, staleAfter time.Duration, telemetry *Telemetry, logger *logrus.Logger) (*PrometheusExporter, error) {
+	labels := []string{"site_id", "host_id", "metric_type"}
+	gauge := func(name, help string, value func(wan WANData) float64) wanGauge {
+		return wanGauge{
+			desc:  prometheus.NewDesc(prometheus.BuildFQName("ubipoller", "wan", name), help, labels, nil),
+			value: value,
+		}
+	}
+
+	e := &PrometheusExporter{
+		gauges: []wanGauge{
+			gauge("avg_latency_ms", "Average WAN latency in milliseconds", func(w WANData) float64 { return float64(w.AvgLatency) }),
+			gauge("max_latency_ms", "Maximum WAN latency in milliseconds", func(w WANData) float64 { return float64(w.MaxLatency) }),
+			gauge("download_kbps", "WAN download throughput in kbps", func(w WANData) float64 { return float64(w.DownloadKbps) }),
+			gauge("upload_kbps", "WAN upload throughput in kbps", func(w WANData) float64 { return float64(w.UploadKbps) }),
+			gauge("packet_loss", "WAN packet loss percentage", func(w WANData) float64 { return float64(w.PacketLoss) }),
+			gauge("uptime", "WAN uptime reported for the period", func(w WANData) float64 { return float64(w.Uptime) }),
+			gauge("downtime", "WAN downtime reported for the period", func(w WANData) float64 { return float64(w.Downtime) }),
+		},
+		staleAfter: staleAfter,
+		logger:     logger,
+		samples:    make(map[string]promSample),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e, telemetry)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// Listen up front so address errors fail startup instead of being logged later
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	e.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := e.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Error("Prometheus exporter stopped")
+		}
+	}()
+
+	logger.WithField("addr", addr).Info("Serving Prometheus metrics")
+	return e, nil
+}
+
+// Name implements Sink
+func (e *PrometheusExporter) Name() string {
+	return "prometheus"
+}
+
+// Publish implements Sink by replacing the exported sample of the metric's site and
+// metric type
+func (e *PrometheusExporter) Publish(ctx context.Context, metric Metric) error {
+	staleAfter := e.staleAfter
+	if period, err := parseDays(metric.MetricType); err == nil && staleAfter > 0 {
+		// Deduplicated periods of longer metric types are only published once per period
+		staleAfter = max(staleAfter, 3*period)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.samples[periodKey(metric)] = promSample{metric: metric, updatedAt: time.Now(), staleAfter: staleAfter}
+	return nil
+}
+
+// Close implements Sink by shutting down the HTTP server
+func (e *PrometheusExporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return e.server.Shutdown(ctx)
+}
+
+// Describe implements prometheus.Collector
+func (e *PrometheusExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, g := range e.gauges {
+		ch <- g.desc
+	}
+}
+
+// Collect implements prometheus.Collector using the latest sample of each site
+func (e *PrometheusExporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, sample := range e.samples {
+		if sample.staleAfter > 0 && time.Since(sample.updatedAt) > sample.staleAfter {
+			continue
+		}
+		m := sample.metric
+		for _, g := range e.gauges {
+			ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, g.value(m.WAN), m.SiteId, m.HostId, m.MetricType)
+		}
+	}
+}
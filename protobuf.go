@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// payloadSchemaPackage is the protobuf package of the payload messages
+const payloadSchemaPackage = "ubipoller.v1"
+
+// Field types of the payload messages
+const (
+	protoString = descriptorpb.FieldDescriptorProto_TYPE_STRING
+	protoInt64  = descriptorpb.FieldDescriptorProto_TYPE_INT64
+	protoDouble = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	protoBool   = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	protoLabels = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+)
+
+// protoFieldDef is a field of a payload message, numbered by its position
+type protoFieldDef struct {
+	name     string
+	typ      descriptorpb.FieldDescriptorProto_Type
+	optional bool
+}
+
+// payloadMessages mirror proto/ubipoller.proto, keep both in sync. Messages are
+// named like the Go payload structs they encode.
+var payloadMessages = []struct {
+	name   string
+	fields []protoFieldDef
+}{
+	{"LatencyMetric", []protoFieldDef{
+		{"site_id", protoString, false},
+		{"host_id", protoString, false},
+		{"timestamp", protoString, false},
+		{"avg_latency", protoInt64, false},
+		{"max_latency", protoInt64, false},
+		{"isp_name", protoString, false},
+		{"isp_asn", protoString, false},
+		{"labels", protoLabels, false},
+		{"published_at", protoString, false},
+		{"metric_type", protoString, false},
+	}},
+	{"WANMetric", []protoFieldDef{
+		{"site_id", protoString, false},
+		{"host_id", protoString, false},
+		{"timestamp", protoString, false},
+		{"avg_latency", protoInt64, false},
+		{"max_latency", protoInt64, false},
+		{"download_kbps", protoInt64, false},
+		{"upload_kbps", protoInt64, false},
+		{"packet_loss", protoInt64, false},
+		{"uptime", protoInt64, false},
+		{"downtime", protoInt64, false},
+		{"isp_name", protoString, false},
+		{"isp_asn", protoString, false},
+		{"labels", protoLabels, false},
+		{"published_at", protoString, false},
+		{"metric_type", protoString, false},
+	}},
+	{"PlanMetric", []protoFieldDef{
+		{"site_id", protoString, false},
+		{"host_id", protoString, false},
+		{"timestamp", protoString, false},
+		{"download_kbps", protoInt64, false},
+		{"upload_kbps", protoInt64, false},
+		{"contracted_download_kbps", protoInt64, false},
+		{"contracted_upload_kbps", protoInt64, false},
+		{"download_attainment", protoDouble, false},
+		{"upload_attainment", protoDouble, false},
+		{"under_delivering", protoBool, false},
+		{"consecutive_under", protoInt64, false},
+		{"chronic_under_delivery", protoBool, false},
+		{"published_at", protoString, false},
+	}},
+	{"CounterMetric", []protoFieldDef{
+		{"site_id", protoString, false},
+		{"host_id", protoString, false},
+		{"timestamp", protoString, false},
+		{"uptime", protoInt64, false},
+		{"downtime", protoInt64, false},
+		{"uptime_delta", protoInt64, true},
+		{"downtime_delta", protoInt64, true},
+		{"published_at", protoString, false},
+	}},
+}
+
+// payloadSchemaFile is the file descriptor of the payload messages
+var payloadSchemaFile = func() protoreflect.FileDescriptor {
+	file, err := protodesc.NewFile(payloadSchemaProto(), nil)
+	if err != nil {
+		panic(err)
+	}
+	return file
+}()
+
+// payloadSchemaProto builds the descriptor of proto/ubipoller.proto
+func payloadSchemaProto() *descriptorpb.FileDescriptorProto {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("ubipoller.proto"),
+		Package: proto.String(payloadSchemaPackage),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("github.com/aaronwald/ubipoller/proto;ubipollerv1"),
+		},
+	}
+
+	for _, m := range payloadMessages {
+		message := &descriptorpb.DescriptorProto{Name: proto.String(m.name)}
+		for i, f := range m.fields {
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:   proto.String(f.name),
+				Number: proto.Int32(int32(i + 1)),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   f.typ.Enum(),
+			}
+			if f.typ == protoLabels {
+				// map<string, string> is a repeated message of a synthesized entry type
+				message.NestedType = append(message.NestedType, &descriptorpb.DescriptorProto{
+					Name: proto.String("LabelsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						{Name: proto.String("key"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: protoString.Enum()},
+						{Name: proto.String("value"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: protoString.Enum()},
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				})
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+				field.TypeName = proto.String("." + payloadSchemaPackage + "." + m.name + ".LabelsEntry")
+			}
+			if f.optional {
+				// proto3 optional fields live in a synthetic oneof of their own
+				field.Proto3Optional = proto.Bool(true)
+				field.OneofIndex = proto.Int32(int32(len(message.OneofDecl)))
+				message.OneofDecl = append(message.OneofDecl, &descriptorpb.OneofDescriptorProto{
+					Name: proto.String("_" + f.name),
+				})
+			}
+			message.Field = append(message.Field, field)
+		}
+		file.MessageType = append(file.MessageType, message)
+	}
+	return file
+}
+
+// payloadSchemaSet returns the serialized FileDescriptorSet of the payload messages,
+// as published with --publish-payload-schema
+func payloadSchemaSet() ([]byte, error) {
+	return proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{payloadSchemaProto()},
+	})
+}
+
+// marshalProtobuf encodes a payload struct as the message of the same name. The
+// struct goes through its JSON encoding, whose field names are the messages' JSON
+// names, so the two formats cannot drift apart.
+func marshalProtobuf(v interface{}) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	desc := payloadSchemaFile.Messages().ByName(protoreflect.Name(t.Name()))
+	if desc == nil {
+		return nil, fmt.Errorf("no protobuf message for payload type %s", t.Name())
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(desc)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(raw, message); err != nil {
+		return nil, fmt.Errorf("failed to convert payload to protobuf: %w", err)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// publishLimiter spaces out MQTT messages so a backfill, a buffer replay or a large
+// account does not flood a low-powered broker. It allows at most rate messages per
+// second, in bursts of up to one second's worth, and keeps consecutive messages at
+// least delay apart.
+type publishLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	delay    time.Duration
+	// tat is the theoretical arrival time of the next message at the limited rate
+	tat  time.Time
+	last time.Time
+}
+
+// newPublishLimiter creates a limiter for --mqtt-max-rate and --mqtt-publish-delay,
+// or nil when neither is set
+func newPublishLimiter(rate float64, delay time.Duration) (*publishLimiter, error) {
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return nil, fmt.Errorf("--mqtt-max-rate must be a positive number of messages per second, or 0 for no limit")
+	}
+	if delay < 0 {
+		return nil, fmt.Errorf("--mqtt-publish-delay must not be negative")
+	}
+	if rate == 0 && delay == 0 {
+		return nil, nil
+	}
+
+	l := &publishLimiter{delay: delay, burst: 1}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+		l.burst = max(1, int(rate))
+	}
+	return l, nil
+}
+
+// wait blocks until the next message may be sent. Concurrent callers are served one
+// at a time.
+func (l *publishLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	at := now
+	if l.interval > 0 {
+		// A burst may run ahead of the rate by up to burst-1 messages
+		if allowed := l.tat.Add(-time.Duration(l.burst-1) * l.interval); allowed.After(at) {
+			at = allowed
+		}
+	}
+	if l.delay > 0 && !l.last.IsZero() {
+		if allowed := l.last.Add(l.delay); allowed.After(at) {
+			at = allowed
+		}
+	}
+	time.Sleep(at.Sub(now))
+
+	if l.tat.Before(at) {
+		l.tat = at
+	}
+	l.tat = l.tat.Add(l.interval)
+	l.last = at
+}
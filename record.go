@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ResponseRecorder writes the raw body of every successful API response to
+// --record-dir before it is decoded, in a directory per endpoint and a file per
+// response named after the time it was received
+type ResponseRecorder struct {
+	dir    string
+	logger *logrus.Logger
+}
+
+// newResponseRecorder creates a recorder writing to dir, or nil when dir is empty
+func newResponseRecorder(dir string, logger *logrus.Logger) *ResponseRecorder {
+	if dir == "" {
+		return nil
+	}
+	return &ResponseRecorder{dir: dir, logger: logger}
+}
+
+// Record writes body, the response to a request for rawURL. A failure is logged
+// rather than failing the poll.
+func (r *ResponseRecorder) Record(rawURL string, body []byte) {
+	if r == nil {
+		return
+	}
+
+	dir := filepath.Join(r.dir, recordEndpoint(rawURL))
+	path := filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000000000Z")+".json")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		r.logger.WithError(err).Warn("Failed to create record directory")
+		return
+	}
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		r.logger.WithError(err).Warn("Failed to record API response")
+		return
+	}
+	r.logger.WithField("path", path).Debug("Recorded API response")
+}
+
+// recordEndpoint names the directory of the responses of rawURL after its path, such
+// as ea-isp-metrics-5m for https://api.ui.com/ea/isp-metrics/5m
+func recordEndpoint(rawURL string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, strings.Trim(path, "/"))
+	if name == "" || strings.Trim(name, ".") == "" {
+		return "root"
+	}
+	return name
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// RedisPublisher is a sink PUBLISHing WAN metrics to a channel per site and storing
+// the most recent one under a latest-value key, so readers don't have to subscribe
+type RedisPublisher struct {
+	client        *redis.Client
+	prefix        string
+	ttl           time.Duration
+	payloadFormat string
+	logger        *logrus.Logger
+}
+
+// NewRedisPublisher creates a publisher for --redis-url
+func NewRedisPublisher(cli *CLI, logger *logrus.Logger) (*RedisPublisher, error) {
+	if cli.RedisURL == "" {
+		return nil, fmt.Errorf("redis url is required")
+	}
+	opts, err := redis.ParseURL(cli.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis url: %w", err)
+	}
+	opts.ClientName = cli.MqttClientID
+
+	return &RedisPublisher{
+		client:        redis.NewClient(opts),
+		prefix:        cli.RedisPrefix,
+		ttl:           cli.RedisTTL,
+		payloadFormat: cli.PayloadFormat,
+		logger:        logger,
+	}, nil
+}
+
+// Name implements Sink
+func (p *RedisPublisher) Name() string {
+	return "redis"
+}
+
+// Publish implements Sink
+func (p *RedisPublisher) Publish(ctx context.Context, metric Metric) error {
+	return p.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by sending all commands in one pipeline. Metrics
+// arrive oldest first, so each site's latest key ends up holding its newest period.
+func (p *RedisPublisher) PublishBatch(ctx context.Context, metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	pipe := p.client.Pipeline()
+	for _, metric := range metrics {
+		payload, err := marshalPayload(p.payloadFormat, newWANMetric(metric))
+		if err != nil {
+			return fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		pipe.Publish(ctx, fmt.Sprintf("%s:%s:wan", p.prefix, metric.SiteId), payload)
+		pipe.Set(ctx, fmt.Sprintf("%s:%s:latest", p.prefix, metric.SiteId), payload, p.ttl)
+	}
+
+	p.logger.WithField("metrics", len(metrics)).Debug("Writing metrics to Redis")
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to write to Redis: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (p *RedisPublisher) Close() error {
+	return p.client.Close()
+}
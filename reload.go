@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/alecthomas/kong"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadableOptions are the options a reload applies to the running poller. Changes
+// to any other option keep their startup value until a restart.
+var reloadableOptions = []string{
+	"interval",
+	"sites",
+	"exclude-sites",
+	"mqtt-topic-template",
+	"plan-threshold",
+	"plan-chronic-polls",
+	"alert-rules",
+	"anomaly-z-threshold",
+	"anomaly-min-deviation",
+	"log-level",
+}
+
+// reparseCLI parses the command line, the --config file and the environment again as
+// at startup, returning the options of the run command and the flags they were
+// parsed from. Secrets are not read again, so Vault and AWS are not called.
+func reparseCLI() (*CLI, []*kong.Flag, error) {
+	var commands Commands
+	commands.Run.secretsRead = true
+
+	parser, err := kong.New(&commands, kongOptions()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, err := parser.Parse(os.Args[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	if ctx.Command() != "run" {
+		return nil, nil, fmt.Errorf("only the run command can be reloaded")
+	}
+	return &commands.Run, ctx.Flags(), nil
+}
+
+// changedOptions returns the names of the flags whose values differ between current
+// and next, which was parsed from flags. Secret options and --accounts are skipped
+// as they are only read at startup.
+func changedOptions(current, next *CLI, flags []*kong.Flag) []string {
+	currentValue, nextValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem()
+	fields := make(map[uintptr]int, nextValue.NumField())
+	for i := 0; i < nextValue.NumField(); i++ {
+		if nextValue.Type().Field(i).IsExported() {
+			fields[nextValue.Field(i).Addr().Pointer()] = i
+		}
+	}
+	secrets := current.secretOptions()
+
+	var changed []string
+	for _, flag := range flags {
+		if !flag.Target.CanAddr() {
+			continue
+		}
+		i, ok := fields[flag.Target.Addr().Pointer()]
+		if !ok || secrets[flag.Name] != nil || flag.Name == "accounts" {
+			continue
+		}
+		if !reflect.DeepEqual(currentValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			changed = append(changed, flag.Name)
+		}
+	}
+	return changed
+}
+
+// reload parses the configuration again and applies the reloadable options without
+// reconnecting to the broker, or nothing when any of them is invalid. The alert rules
+// file is read again even when its path did not change. It reports whether the
+// interval changed.
+func (a *App) reload() bool {
+	a.pipeline.Lock()
+	defer a.pipeline.Unlock()
+
+	next, flags, err := reparseCLI()
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to reload configuration, keeping the current one")
+		return false
+	}
+	changed := changedOptions(a.cli, next, flags)
+
+	level, err := logrus.ParseLevel(next.LogLevel)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to reload configuration, keeping the current one")
+		return false
+	}
+	if next.Interval <= 0 {
+		a.logger.Error("Failed to reload configuration, keeping the current one: --interval must be positive")
+		return false
+	}
+	var topicTemplate *template.Template
+	if a.mqttPublisher != nil {
+		if topicTemplate, err = parseTopicTemplate(next.MqttTopicTemplate); err != nil {
+			a.logger.WithError(err).Error("Failed to reload configuration, keeping the current one")
+			return false
+		}
+		if err := checkMetricTypeTopics(a.cli.MetricTypes, next.MqttTopicTemplate); err != nil {
+			a.logger.WithError(err).Error("Failed to reload configuration, keeping the current one")
+			return false
+		}
+	}
+	var rules *AlertRulesFile
+	if a.alerter != nil && next.AlertRules != "" {
+		if rules, err = LoadAlertRules(next.AlertRules); err != nil {
+			a.logger.WithError(err).Error("Failed to reload configuration, keeping the current one")
+			return false
+		}
+	}
+
+	var restart []string
+	for _, name := range changed {
+		if !slices.Contains(reloadableOptions, name) || (name == "alert-rules" && rules == nil) {
+			restart = append(restart, name)
+		}
+	}
+
+	a.logger.SetLevel(level)
+	a.cli.LogLevel = next.LogLevel
+	intervalChanged := a.cli.Interval != next.Interval
+	a.cli.Interval = next.Interval
+
+	a.cli.Sites, a.cli.ExcludeSites = next.Sites, next.ExcludeSites
+	a.siteFilter = NewSiteFilter(next.Sites, next.ExcludeSites)
+
+	// --mqtt-topic is not reloaded, as the last will, the birth message and the
+	// announced discovery configs refer to the status topic below it
+	a.cli.MqttTopicTemplate = next.MqttTopicTemplate
+	if a.mqttPublisher != nil {
+		a.mqttPublisher.topicTemplate = topicTemplate
+	}
+
+	a.cli.PlanThreshold, a.cli.PlanChronicPolls = next.PlanThreshold, next.PlanChronicPolls
+	if a.planTracker != nil {
+		a.planTracker.threshold, a.planTracker.chronicPoll = next.PlanThreshold, next.PlanChronicPolls
+	}
+
+	if rules != nil {
+		a.cli.AlertRules = next.AlertRules
+		a.alerter.setRules(rules)
+	}
+
+	a.cli.AnomalyZThreshold, a.cli.AnomalyMinDeviation = next.AnomalyZThreshold, next.AnomalyMinDeviation
+	if a.anomalies != nil {
+		a.anomalies.threshold, a.anomalies.minDeviation = next.AnomalyZThreshold, next.AnomalyMinDeviation
+	}
+
+	entry := a.logger.WithField("changed", strings.Join(changed, ", "))
+	if len(restart) > 0 {
+		entry.WithField("restart_required", strings.Join(restart, ", ")).Warn("Reloaded configuration, some changes only apply after a restart")
+	} else {
+		entry.Info("Reloaded configuration")
+	}
+	return intervalChanged
+}
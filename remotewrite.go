@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// promLabel is a single label of a remote write series
+type promLabel struct {
+	name  string
+	value string
+}
+
+// promSeries is a remote write series holding a single sample
+type promSeries struct {
+	labels    []promLabel
+	value     float64
+	timestamp int64
+}
+
+// RemoteWriteWriter is a sink pushing WAN metrics to a Prometheus remote_write
+// receiver (Prometheus, Mimir, VictoriaMetrics, Thanos Receive). The series are
+// named and labelled like the gauges of the prometheus sink.
+type RemoteWriteWriter struct {
+	url        string
+	headers    http.Header
+	labels     []promLabel
+	httpClient *http.Client
+	logger     *logrus.Logger
+}
+
+// NewRemoteWriteWriter creates a writer pushing to --remote-write-url
+func NewRemoteWriteWriter(cli *CLI, logger *logrus.Logger) (*RemoteWriteWriter, error) {
+	if cli.RemoteWriteURL == "" {
+		return nil, fmt.Errorf("remote write url is required")
+	}
+	if cli.RemoteWriteBearerToken != "" && cli.RemoteWriteUsername != "" {
+		return nil, fmt.Errorf("remote write bearer token and basic auth are mutually exclusive")
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/x-protobuf")
+	headers.Set("Content-Encoding", "snappy")
+	headers.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	headers.Set("User-Agent", "ubipoller/"+version)
+	for _, header := range cli.RemoteWriteHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid remote write header %q, expected 'Name: value'", header)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	switch {
+	case cli.RemoteWriteBearerToken != "":
+		headers.Set("Authorization", "Bearer "+cli.RemoteWriteBearerToken)
+	case cli.RemoteWriteUsername != "":
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(cli.RemoteWriteUsername, cli.RemoteWritePassword)
+		headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+
+	var labels []promLabel
+	for _, label := range cli.RemoteWriteLabels {
+		name, value, ok := strings.Cut(label, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid remote write label %q, expected 'name=value'", label)
+		}
+		labels = append(labels, promLabel{name: name, value: value})
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cli.RemoteWriteTLSCAFile != "" || cli.RemoteWriteTLSInsecure || cli.RemoteWriteTLSCertFile != "" || cli.RemoteWriteTLSKeyFile != "" {
+		tlsConfig := &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: cli.RemoteWriteTLSInsecure,
+		}
+		if cli.RemoteWriteTLSCAFile != "" {
+			pool, err := loadCertPool(cli.RemoteWriteTLSCAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if cli.RemoteWriteTLSCertFile != "" || cli.RemoteWriteTLSKeyFile != "" {
+			if cli.RemoteWriteTLSCertFile == "" || cli.RemoteWriteTLSKeyFile == "" {
+				return nil, fmt.Errorf("both a client certificate and key file are required for mutual TLS")
+			}
+			cert, err := tls.LoadX509KeyPair(cli.RemoteWriteTLSCertFile, cli.RemoteWriteTLSKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &RemoteWriteWriter{
+		url:     cli.RemoteWriteURL,
+		headers: headers,
+		labels:  labels,
+		httpClient: &http.Client{
+			Timeout:   cli.RemoteWriteTimeout,
+			Transport: transport,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *RemoteWriteWriter) Name() string {
+	return "remote-write"
+}
+
+// Publish implements Sink
+func (w *RemoteWriteWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink by pushing the series of all metrics in one
+// request. Samples are timestamped with the period's metricTime.
+func (w *RemoteWriteWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var series []promSeries
+	for _, metric := range metrics {
+		metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Skipping metric with invalid metricTime")
+			continue
+		}
+
+		for _, v := range wanValues(metric.WAN) {
+			labels := append([]promLabel{
+				{name: "__name__", value: "ubipoller_wan_" + v.name},
+				{name: "site_id", value: metric.SiteId},
+				{name: "host_id", value: metric.HostId},
+				{name: "metric_type", value: metric.MetricType},
+			}, w.labels...)
+			// Receivers require the labels of a series sorted by name
+			sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+			series = append(series, promSeries{
+				labels:    labels,
+				value:     float64(v.value),
+				timestamp: metricTime.UnixMilli(),
+			})
+		}
+	}
+
+	if len(series) == 0 {
+		return nil
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = w.headers.Clone()
+
+	w.logger.WithField("series", len(series)).Debug("Pushing series over remote write")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push to remote write receiver: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote write failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Close implements Sink
+func (w *RemoteWriteWriter) Close() error {
+	return nil
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protobuf message
+func encodeWriteRequest(series []promSeries) []byte {
+	var out []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, ts)
+	}
+	return out
+}
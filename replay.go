@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReplayCmd runs the poll pipeline against recorded API responses instead of the API
+type ReplayCmd struct {
+	CLI `kong:"embed"`
+
+	Paths []string      `kong:"arg,name='path',help='Recorded API responses (*.json) or directories of them, replayed in name order'"`
+	Delay time.Duration `kong:"help='Wait between responses, such as the poll interval for a demo (default: none)'"`
+	Loop  bool          `kong:"help='Start over after the last response until interrupted'"`
+}
+
+// Run publishes every recorded response as one poll cycle and exits
+func (c *ReplayCmd) Run() error {
+	logger := newLogger(c.LogLevel, c.LogFormat)
+
+	flushTraces, err := initTracing(&c.CLI, logger)
+	if err != nil {
+		return err
+	}
+	defer flushTraces()
+
+	if c.Delay < 0 {
+		return fmt.Errorf("--delay must not be negative")
+	}
+	client, err := newReplayClient(c.Paths)
+	if err != nil {
+		return err
+	}
+	c.replay = client
+
+	// Recorded periods are older than the watermarks of the running poller, so the
+	// state file is neither used to filter them nor advanced by them
+	c.StateFile = ""
+	c.exits = true
+
+	app, err := NewApp(&c.CLI, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create application: %w", err)
+	}
+	defer app.close()
+
+	return app.Replay(shutdownContext(logger), client, c.Delay, c.Loop)
+}
+
+// Replay runs a fetch-and-publish cycle for every response of client, waiting delay
+// between them, and starts over when loop is set. It returns an error when any
+// cycle failed or could not publish everything.
+func (a *App) Replay(ctx context.Context, client *replayClient, delay time.Duration, loop bool) error {
+	a.logger.WithFields(logrus.Fields{
+		"responses": len(client.paths),
+		"sinks":     a.cli.Sinks,
+	}).Info("Starting replay")
+
+	cycles, failed := 0, 0
+	for {
+		path := client.paths[client.next]
+		// The responses carry their own metric type, the cycle runs as the first one
+		summary, err := a.fetchAndPublishMetrics(ctx, a.cli.MetricTypes[0])
+		cycles++
+		if err != nil || summary.Errors > 0 {
+			failed++
+			a.logger.WithError(err).WithField("path", path).Error("Failed to replay response")
+		} else {
+			a.logger.WithField("path", path).Debug("Replayed response")
+		}
+
+		if client.next == 0 && !loop {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+
+	a.logger.WithField("cycles", cycles).Info("Replay complete")
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replayed responses failed", failed, cycles)
+	}
+	return nil
+}
+
+// replayClient is a MetricsClient returning recorded API responses in turn, wrapping
+// around after the last one
+type replayClient struct {
+	paths []string
+	next  int
+}
+
+// newReplayClient lists the responses in paths, expanding directories to the *.json
+// files they contain
+func newReplayClient(paths []string) (*replayClient, error) {
+	client := &replayClient{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			client.paths = append(client.paths, path)
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", path, err)
+		}
+		sort.Strings(files)
+		client.paths = append(client.paths, files...)
+	}
+	if len(client.paths) == 0 {
+		return nil, fmt.Errorf("no recorded API responses to replay")
+	}
+	return client, nil
+}
+
+// GetISPMetricsRange implements MetricsClient, decoding the next recorded response.
+// The metric type and time range of the recorded request apply instead of the ones
+// given.
+func (c *replayClient) GetISPMetricsRange(ctx context.Context, metricType string, begin, end time.Time) (*ISPMetrics, error) {
+	path := c.paths[c.next]
+	c.next = (c.next + 1) % len(c.paths)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var metrics ISPMetrics
+	if err := json.Unmarshal(raw, &metrics); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &metrics, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Resolver maps a siteId to a set of descriptive labels
+type Resolver interface {
+	Resolve(ctx context.Context, siteId string) (map[string]string, error)
+}
+
+// ChainResolver queries several resolvers and merges their labels.
+// Resolvers earlier in the chain take precedence for the same label key.
+type ChainResolver struct {
+	resolvers []Resolver
+	logger    *logrus.Logger
+}
+
+// NewChainResolver creates a resolver that merges the labels of all given resolvers
+func NewChainResolver(logger *logrus.Logger, resolvers ...Resolver) *ChainResolver {
+	return &ChainResolver{
+		resolvers: resolvers,
+		logger:    logger,
+	}
+}
+
+// Resolve merges labels from every resolver in the chain. A failing resolver is
+// logged and skipped so the remaining sources can still enrich the metric.
+func (r *ChainResolver) Resolve(ctx context.Context, siteId string) (map[string]string, error) {
+	merged := make(map[string]string)
+	for _, resolver := range r.resolvers {
+		labels, err := resolver.Resolve(ctx, siteId)
+		if err != nil {
+			r.logger.WithError(err).WithField("siteId", siteId).Warn("Resolver failed")
+			continue
+		}
+		for k, v := range labels {
+			if _, exists := merged[k]; !exists {
+				merged[k] = v
+			}
+		}
+	}
+	return merged, nil
+}
+
+// StaticResolver resolves labels from the site metadata file
+type StaticResolver struct {
+	metadata *SiteMetadataFile
+}
+
+// NewStaticResolver creates a resolver backed by the site metadata file
+func NewStaticResolver(metadata *SiteMetadataFile) *StaticResolver {
+	return &StaticResolver{metadata: metadata}
+}
+
+// Resolve returns the name and labels declared for siteId in the metadata file
+func (r *StaticResolver) Resolve(ctx context.Context, siteId string) (map[string]string, error) {
+	site, ok := r.metadata.Site(siteId)
+	if !ok {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(site.Labels)+1)
+	for k, v := range site.Labels {
+		labels[k] = v
+	}
+	if site.Name != "" {
+		labels["site_name"] = site.Name
+	}
+	return labels, nil
+}
+
+// UIResolver resolves labels from the Ubiquiti sites and hosts APIs
+type UIResolver struct {
+	client  *UbiquitiClient
+	apiRoot string
+	refresh time.Duration
+
+	mu        sync.Mutex
+	labels    map[string]map[string]string
+	fetchedAt time.Time
+}
+
+// NewUIResolver creates a resolver that caches site and host names from the
+// Ubiquiti API under apiRoot, refreshing them every refresh interval
+func NewUIResolver(client *UbiquitiClient, apiRoot string, refresh time.Duration) *UIResolver {
+	return &UIResolver{
+		client:  client,
+		apiRoot: strings.TrimSuffix(apiRoot, "/"),
+		refresh: refresh,
+	}
+}
+
+// Resolve returns the site name, timezone and host name for siteId
+func (r *UIResolver) Resolve(ctx context.Context, siteId string) (map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.labels == nil || time.Since(r.fetchedAt) > r.refresh {
+		labels, err := r.load(ctx)
+		if err != nil {
+			// Serve stale labels rather than none when a refresh fails
+			if r.labels != nil {
+				return r.labels[siteId], err
+			}
+			return nil, err
+		}
+		r.labels = labels
+		r.fetchedAt = time.Now()
+	}
+
+	return r.labels[siteId], nil
+}
+
+// load fetches sites and hosts and builds the label index keyed by siteId
+func (r *UIResolver) load(ctx context.Context) (map[string]map[string]string, error) {
+	sites, err := r.client.GetSites(ctx, r.apiRoot+"/sites")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sites: %w", err)
+	}
+	hosts, err := r.client.GetHosts(ctx, r.apiRoot+"/hosts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hosts: %w", err)
+	}
+
+	hostNames := make(map[string]string, len(hosts.Data))
+	for _, host := range hosts.Data {
+		name := host.ReportedState.Name
+		if name == "" {
+			name = host.ReportedState.Hostname
+		}
+		hostNames[host.ID] = name
+	}
+
+	labels := make(map[string]map[string]string, len(sites.Data))
+	for _, site := range sites.Data {
+		siteLabels := make(map[string]string)
+		// desc carries the display name, name is the controller's short name
+		if site.Meta.Desc != "" {
+			siteLabels["site_name"] = site.Meta.Desc
+		} else if site.Meta.Name != "" {
+			siteLabels["site_name"] = site.Meta.Name
+		}
+		if site.Meta.Timezone != "" {
+			siteLabels["site_timezone"] = site.Meta.Timezone
+		}
+		if name := hostNames[site.HostId]; name != "" {
+			siteLabels["host_name"] = name
+		}
+		labels[site.SiteId] = siteLabels
+	}
+
+	return labels, nil
+}
+
+// HTTPResolver resolves labels from an external lookup service. The URL template
+// must contain {siteId}, and the service must respond with a JSON object of strings.
+type HTTPResolver struct {
+	urlTemplate string
+	httpClient  *http.Client
+	ttl         time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedLabels
+}
+
+type cachedLabels struct {
+	labels    map[string]string
+	fetchedAt time.Time
+}
+
+// NewHTTPResolver creates a resolver that queries urlTemplate per site and caches results for ttl
+func NewHTTPResolver(urlTemplate string, ttl time.Duration) (*HTTPResolver, error) {
+	if !strings.Contains(urlTemplate, "{siteId}") {
+		return nil, fmt.Errorf("resolver URL %q must contain {siteId}", urlTemplate)
+	}
+	return &HTTPResolver{
+		urlTemplate: urlTemplate,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		ttl:   ttl,
+		cache: make(map[string]cachedLabels),
+	}, nil
+}
+
+// Resolve returns the labels the lookup service reports for siteId
+func (r *HTTPResolver) Resolve(ctx context.Context, siteId string) (map[string]string, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[siteId]
+	r.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < r.ttl {
+		return cached.labels, nil
+	}
+
+	lookupURL := strings.ReplaceAll(r.urlTemplate, "{siteId}", url.PathEscape(siteId))
+	req, err := http.NewRequestWithContext(ctx, "GET", lookupURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var labels map[string]string
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&labels); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+	case http.StatusNotFound:
+		// Unknown sites are cached as having no labels
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("lookup failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	r.mu.Lock()
+	r.cache[siteId] = cachedLabels{labels: labels, fetchedAt: time.Now()}
+	r.mu.Unlock()
+
+	return labels, nil
+}
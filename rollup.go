@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RollupMetric summarizes the periods of a site within one hourly, daily or other
+// rollup bucket
+type RollupMetric struct {
+	SiteId          string    `json:"siteId"`
+	HostId          string    `json:"hostId"`
+	Interval        string    `json:"interval"`
+	Start           string    `json:"start"`
+	End             string    `json:"end"`
+	Periods         int       `json:"periods"`
+	ExpectedPeriods int       `json:"expectedPeriods"`
+	AvgLatency      float64   `json:"avgLatency"`
+	MaxLatency      int       `json:"maxLatency"`
+	AvgDownloadKbps float64   `json:"avgDownloadKbps"`
+	AvgUploadKbps   float64   `json:"avgUploadKbps"`
+	AvgPacketLoss   float64   `json:"avgPacketLoss"`
+	MaxPacketLoss   int       `json:"maxPacketLoss"`
+	Uptime          int       `json:"uptime"`
+	Downtime        int       `json:"downtime"`
+	PublishedAt     time.Time `json:"publishedAt"`
+}
+
+// rollupInterval is an interval of --rollups
+type rollupInterval struct {
+	name     string
+	duration time.Duration
+}
+
+// RollupAggregator rolls the periods of every site up into buckets aligned to each
+// interval, and emits a bucket once a period after its end has been seen
+type RollupAggregator struct {
+	intervals []rollupInterval
+	period    time.Duration
+	periods   map[string]map[time.Time]WANData
+	published map[string]time.Time
+}
+
+// NewRollupAggregator creates an aggregator of periods of metricType (5m, 1h, 1d)
+// into intervals such as 1h and 1d
+func NewRollupAggregator(intervals []string, metricType string) (*RollupAggregator, error) {
+	period, err := parseDays(metricType)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported metric type %q for rollups", metricType)
+	}
+
+	r := &RollupAggregator{
+		period:    period,
+		periods:   make(map[string]map[time.Time]WANData),
+		published: make(map[string]time.Time),
+	}
+	for _, name := range intervals {
+		d, err := parseDays(name)
+		if err != nil || d <= period || d%period != 0 {
+			return nil, fmt.Errorf("invalid rollup interval %q, expected a multiple of the %s periods such as 1h or 1d", name, metricType)
+		}
+		r.intervals = append(r.intervals, rollupInterval{name: name, duration: d})
+	}
+	return r, nil
+}
+
+// Evaluate adds every period in metrics to its site's open buckets and returns the
+// buckets completed since the last poll, oldest first. Periods arriving after their
+// bucket was emitted are ignored.
+func (r *RollupAggregator) Evaluate(metrics *ISPMetrics) []RollupMetric {
+	var rollups []RollupMetric
+
+	for _, data := range metrics.Data {
+		periods, ok := r.periods[data.SiteId]
+		if !ok {
+			periods = make(map[time.Time]WANData)
+			r.periods[data.SiteId] = periods
+		}
+
+		var latest time.Time
+		for _, period := range data.Periods {
+			metricTime, err := time.Parse(time.RFC3339, period.MetricTime)
+			if err != nil {
+				continue
+			}
+			periods[metricTime] = period.Data.WAN
+			if metricTime.After(latest) {
+				latest = metricTime
+			}
+		}
+		if latest.IsZero() {
+			continue
+		}
+
+		// Periods before every interval's last emitted bucket are no longer needed
+		var keepFrom time.Time
+		for i, interval := range r.intervals {
+			key := data.SiteId + "/" + interval.name
+			rollups = append(rollups, r.complete(data, interval, periods, latest)...)
+			if published := r.published[key]; i == 0 || published.Before(keepFrom) {
+				keepFrom = published
+			}
+		}
+		for metricTime := range periods {
+			if metricTime.Before(keepFrom) {
+				delete(periods, metricTime)
+			}
+		}
+	}
+
+	return rollups
+}
+
+// complete summarizes the buckets of interval that ended by latest and were not emitted yet
+func (r *RollupAggregator) complete(data MetricData, interval rollupInterval, periods map[time.Time]WANData, latest time.Time) []RollupMetric {
+	key := data.SiteId + "/" + interval.name
+	since := r.published[key]
+
+	buckets := make(map[time.Time][]WANData)
+	for metricTime, wan := range periods {
+		start := metricTime.Truncate(interval.duration)
+		if start.Before(since) || start.Add(interval.duration).After(latest) {
+			continue
+		}
+		buckets[start] = append(buckets[start], wan)
+	}
+
+	starts := make([]time.Time, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	slices.SortFunc(starts, time.Time.Compare)
+
+	var rollups []RollupMetric
+	for _, start := range starts {
+		end := start.Add(interval.duration)
+		rollup := RollupMetric{
+			SiteId:          data.SiteId,
+			HostId:          data.HostId,
+			Interval:        interval.name,
+			Start:           start.Format(time.RFC3339),
+			End:             end.Format(time.RFC3339),
+			Periods:         len(buckets[start]),
+			ExpectedPeriods: int(interval.duration / r.period),
+			PublishedAt:     time.Now(),
+		}
+
+		var latency, download, upload, loss int
+		for _, wan := range buckets[start] {
+			latency += wan.AvgLatency
+			download += wan.DownloadKbps
+			upload += wan.UploadKbps
+			loss += wan.PacketLoss
+			rollup.MaxLatency = max(rollup.MaxLatency, wan.MaxLatency)
+			rollup.MaxPacketLoss = max(rollup.MaxPacketLoss, wan.PacketLoss)
+			rollup.Uptime += wan.Uptime
+			rollup.Downtime += wan.Downtime
+		}
+		n := float64(rollup.Periods)
+		rollup.AvgLatency = math.Round(float64(latency)/n*100) / 100
+		rollup.AvgDownloadKbps = math.Round(float64(download)/n*100) / 100
+		rollup.AvgUploadKbps = math.Round(float64(upload)/n*100) / 100
+		rollup.AvgPacketLoss = math.Round(float64(loss)/n*100) / 100
+
+		rollups = append(rollups, rollup)
+		r.published[key] = end
+	}
+	return rollups
+}
+
+// PublishRollup publishes a rollup bucket to the site's rollup topic followed by the
+// interval, e.g. <topic>/<siteId>/rollup/1h
+func (p *MQTTPublisher) PublishRollup(rollup RollupMetric, site Metric) error {
+	payload, err := json.Marshal(rollup)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollup: %w", err)
+	}
+
+	topic, err := p.siteTopic("rollup", site)
+	if err != nil {
+		return err
+	}
+	topic += "/" + rollup.Interval
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":   topic,
+		"siteId":  rollup.SiteId,
+		"start":   rollup.Start,
+		"periods": rollup.Periods,
+	}).Debug("Publishing rollup to MQTT")
+
+	if err := p.sendSite(topic, site, payload); err != nil {
+		return fmt.Errorf("failed to publish rollup to MQTT: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField describes a field of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+// cronFields are the fields of a cron expression with seconds, in order. Without
+// seconds the expression starts at the minute.
+var cronFields = []cronField{
+	{name: "second", min: 0, max: 59},
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// cronGapSamples is the number of scheduled times looked at for the nominal interval
+const cronGapSamples = 100
+
+// cronDescriptors are the shorthands accepted instead of the fields
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// CronSchedule is a parsed cron expression, evaluated in local time. Every field is
+// a bit set of the values it matches.
+type CronSchedule struct {
+	expr                                  string
+	second, minute, hour, dom, month, dow uint64
+	// Days match either field when both are restricted, as in cron
+	domAny, dowAny bool
+	// interval is the shortest gap between scheduled times, standing in for --interval
+	interval time.Duration
+}
+
+// ParseCronSchedule parses a cron expression of five fields (minute hour day-of-month
+// month day-of-week), or six with a leading second, or a descriptor such as @hourly.
+// Fields take *, values, ranges (a-b), steps (*/n, a-b/n) and comma-separated lists,
+// and months and weekdays their three-letter names.
+func ParseCronSchedule(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if descriptor, ok := cronDescriptors[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(descriptor)
+		}
+	}
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid schedule %q, expected 5 fields (minute hour day month weekday) or 6 with seconds first", expr)
+	}
+
+	s := &CronSchedule{expr: expr}
+	sets := []*uint64{&s.second, &s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+		*sets[i] = set
+	}
+	s.domAny = fields[3] == "*" || fields[3] == "?"
+	s.dowAny = fields[5] == "*" || fields[5] == "?"
+
+	// 7 is Sunday as well as 0
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	if s.interval = s.minGap(time.Now()); s.interval == 0 {
+		return nil, fmt.Errorf("schedule %q never matches", expr)
+	}
+	return s, nil
+}
+
+// parseCronField returns the bit set of the values matched by a field
+func parseCronField(field string, def cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, def.name)
+			}
+			step = n
+		}
+
+		low, high := def.min, def.max
+		if rangePart != "*" && rangePart != "?" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseCronValue(lowPart, def); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseCronValue(highPart, def); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// a/n runs from a to the end of the field
+				high = def.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangePart, def.name)
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// parseCronValue parses a number or name within the bounds of a field
+func parseCronValue(value string, def cronField) (int, error) {
+	for i, name := range def.names {
+		if strings.EqualFold(value, name) {
+			// Months are numbered from 1, weekdays from 0
+			return def.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < def.min || n > def.max {
+		return 0, fmt.Errorf("invalid %s %q, expected %d-%d", def.name, value, def.min, def.max)
+	}
+	return n, nil
+}
+
+// Next returns the first time after t matched by the schedule, or the zero time if
+// none does within five years, such as on the 30th of February
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	// Each step moves to the start of the next candidate of the first field that
+	// does not match, resetting the finer fields. Hours and minutes are stepped in
+	// elapsed time, so daylight saving changes neither skip nor repeat them.
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			next = t.Add(time.Duration(60-t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
+		case s.minute&(1<<t.Minute()) == 0:
+			next = t.Add(time.Duration(60-t.Second()) * time.Second)
+		case s.second&(1<<t.Second()) == 0:
+			next = t.Add(time.Second)
+		default:
+			return t
+		}
+		if !next.After(t) {
+			// A wall-clock time skipped or repeated by daylight saving may map to an earlier instant
+			next = t.Add(time.Hour)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day-of-month and day-of-week fields
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// minGap returns the shortest gap between the next cronGapSamples times after t, the
+// nominal interval of the schedule
+func (s *CronSchedule) minGap(t time.Time) time.Duration {
+	var gap time.Duration
+	prev := s.Next(t)
+	for i := 0; i < cronGapSamples && !prev.IsZero(); i++ {
+		next := s.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if d := next.Sub(prev); gap == 0 || d < gap {
+			gap = d
+		}
+		prev = next
+	}
+	return gap
+}
+
+// String returns the expression the schedule was parsed from
+func (s *CronSchedule) String() string {
+	return s.expr
+}
+
+// pollInterval returns the nominal time between polls: --interval, or the shortest
+// gap between the times of the --schedule
+func (cli *CLI) pollInterval() time.Duration {
+	if cli.schedule != nil {
+		return cli.schedule.interval
+	}
+	return cli.Interval
+}
+
+// pollTicker delivers the ticks that poll the API: every --interval, or at the times
+// of the --schedule
+type pollTicker struct {
+	schedule *CronSchedule
+	ticker   *time.Ticker
+	timer    *time.Timer
+}
+
+// newPollTicker starts a ticker firing every interval, or at the times of schedule
+// when it is not nil
+func newPollTicker(interval time.Duration, schedule *CronSchedule) *pollTicker {
+	if schedule == nil {
+		return &pollTicker{ticker: time.NewTicker(interval)}
+	}
+	t := &pollTicker{schedule: schedule, timer: time.NewTimer(0)}
+	t.timer.Stop()
+	t.arm()
+	return t
+}
+
+// C returns the channel the ticks are delivered on
+func (t *pollTicker) C() <-chan time.Time {
+	if t.ticker != nil {
+		return t.ticker.C
+	}
+	return t.timer.C
+}
+
+// Ticked arms the timer for the scheduled time following a received tick
+func (t *pollTicker) Ticked() {
+	if t.timer != nil {
+		t.arm()
+	}
+}
+
+// arm sets the timer to the next scheduled time
+func (t *pollTicker) arm() {
+	next := t.schedule.Next(time.Now())
+	if next.IsZero() {
+		// The schedule never matches again, the timer stays stopped
+		return
+	}
+	t.timer.Reset(time.Until(next))
+}
+
+// Reset changes the interval of a ticker, a schedule keeps its times
+func (t *pollTicker) Reset(interval time.Duration) {
+	if t.ticker != nil {
+		t.ticker.Reset(interval)
+	}
+}
+
+// Stop stops the ticks
+func (t *pollTicker) Stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	} else {
+		t.timer.Stop()
+	}
+}
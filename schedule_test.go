@@ -0,0 +1,217 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		expr         string
+		wantErr      string
+		wantInterval time.Duration
+	}{
+		{expr: "*/5 * * * *", wantInterval: 5 * time.Minute},
+		{expr: "30 */5 * * * *", wantInterval: 5 * time.Minute},
+		{expr: "0,30 * * * *", wantInterval: 30 * time.Minute},
+		{expr: "5-55/10 * * * *", wantInterval: 10 * time.Minute},
+		{expr: "2 8-18 * * mon-fri", wantInterval: time.Hour},
+		{expr: "0 0 1 jan *", wantInterval: 365 * 24 * time.Hour},
+		{expr: "@hourly", wantInterval: time.Hour},
+		{expr: "@Daily", wantInterval: 24 * time.Hour},
+		{expr: "0 0 * * 7", wantInterval: 7 * 24 * time.Hour},
+		{expr: "* * * *", wantErr: "expected 5 fields"},
+		{expr: "* * * * * * *", wantErr: "expected 5 fields"},
+		{expr: "@often", wantErr: "expected 5 fields"},
+		{expr: "60 * * * *", wantErr: "invalid minute"},
+		{expr: "* 24 * * *", wantErr: "invalid hour"},
+		{expr: "* * 0 * *", wantErr: "invalid day of month"},
+		{expr: "* * * 13 *", wantErr: "invalid month"},
+		{expr: "* * * * 8", wantErr: "invalid day of week"},
+		{expr: "* * * foo *", wantErr: "invalid month"},
+		{expr: "*/0 * * * *", wantErr: "invalid step"},
+		{expr: "*/x * * * *", wantErr: "invalid step"},
+		{expr: "30-10 * * * *", wantErr: "invalid range"},
+		{expr: "0 0 30 feb *", wantErr: "never matches"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseCronSchedule(tt.expr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s.interval != tt.wantInterval {
+				t.Errorf("got interval %v, want %v", s.interval, tt.wantInterval)
+			}
+			if s.String() != tt.expr {
+				t.Errorf("got String() %q, want %q", s.String(), tt.expr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+	utc := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	local := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02T15:04:05", s, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		// want are the following scheduled times, as instants
+		want []time.Time
+	}{
+		{
+			name: "every 5 minutes",
+			expr: "*/5 * * * *",
+			from: utc("2025-09-21T17:03:12Z"),
+			want: []time.Time{utc("2025-09-21T17:05:00Z"), utc("2025-09-21T17:10:00Z"), utc("2025-09-21T17:15:00Z")},
+		},
+		{
+			name: "exactly on a scheduled time moves to the next",
+			expr: "*/5 * * * *",
+			from: utc("2025-09-21T17:05:00Z"),
+			want: []time.Time{utc("2025-09-21T17:10:00Z")},
+		},
+		{
+			name: "seconds field",
+			expr: "30 */5 * * * *",
+			from: utc("2025-09-21T17:05:00Z"),
+			want: []time.Time{utc("2025-09-21T17:05:30Z"), utc("2025-09-21T17:10:30Z")},
+		},
+		{
+			name: "step over a range",
+			expr: "5-20/5 * * * *",
+			from: utc("2025-09-21T17:18:00Z"),
+			want: []time.Time{utc("2025-09-21T17:20:00Z"), utc("2025-09-21T18:05:00Z")},
+		},
+		{
+			name: "step from a value",
+			expr: "50/5 * * * *",
+			from: utc("2025-09-21T17:00:00Z"),
+			want: []time.Time{utc("2025-09-21T17:50:00Z"), utc("2025-09-21T17:55:00Z"), utc("2025-09-21T18:50:00Z")},
+		},
+		{
+			name: "weekdays by name skip the weekend",
+			expr: "0 8 * * mon-fri",
+			from: utc("2025-09-19T09:00:00Z"), // a Friday
+			want: []time.Time{utc("2025-09-22T08:00:00Z"), utc("2025-09-23T08:00:00Z")},
+		},
+		{
+			name: "Sunday as 7",
+			expr: "0 0 * * 7",
+			from: utc("2025-09-21T12:00:00Z"), // a Sunday
+			want: []time.Time{utc("2025-09-28T00:00:00Z")},
+		},
+		{
+			name: "day of month or day of week when both are restricted",
+			expr: "0 0 13 * fri",
+			from: utc("2026-02-01T00:00:00Z"),
+			want: []time.Time{
+				utc("2026-02-06T00:00:00Z"), // Friday
+				utc("2026-02-13T00:00:00Z"), // the 13th, also a Friday
+				utc("2026-02-20T00:00:00Z"), // Friday
+				utc("2026-02-27T00:00:00Z"), // Friday
+				utc("2026-03-06T00:00:00Z"), // Friday
+				utc("2026-03-13T00:00:00Z"), // the 13th, also a Friday
+				utc("2026-03-20T00:00:00Z"), // Friday
+			},
+		},
+		{
+			name: "day of month alone when day of week is *",
+			expr: "0 0 13 * *",
+			from: utc("2026-02-01T00:00:00Z"),
+			want: []time.Time{utc("2026-02-13T00:00:00Z"), utc("2026-03-13T00:00:00Z")},
+		},
+		{
+			name: "day of week alone when day of month is ?",
+			expr: "0 0 ? * mon",
+			from: utc("2026-02-01T00:00:00Z"),
+			want: []time.Time{utc("2026-02-02T00:00:00Z"), utc("2026-02-09T00:00:00Z")},
+		},
+		{
+			name: "31st skips shorter months",
+			expr: "0 0 31 * *",
+			from: utc("2026-01-31T12:00:00Z"),
+			want: []time.Time{utc("2026-03-31T00:00:00Z"), utc("2026-05-31T00:00:00Z")},
+		},
+		{
+			name: "29th of February in the next leap year",
+			expr: "0 0 29 feb *",
+			from: utc("2025-03-01T00:00:00Z"),
+			want: []time.Time{utc("2028-02-29T00:00:00Z")},
+		},
+		{
+			name: "time skipped by the change to daylight saving time is not polled",
+			expr: "30 2 * * *",
+			from: local("2026-03-07T12:00:00"),
+			want: []time.Time{local("2026-03-09T02:30:00")},
+		},
+		{
+			name: "steps continue across the change to daylight saving time",
+			expr: "*/30 * * * *",
+			from: local("2026-03-08T01:10:00"),
+			want: []time.Time{utc("2026-03-08T06:30:00Z"), utc("2026-03-08T07:00:00Z"), utc("2026-03-08T07:30:00Z")},
+		},
+		{
+			name: "time repeated by the change from daylight saving time is polled both times",
+			expr: "30 1 * * *",
+			from: local("2026-10-31T12:00:00"),
+			want: []time.Time{utc("2026-11-01T05:30:00Z"), utc("2026-11-01T06:30:00Z"), utc("2026-11-02T06:30:00Z")},
+		},
+		{
+			name: "steps repeat the hour repeated by the change from daylight saving time",
+			expr: "*/30 * * * *",
+			from: local("2026-11-01T00:45:00"),
+			want: []time.Time{utc("2026-11-01T05:00:00Z"), utc("2026-11-01T05:30:00Z"), utc("2026-11-01T06:00:00Z"), utc("2026-11-01T06:30:00Z"), utc("2026-11-01T07:00:00Z")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCronSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			next := tt.from
+			for i, want := range tt.want {
+				next = s.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("time %d: got %v, want %v", i+1, next, want.In(tt.from.Location()))
+				}
+			}
+		})
+	}
+}
+
+func TestCronScheduleNextNeverMatches(t *testing.T) {
+	// Parsing rejects such a schedule, built here to check Next gives up
+	s := &CronSchedule{second: 1, minute: 1, hour: 1, dom: 1 << 30, month: 1 << 2, domAny: false, dowAny: true}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("got %v, want the zero time", next)
+	}
+}
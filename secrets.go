@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// secretFile is a secret option that may instead be read from a file, named by its
+// -file option where it has one or else by its environment variable with a _FILE
+// suffix, following the Docker and Kubernetes secrets convention
+type secretFile struct {
+	option string
+	value  *string
+	file   *string
+}
+
+// secretFiles returns the secret options that can be read from a file
+func (cli *CLI) secretFiles() []secretFile {
+	return []secretFile{
+		{"--api-key", &cli.ApiKey, &cli.ApiKeyFile},
+		{"--local-api-key", &cli.LocalApiKey, &cli.LocalApiKeyFile},
+		{"--local-password", &cli.LocalPassword, &cli.LocalPasswordFile},
+		{"--mqtt-password", &cli.MqttPassword, &cli.MqttPasswordFile},
+		{"--influx-token", &cli.InfluxToken, nil},
+		{"--kafka-password", &cli.KafkaPassword, nil},
+		{"--kafka-schema-registry-password", &cli.KafkaSchemaRegistryPassword, nil},
+		{"--nats-token", &cli.NatsToken, nil},
+		{"--nats-password", &cli.NatsPassword, nil},
+		{"--redis-url", &cli.RedisURL, nil},
+		{"--postgres-url", &cli.PostgresURL, nil},
+		{"--webhook-bearer-token", &cli.WebhookBearerToken, nil},
+		{"--webhook-password", &cli.WebhookPassword, nil},
+		{"--datadog-api-key", &cli.DatadogApiKey, nil},
+		{"--newrelic-license-key", &cli.NewrelicLicenseKey, nil},
+		{"--remote-write-password", &cli.RemoteWritePassword, nil},
+		{"--remote-write-bearer-token", &cli.RemoteWriteBearerToken, nil},
+		{"--eventhubs-connection-string", &cli.EventhubsConnectionString, nil},
+		{"--vault-token", &cli.VaultToken, nil},
+		{"--vault-secret-id", &cli.VaultSecretId, nil},
+	}
+}
+
+// secretOptions returns the secret options and the MQTT username, which can be read
+// from Vault or AWS, by option name without the leading dashes
+func (cli *CLI) secretOptions() map[string]*string {
+	options := map[string]*string{"mqtt-username": &cli.MqttUsername}
+	for _, secret := range cli.secretFiles() {
+		options[strings.TrimPrefix(secret.option, "--")] = secret.value
+	}
+	return options
+}
+
+// secretFileEnvar returns the _FILE environment variable of a secret option, which
+// for options with a -file option is the environment variable of that option
+func secretFileEnvar(option string) string {
+	return "UBIPOLLER_" + strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(option, "--"), "-", "_")) + "_FILE"
+}
+
+// AfterApply reads the secrets given as files, then those in Vault, and resolves
+// AWS references once the command line, config file and environment have been
+// applied, so every command sees them like their options. Kong calls it once for the command and once for the embedded
+// CLI of subcommands.
+func (cli *CLI) AfterApply() error {
+	if cli.secretsRead {
+		return nil
+	}
+	cli.secretsRead = true
+
+	for _, secret := range cli.secretFiles() {
+		source, path := secretFileEnvar(secret.option), ""
+		if secret.file != nil {
+			source, path = secret.option+"-file", *secret.file
+		} else {
+			path = os.Getenv(source)
+		}
+		if path == "" {
+			continue
+		}
+		if *secret.value != "" {
+			return fmt.Errorf("%s and %s cannot both be set", secret.option, source)
+		}
+		value, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		*secret.value = value
+	}
+	if err := cli.readAccountsFile(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if cli.VaultAddr != "" {
+		if err := cli.loadVaultSecrets(ctx); err != nil {
+			return err
+		}
+	}
+	return cli.resolveAWSSecrets(ctx)
+}
+
+// accountsFileEnvar names the file the keys of --accounts are read from
+const accountsFileEnvar = "UBIPOLLER_ACCOUNTS_FILE"
+
+// readAccountsFile reads the keys of --accounts from the file named by
+// UBIPOLLER_ACCOUNTS_FILE, if set
+func (cli *CLI) readAccountsFile() error {
+	path := os.Getenv(accountsFileEnvar)
+	if path == "" {
+		return nil
+	}
+	if len(cli.Accounts) > 0 {
+		return fmt.Errorf("--accounts and %s cannot both be set", accountsFileEnvar)
+	}
+	value, err := readSecretFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", accountsFileEnvar, err)
+	}
+	if cli.Accounts, err = parseAccounts(value); err != nil {
+		return fmt.Errorf("%s: %w", accountsFileEnvar, err)
+	}
+	return nil
+}
+
+// parseAccounts parses API keys by account name given as name=key pairs, one per
+// line or separated by semicolons as on the command line. Blank lines and lines
+// starting with # are skipped.
+func parseAccounts(value string) (map[string]string, error) {
+	accounts := make(map[string]string)
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, pair := range strings.Split(line, ";") {
+			name, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid account %q, expected name=key", pair)
+			}
+			name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+			if _, dup := accounts[name]; dup {
+				return nil, fmt.Errorf("account %q is set more than once", name)
+			}
+			accounts[name] = key
+		}
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("no accounts found")
+	}
+	return accounts, nil
+}
+
+// readSecretFile reads a secret from path, trimming the surrounding whitespace and
+// trailing newline editors and secret mounts leave behind
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import "github.com/sirupsen/logrus"
+
+// defaultServiceName is the name the Windows service is installed under by default
+const defaultServiceName = "ubipoller"
+
+// ServiceCmd installs and controls the poller as a Windows service
+type ServiceCmd struct {
+	Install   ServiceInstallCmd   `kong:"cmd,help='Install the poller as a Windows service started at boot, running with the options given after --'"`
+	Uninstall ServiceUninstallCmd `kong:"cmd,help='Remove the Windows service and its event log source'"`
+	Start     ServiceStartCmd     `kong:"cmd,help='Start the Windows service'"`
+	Stop      ServiceStopCmd      `kong:"cmd,help='Stop the Windows service'"`
+}
+
+// ServiceInstallCmd installs the Windows service
+type ServiceInstallCmd struct {
+	Name        string   `kong:"default='ubipoller',help='Name of the Windows service'"`
+	DisplayName string   `kong:"default='UbiPoller',help='Display name of the Windows service'"`
+	Args        []string `kong:"arg,optional,passthrough,help='Options the service runs with (e.g. -- --config C:\\ProgramData\\ubipoller\\ubipoller.yaml)'"`
+}
+
+// Run installs the service with an event log source of the same name
+func (c *ServiceInstallCmd) Run() error {
+	args := c.Args
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if c.Name != defaultServiceName {
+		args = append([]string{"--service-name", c.Name}, args...)
+	}
+	if err := installService(c.Name, c.DisplayName, args); err != nil {
+		return err
+	}
+	logrus.WithField("service", c.Name).Info("Installed Windows service")
+	return nil
+}
+
+// ServiceUninstallCmd removes the Windows service
+type ServiceUninstallCmd struct {
+	Name string `kong:"default='ubipoller',help='Name of the Windows service'"`
+}
+
+// Run removes the service and its event log source
+func (c *ServiceUninstallCmd) Run() error {
+	if err := removeService(c.Name); err != nil {
+		return err
+	}
+	logrus.WithField("service", c.Name).Info("Removed Windows service")
+	return nil
+}
+
+// ServiceStartCmd starts the Windows service
+type ServiceStartCmd struct {
+	Name string `kong:"default='ubipoller',help='Name of the Windows service'"`
+}
+
+// Run starts the service
+func (c *ServiceStartCmd) Run() error {
+	return startService(c.Name)
+}
+
+// ServiceStopCmd stops the Windows service
+type ServiceStopCmd struct {
+	Name string `kong:"default='ubipoller',help='Name of the Windows service'"`
+}
+
+// Run stops the service and waits for it to exit
+func (c *ServiceStopCmd) Run() error {
+	return stopService(c.Name)
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errServiceUnsupported is returned by the service commands outside Windows
+var errServiceUnsupported = errors.New("Windows services are only supported on Windows, use a systemd unit instead")
+
+// isWindowsService reports whether the poller was started by the Windows service manager
+func isWindowsService() bool {
+	return false
+}
+
+// runWindowsService is only called when isWindowsService reports true
+func runWindowsService(name string, logger *logrus.Logger, run func(ctx context.Context) error) error {
+	return errServiceUnsupported
+}
+
+func installService(name, displayName string, args []string) error {
+	return errServiceUnsupported
+}
+
+func removeService(name string) error {
+	return errServiceUnsupported
+}
+
+func startService(name string) error {
+	return errServiceUnsupported
+}
+
+func stopService(name string) error {
+	return errServiceUnsupported
+}
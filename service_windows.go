@@ -0,0 +1,218 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout is how long stopService waits for the service to exit
+const serviceStopTimeout = 30 * time.Second
+
+// isWindowsService reports whether the poller was started by the Windows service manager
+func isWindowsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// runWindowsService runs the poller under the service manager until it is stopped,
+// logging to the Windows event log under the service name
+func runWindowsService(name string, logger *logrus.Logger, run func(ctx context.Context) error) error {
+	events, err := eventlog.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open event log %s: %w", name, err)
+	}
+	defer events.Close()
+
+	// A service has no console, so the event log is the only log output
+	logger.AddHook(&eventLogHook{events: events})
+	logger.SetOutput(io.Discard)
+
+	return svc.Run(name, &windowsService{run: run, logger: logger})
+}
+
+// windowsService handles the requests of the service manager
+type windowsService struct {
+	run    func(ctx context.Context) error
+	logger *logrus.Logger
+}
+
+// Execute runs the poller and cancels it on a stop or shutdown request, returning a
+// service-specific exit code of 1 when it failed
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx) }()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				s.logger.WithError(err).Error("Poller failed")
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s.logger.Info("Received service stop request")
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
+
+// eventLogHook writes log entries to the Windows event log
+type eventLogHook struct {
+	events *eventlog.Log
+}
+
+// Levels implements logrus.Hook
+func (h *eventLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook, mapping log levels onto event types
+func (h *eventLogHook) Fire(entry *logrus.Entry) error {
+	message, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return h.events.Error(1, message)
+	case logrus.WarnLevel:
+		return h.events.Warning(1, message)
+	default:
+		return h.events.Info(1, message)
+	}
+}
+
+// installService creates an automatically started service running this executable
+// with args, restarted a minute after it fails, and its event log source
+func installService(name, displayName string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: displayName,
+		Description: "Polls the Ubiquiti ISP metrics API and publishes WAN metrics",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %w", name, err)
+	}
+	defer s.Close()
+
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}, uint32((24 * time.Hour).Seconds())); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set recovery actions of service %s: %w", name, err)
+	}
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to install event log source %s: %w", name, err)
+	}
+	return nil
+}
+
+// removeService deletes the service and its event log source
+func removeService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service %s: %w", name, err)
+	}
+	if err := eventlog.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove event log source %s: %w", name, err)
+	}
+	return nil
+}
+
+// startService starts the service
+func startService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start service %s: %w", name, err)
+	}
+	return nil
+}
+
+// stopService asks the service to stop and waits for it to exit
+func stopService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("failed to stop service %s: %w", name, err)
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop within %s", name, serviceStopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("failed to query service %s: %w", name, err)
+		}
+	}
+	return nil
+}
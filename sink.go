@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metric is a single site's WAN data for one period, as delivered to sinks
+type Metric struct {
+	SiteId      string
+	HostId      string
+	MetricType  string
+	Timestamp   string
+	WAN         WANData
+	Labels      map[string]string
+	PublishedAt time.Time
+}
+
+// wanValue is a single numeric WAN value, as sent by sinks storing one series per value
+type wanValue struct {
+	name  string
+	value int
+}
+
+// wanValues returns the numeric WAN values of a period
+func wanValues(wan WANData) []wanValue {
+	return []wanValue{
+		{"avg_latency_ms", wan.AvgLatency},
+		{"max_latency_ms", wan.MaxLatency},
+		{"download_kbps", wan.DownloadKbps},
+		{"upload_kbps", wan.UploadKbps},
+		{"packet_loss", wan.PacketLoss},
+		{"uptime", wan.Uptime},
+		{"downtime", wan.Downtime},
+	}
+}
+
+// lookupWANValue returns the WAN value called name, as named by wanValues
+func lookupWANValue(wan WANData, name string) (int, bool) {
+	for _, v := range wanValues(wan) {
+		if v.name == name {
+			return v.value, true
+		}
+	}
+	return 0, false
+}
+
+// Sink is an output destination for metrics
+type Sink interface {
+	// Name identifies the sink in logs and configuration
+	Name() string
+	// Publish delivers a single metric
+	Publish(ctx context.Context, metric Metric) error
+	// Close flushes and releases the sink's resources
+	Close() error
+}
+
+// BatchSink is implemented by sinks that can deliver all metrics of a cycle at once
+type BatchSink interface {
+	Sink
+	PublishBatch(ctx context.Context, metrics []Metric) error
+}
+
+// ConcurrentSink is implemented by sinks that can publish the metrics of a cycle
+// concurrently, returning the error of each metric
+type ConcurrentSink interface {
+	Sink
+	PublishConcurrent(ctx context.Context, metrics []Metric) []error
+}
+
+// publishConcurrently calls publish for every metric with up to workers goroutines
+// and returns the error of each. The metrics of a site are published by one worker in
+// order, so periods of --publish-all-periods and the topics of a site never overtake
+// each other.
+func publishConcurrently(ctx context.Context, metrics []Metric, workers int, publish func(context.Context, Metric) error) []error {
+	var sites [][]int
+	index := make(map[string]int)
+	for i, metric := range metrics {
+		site, ok := index[metric.SiteId]
+		if !ok {
+			site = len(sites)
+			index[metric.SiteId] = site
+			sites = append(sites, nil)
+		}
+		sites[site] = append(sites[site], i)
+	}
+
+	errs := make([]error, len(metrics))
+	jobs := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(sites)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for site := range jobs {
+				for _, i := range site {
+					errs[i] = publish(ctx, metrics[i])
+				}
+			}
+		}()
+	}
+	for _, site := range sites {
+		jobs <- site
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
+
+// MultiSink fans metrics out to several sinks
+type MultiSink struct {
+	sinks  []Sink
+	logger *logrus.Logger
+}
+
+// NewMultiSink creates a sink that publishes to all given sinks
+func NewMultiSink(logger *logrus.Logger, sinks ...Sink) *MultiSink {
+	return &MultiSink{
+		sinks:  sinks,
+		logger: logger,
+	}
+}
+
+// PublishAll publishes metrics to every sink, using PublishBatch or PublishConcurrent
+// where supported. The returned slice holds the combined error of all sinks for each
+// metric.
+func (m *MultiSink) PublishAll(ctx context.Context, metrics []Metric) []error {
+	errs := make([][]error, len(metrics))
+
+	for _, sink := range m.sinks {
+		ctx, span := tracer.Start(ctx, "publish "+sink.Name(), trace.WithAttributes(
+			attribute.String("ubipoller.sink", sink.Name()),
+			attribute.Int("ubipoller.metrics", len(metrics)),
+		))
+
+		if batch, ok := sink.(BatchSink); ok {
+			err := batch.PublishBatch(ctx, metrics)
+			if err != nil {
+				m.logger.WithError(err).WithField("sink", sink.Name()).Error("Failed to publish metrics")
+				for i := range metrics {
+					errs[i] = append(errs[i], fmt.Errorf("%s: %w", sink.Name(), err))
+				}
+			}
+			endSpan(span, err)
+			continue
+		}
+
+		var sinkErrs []error
+		if concurrent, ok := sink.(ConcurrentSink); ok {
+			sinkErrs = concurrent.PublishConcurrent(ctx, metrics)
+		} else {
+			sinkErrs = make([]error, len(metrics))
+			for i, metric := range metrics {
+				sinkErrs[i] = sink.Publish(ctx, metric)
+			}
+		}
+
+		var failed []error
+		for i, metric := range metrics {
+			if err := sinkErrs[i]; err != nil {
+				m.logger.WithError(err).WithFields(logrus.Fields{
+					"sink":   sink.Name(),
+					"siteId": metric.SiteId,
+				}).Error("Failed to publish metric")
+				errs[i] = append(errs[i], fmt.Errorf("%s: %w", sink.Name(), err))
+				failed = append(failed, err)
+			}
+		}
+		endSpan(span, errors.Join(failed...))
+	}
+
+	joined := make([]error, len(metrics))
+	for i := range errs {
+		joined[i] = errors.Join(errs[i]...)
+	}
+	return joined
+}
+
+// Close closes every sink
+func (m *MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// hasSink reports whether name is among the configured sinks
+func hasSink(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newSinks builds the sinks selected by --sinks
+func newSinks(cli *CLI, mqttPublisher *MQTTPublisher, telemetry *Telemetry, logger *logrus.Logger) ([]Sink, error) {
+	if len(cli.Sinks) == 0 {
+		return nil, fmt.Errorf("at least one sink must be configured")
+	}
+
+	var sinks []Sink
+	for _, name := range cli.Sinks {
+		switch name {
+		case "mqtt":
+			if cli.MqttPublishWorkers < 1 {
+				return nil, fmt.Errorf("--mqtt-publish-workers must be at least 1")
+			}
+			// A dry run prints the messages in order
+			workers := cli.MqttPublishWorkers
+			if cli.DryRun {
+				workers = 1
+			}
+			mqttSink := NewMQTTSink(mqttPublisher, cli.PublishWAN || cli.HADiscovery, cli.MqttTopicMode, workers)
+			var sink Sink = mqttSink
+			switch {
+			// A failed batch is not buffered but retried whole on the next poll, as its
+			// periods are not recorded as published
+			case cli.MqttBatch:
+				sink = &MQTTBatchSink{mqttSink, cli.MqttBatchTopic}
+			case cli.DryRun:
+			case cli.MqttBufferDir != "":
+				if cli.MqttBufferSize <= 0 {
+					return nil, fmt.Errorf("--mqtt-buffer-dir requires a positive --mqtt-buffer-size")
+				}
+				queue, err := newDiskQueue(cli.MqttBufferDir, cli.MqttBufferSize)
+				if err != nil {
+					return nil, fmt.Errorf("failed to open MQTT publish queue: %w", err)
+				}
+				if n := queue.Len(); n > 0 {
+					logger.WithField("queued", n).Info("Loaded buffered metrics from a previous run")
+				}
+				sink = NewBufferedSink(sink, queue, logger)
+			// A command that exits would discard the memory buffer and hide the failure
+			case cli.MqttBufferSize > 0 && !cli.Once && !cli.exits:
+				sink = NewBufferedSink(sink, newMemoryQueue(cli.MqttBufferSize), logger)
+			}
+			sinks = append(sinks, sink)
+		case "prometheus":
+			exporter, err := NewPrometheusExporter(cli.PrometheusAddr, 3*cli.pollInterval(), telemetry, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Prometheus exporter: %w", err)
+			}
+			sinks = append(sinks, exporter)
+		case "influx":
+			writer, err := NewInfluxWriter(cli.InfluxURL, cli.InfluxOrg, cli.InfluxBucket, cli.InfluxToken, cli.InfluxMeasurement, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create InfluxDB writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "kafka":
+			writer, err := NewKafkaWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Kafka writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "nats":
+			publisher, err := NewNATSPublisher(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create NATS publisher: %w", err)
+			}
+			sinks = append(sinks, publisher)
+		case "redis":
+			publisher, err := NewRedisPublisher(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Redis publisher: %w", err)
+			}
+			sinks = append(sinks, publisher)
+		case "postgres":
+			writer, err := NewPostgresWriter(context.Background(), cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create PostgreSQL writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "file":
+			writer, err := NewFileWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create file writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "stdout":
+			sinks = append(sinks, NewStdoutWriter(os.Stdout))
+		case "webhook":
+			writer, err := NewWebhookWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create webhook writer: %w", err)
+			}
+			if cli.WebhookBatch {
+				sinks = append(sinks, &WebhookBatchWriter{writer})
+			} else {
+				sinks = append(sinks, writer)
+			}
+		case "statsd":
+			writer, err := NewStatsDWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create StatsD writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "graphite":
+			writer, err := NewGraphiteWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Graphite writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "cloudwatch":
+			writer, err := NewCloudWatchWriter(context.Background(), cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create CloudWatch writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "datadog":
+			writer, err := NewDatadogWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Datadog writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "newrelic":
+			writer, err := NewNewRelicWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create New Relic writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "remote-write":
+			writer, err := NewRemoteWriteWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create remote write writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "otlp":
+			exporter, err := NewOTLPMetricsExporter(context.Background(), cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create OTLP metrics exporter: %w", err)
+			}
+			sinks = append(sinks, exporter)
+		case "eventhubs":
+			writer, err := NewEventHubsWriter(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Event Hubs writer: %w", err)
+			}
+			sinks = append(sinks, writer)
+		case "sparkplug":
+			publisher, err := NewSparkplugPublisher(cli, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create Sparkplug publisher: %w", err)
+			}
+			sinks = append(sinks, publisher)
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+
+	return sinks, nil
+}
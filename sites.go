@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// SitesResponse represents the Ubiquiti sites API response
+type SitesResponse struct {
+	Data []Site `json:"data"`
+	Pagination
+}
+
+// Site represents a UniFi site as returned by the sites API
+type Site struct {
+	SiteId string   `json:"siteId"`
+	HostId string   `json:"hostId"`
+	Meta   SiteMeta `json:"meta"`
+}
+
+type SiteMeta struct {
+	Name     string `json:"name"`
+	Desc     string `json:"desc"`
+	Timezone string `json:"timezone"`
+}
+
+// HostsResponse represents the Ubiquiti hosts API response
+type HostsResponse struct {
+	Data []Host `json:"data"`
+	Pagination
+}
+
+// Host represents a UniFi host (console) as returned by the hosts API
+type Host struct {
+	ID            string            `json:"id"`
+	HardwareID    string            `json:"hardwareId"`
+	Type          string            `json:"type"`
+	IPAddress     string            `json:"ipAddress"`
+	ReportedState HostReportedState `json:"reportedState"`
+}
+
+type HostReportedState struct {
+	Hostname string       `json:"hostname"`
+	Name     string       `json:"name"`
+	Version  string       `json:"version"`
+	State    string       `json:"state"`
+	Hardware HostHardware `json:"hardware"`
+}
+
+type HostHardware struct {
+	Name      string `json:"name"`
+	Shortname string `json:"shortname"`
+}
+
+// DevicesResponse represents the Ubiquiti devices API response, grouped by host
+type DevicesResponse struct {
+	Data []HostDevices `json:"data"`
+	Pagination
+}
+
+// HostDevices lists the devices managed by a host
+type HostDevices struct {
+	HostId   string   `json:"hostId"`
+	HostName string   `json:"hostName"`
+	Devices  []Device `json:"devices"`
+}
+
+// Device represents a UniFi device as returned by the devices API
+type Device struct {
+	ID          string     `json:"id"`
+	MAC         string     `json:"mac"`
+	Name        string     `json:"name"`
+	Model       string     `json:"model"`
+	Shortname   string     `json:"shortname"`
+	ProductLine string     `json:"productLine"`
+	Status      string     `json:"status"`
+	Version     string     `json:"version"`
+	IsConsole   bool       `json:"isConsole"`
+	StartupTime *time.Time `json:"startupTime"`
+}
+
+// GetSites fetches all sites visible to the API key, following every page
+func (c *UbiquitiClient) GetSites(ctx context.Context, url string) (*SitesResponse, error) {
+	return getAllPages[SitesResponse](ctx, c, url)
+}
+
+// GetHosts fetches all hosts visible to the API key, following every page
+func (c *UbiquitiClient) GetHosts(ctx context.Context, url string) (*HostsResponse, error) {
+	return getAllPages[HostsResponse](ctx, c, url)
+}
+
+// GetDevices fetches the devices of all hosts visible to the API key, following every page
+func (c *UbiquitiClient) GetDevices(ctx context.Context, url string) (*DevicesResponse, error) {
+	return getAllPages[DevicesResponse](ctx, c, url)
+}
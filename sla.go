@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SLAMetric is a site's availability over a rolling window, computed from the
+// uptime and downtime of the periods within it
+type SLAMetric struct {
+	SiteId          string    `json:"siteId"`
+	HostId          string    `json:"hostId"`
+	Window          string    `json:"window"`
+	Availability    float64   `json:"availability"`
+	DowntimeSeconds int       `json:"downtimeSeconds"`
+	Periods         int       `json:"periods"`
+	Coverage        float64   `json:"coverage"`
+	Timestamp       string    `json:"timestamp"`
+	PublishedAt     time.Time `json:"publishedAt"`
+}
+
+// slaWindow is a rolling window of --sla-windows
+type slaWindow struct {
+	name     string
+	duration time.Duration
+}
+
+// slaSample is the uptime and downtime of one period
+type slaSample struct {
+	uptime   int
+	downtime int
+}
+
+// SLATracker keeps the uptime and downtime of every period seen per site for the
+// longest window and computes availability over each window
+type SLATracker struct {
+	windows []slaWindow
+	period  time.Duration
+	samples map[string]map[time.Time]slaSample
+	logger  *logrus.Logger
+}
+
+// NewSLATracker creates a tracker for windows (e.g. 24h, 7d, 30d) of periods of
+// metricType (5m, 1h, 1d)
+func NewSLATracker(windows []string, metricType string, logger *logrus.Logger) (*SLATracker, error) {
+	period, err := parseDays(metricType)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported metric type %q for SLA windows", metricType)
+	}
+
+	t := &SLATracker{
+		period:  period,
+		samples: make(map[string]map[time.Time]slaSample),
+		logger:  logger,
+	}
+	for _, name := range windows {
+		d, err := parseDays(name)
+		if err != nil || d < period {
+			return nil, fmt.Errorf("invalid SLA window %q, expected a duration of at least one %s period such as 24h or 7d", name, metricType)
+		}
+		t.windows = append(t.windows, slaWindow{name: name, duration: d})
+	}
+	return t, nil
+}
+
+// parseDays parses a duration that may also be given in days, such as 7d
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Evaluate adds every period in metrics to its site's history and computes each
+// window of every site, ending at the site's latest period
+func (t *SLATracker) Evaluate(metrics *ISPMetrics) []SLAMetric {
+	var slaMetrics []SLAMetric
+
+	for _, data := range metrics.Data {
+		if len(data.Periods) == 0 {
+			continue
+		}
+
+		samples, ok := t.samples[data.SiteId]
+		if !ok {
+			samples = make(map[time.Time]slaSample)
+			t.samples[data.SiteId] = samples
+		}
+		for _, period := range data.Periods {
+			metricTime, err := time.Parse(time.RFC3339, period.MetricTime)
+			if err != nil {
+				t.logger.WithError(err).WithField("siteId", data.SiteId).Debug("Skipping period with an invalid metricTime")
+				continue
+			}
+			samples[metricTime] = slaSample{uptime: period.Data.WAN.Uptime, downtime: period.Data.WAN.Downtime}
+		}
+
+		latest, err := time.Parse(time.RFC3339, data.Periods[0].MetricTime)
+		if err != nil {
+			continue
+		}
+
+		// Forget periods older than the longest window
+		var longest time.Duration
+		for _, w := range t.windows {
+			longest = max(longest, w.duration)
+		}
+		for metricTime := range samples {
+			if !metricTime.After(latest.Add(-longest)) {
+				delete(samples, metricTime)
+			}
+		}
+
+		for _, w := range t.windows {
+			slaMetric := SLAMetric{
+				SiteId:      data.SiteId,
+				HostId:      data.HostId,
+				Window:      w.name,
+				Timestamp:   data.Periods[0].MetricTime,
+				PublishedAt: time.Now(),
+			}
+
+			var uptime, downtime int
+			var downtimeSeconds float64
+			for metricTime, sample := range samples {
+				total := sample.uptime + sample.downtime
+				if !metricTime.After(latest.Add(-w.duration)) || total <= 0 {
+					continue
+				}
+				slaMetric.Periods++
+				uptime += sample.uptime
+				downtime += sample.downtime
+				downtimeSeconds += t.period.Seconds() * float64(sample.downtime) / float64(total)
+			}
+			if slaMetric.Periods == 0 {
+				continue
+			}
+
+			slaMetric.Availability = math.Round(float64(uptime)/float64(uptime+downtime)*100000) / 1000
+			slaMetric.DowntimeSeconds = int(math.Round(downtimeSeconds))
+			coverage := float64(slaMetric.Periods) * t.period.Seconds() / w.duration.Seconds()
+			slaMetric.Coverage = math.Round(min(coverage, 1)*1000) / 10
+
+			slaMetrics = append(slaMetrics, slaMetric)
+		}
+	}
+
+	return slaMetrics
+}
+
+// PublishSLA publishes a site's availability over one window to the site's sla
+// topic followed by the window, e.g. <topic>/<siteId>/sla/30d
+func (p *MQTTPublisher) PublishSLA(slaMetric SLAMetric, site Metric) error {
+	payload, err := json.Marshal(slaMetric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SLA metric: %w", err)
+	}
+
+	topic, err := p.siteTopic("sla", site)
+	if err != nil {
+		return err
+	}
+	topic += "/" + slaMetric.Window
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"siteId":       slaMetric.SiteId,
+		"availability": slaMetric.Availability,
+		"coverage":     slaMetric.Coverage,
+	}).Debug("Publishing SLA metric to MQTT")
+
+	if err := p.sendSite(topic, site, payload); err != nil {
+		return fmt.Errorf("failed to publish SLA metric to MQTT: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// sparkplugNamespace is the first topic level of Sparkplug B messages
+const sparkplugNamespace = "spBv1.0"
+
+// sparkplugRebirth is the node control metric a host application sets to request births
+const sparkplugRebirth = "Node Control/Rebirth"
+
+// Sparkplug B metric data types
+const (
+	sparkplugInt64   = 4
+	sparkplugUInt64  = 8
+	sparkplugBoolean = 11
+	sparkplugString  = 12
+)
+
+// sparkplugMetric is a single metric of a Sparkplug B payload. Exactly one of the
+// value fields is used, selected by dataType.
+type sparkplugMetric struct {
+	name      string
+	timestamp time.Time
+	dataType  uint32
+	intValue  int64
+	boolValue bool
+	strValue  string
+}
+
+// SparkplugPublisher is a sink publishing WAN metrics as a Sparkplug B edge node.
+// Every site is a device of the node: its first metric after (re)connecting is
+// published as DBIRTH, later ones as DDATA. The node publishes NBIRTH on connect,
+// registers NDEATH as its will and answers rebirth requests received on NCMD.
+//
+// The connection is managed here instead of by paho's auto-reconnect, as every
+// session needs a new bdSeq in both its will and NBIRTH.
+type SparkplugPublisher struct {
+	cli      *CLI
+	group    string
+	node     string
+	clientID string
+	logger   *logrus.Logger
+
+	mu     sync.Mutex
+	client mqtt.Client
+	bdSeq  uint64
+	seq    uint64
+	born   map[string]bool
+	latest map[string]Metric
+}
+
+// NewSparkplugPublisher creates an edge node publishing to --mqtt-broker. A separate
+// client ID is used when the mqtt sink shares the broker, as its will differs.
+func NewSparkplugPublisher(cli *CLI, logger *logrus.Logger) (*SparkplugPublisher, error) {
+	if err := checkSparkplugIDs(cli); err != nil {
+		return nil, err
+	}
+
+	clientID := cli.MqttClientID
+	if hasSink(cli.Sinks, "mqtt") {
+		clientID += "-sparkplug"
+	}
+
+	p := &SparkplugPublisher{
+		cli:      cli,
+		group:    cli.SparkplugGroup,
+		node:     cli.SparkplugEdgeNode,
+		clientID: clientID,
+		logger:   logger,
+		born:     make(map[string]bool),
+		latest:   make(map[string]Metric),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkSparkplugIDs reports group and edge node IDs that cannot be used in topics
+func checkSparkplugIDs(cli *CLI) error {
+	for name, id := range map[string]string{"group": cli.SparkplugGroup, "edge node": cli.SparkplugEdgeNode} {
+		if id == "" {
+			return fmt.Errorf("sparkplug %s ID is required", name)
+		}
+		if strings.ContainsAny(id, "/+#") {
+			return fmt.Errorf("sparkplug %s ID %q must not contain /, + or #", name, id)
+		}
+	}
+	return nil
+}
+
+// Name implements Sink
+func (p *SparkplugPublisher) Name() string {
+	return "sparkplug"
+}
+
+// Publish implements Sink, reconnecting first if the previous session was lost
+func (p *SparkplugPublisher) Publish(ctx context.Context, metric Metric) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil || !p.client.IsConnectionOpen() {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	p.latest[metric.SiteId] = metric
+	return p.publishDevice(metric)
+}
+
+// Close implements Sink by publishing NDEATH before disconnecting, as a graceful
+// disconnect does not trigger the will
+func (p *SparkplugPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil || !p.client.IsConnectionOpen() {
+		return nil
+	}
+	token := p.client.Publish(p.topic("NDEATH", ""), 1, false, p.deathPayload())
+	token.WaitTimeout(5 * time.Second)
+	p.client.Disconnect(250)
+	return token.Error()
+}
+
+// connect opens a new session with the next bdSeq and publishes NBIRTH. Devices are
+// born again by their next metric. Callers must hold mu.
+func (p *SparkplugPublisher) connect() error {
+	if p.client != nil {
+		p.client.Disconnect(0)
+		p.bdSeq = (p.bdSeq + 1) % 256
+	}
+
+	opts, err := newMQTTClientOptions(p.cli)
+	if err != nil {
+		return err
+	}
+	opts.SetClientID(p.clientID)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	opts.SetConnectRetry(false)
+	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetBinaryWill(p.topic("NDEATH", ""), p.deathPayload(), 1, false)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		p.logger.WithError(err).Error("Lost Sparkplug connection to MQTT broker")
+	})
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+	p.client = client
+
+	if token := client.Subscribe(p.topic("NCMD", ""), 1, p.handleCommand); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to Sparkplug node commands: %w", token.Error())
+	}
+
+	if err := p.publishNodeBirth(); err != nil {
+		return err
+	}
+	p.logger.WithFields(logrus.Fields{
+		"group": p.group,
+		"node":  p.node,
+		"bdSeq": p.bdSeq,
+	}).Info("Published Sparkplug node birth")
+	return nil
+}
+
+// handleCommand answers a rebirth request by publishing NBIRTH and the DBIRTH of
+// every known site
+func (p *SparkplugPublisher) handleCommand(client mqtt.Client, msg mqtt.Message) {
+	if !sparkplugRebirthRequested(msg.Payload()) {
+		return
+	}
+
+	// Publishing from within a message handler blocks paho's delivery, so answer asynchronously
+	go func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.logger.Info("Sparkplug rebirth requested")
+		if err := p.publishNodeBirth(); err != nil {
+			p.logger.WithError(err).Warn("Failed to publish Sparkplug node birth")
+			return
+		}
+		for _, metric := range p.latest {
+			if err := p.publishDevice(metric); err != nil {
+				p.logger.WithError(err).WithField("siteId", metric.SiteId).Warn("Failed to publish Sparkplug device birth")
+			}
+		}
+	}()
+}
+
+// publishNodeBirth publishes NBIRTH, restarting the sequence and marking every
+// device unborn. Callers must hold mu.
+func (p *SparkplugPublisher) publishNodeBirth() error {
+	p.seq = 0
+	p.born = make(map[string]bool)
+
+	now := time.Now()
+	payload := encodeSparkplugPayload(now, p.seq, []sparkplugMetric{
+		{name: "bdSeq", timestamp: now, dataType: sparkplugUInt64, intValue: int64(p.bdSeq)},
+		{name: sparkplugRebirth, timestamp: now, dataType: sparkplugBoolean},
+		{name: "Properties/Version", timestamp: now, dataType: sparkplugString, strValue: version},
+	}, true)
+	return p.send(p.topic("NBIRTH", ""), payload)
+}
+
+// publishDevice publishes DBIRTH for a site not yet born in this session, else
+// DDATA. Callers must hold mu.
+func (p *SparkplugPublisher) publishDevice(metric Metric) error {
+	msgType := "DDATA"
+	if !p.born[metric.SiteId] {
+		msgType = "DBIRTH"
+	}
+
+	p.seq = (p.seq + 1) % 256
+	payload := encodeSparkplugPayload(time.Now(), p.seq, sparkplugDeviceMetrics(metric), true)
+	if err := p.send(p.topic(msgType, metric.SiteId), payload); err != nil {
+		return err
+	}
+	p.born[metric.SiteId] = true
+	return nil
+}
+
+// send publishes a Sparkplug message, which is never retained and uses QoS 0
+func (p *SparkplugPublisher) send(topic string, payload []byte) error {
+	token := p.client.Publish(topic, 0, false, payload)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish %s: %w", topic, token.Error())
+	}
+	return nil
+}
+
+// topic returns the Sparkplug topic of a message type, for the node or a device
+func (p *SparkplugPublisher) topic(msgType, device string) string {
+	topic := fmt.Sprintf("%s/%s/%s/%s", sparkplugNamespace, p.group, msgType, p.node)
+	if device != "" {
+		topic += "/" + device
+	}
+	return topic
+}
+
+// deathPayload returns the NDEATH payload of the current session, which carries
+// only its bdSeq
+func (p *SparkplugPublisher) deathPayload() []byte {
+	now := time.Now()
+	return encodeSparkplugPayload(now, 0, []sparkplugMetric{
+		{name: "bdSeq", timestamp: now, dataType: sparkplugUInt64, intValue: int64(p.bdSeq)},
+	}, false)
+}
+
+// sparkplugDeviceMetrics returns the device metrics of a site, timestamped with
+// the period's metricTime
+func sparkplugDeviceMetrics(metric Metric) []sparkplugMetric {
+	metricTime, err := time.Parse(time.RFC3339, metric.Timestamp)
+	if err != nil {
+		metricTime = metric.PublishedAt
+	}
+
+	var metrics []sparkplugMetric
+	for _, v := range wanValues(metric.WAN) {
+		metrics = append(metrics, sparkplugMetric{
+			name:      "WAN/" + v.name,
+			timestamp: metricTime,
+			dataType:  sparkplugInt64,
+			intValue:  int64(v.value),
+		})
+	}
+	return append(metrics,
+		sparkplugMetric{name: "WAN/isp_name", timestamp: metricTime, dataType: sparkplugString, strValue: metric.WAN.ISPName},
+		sparkplugMetric{name: "WAN/isp_asn", timestamp: metricTime, dataType: sparkplugString, strValue: metric.WAN.ISPAsn},
+		sparkplugMetric{name: "Properties/host_id", timestamp: metricTime, dataType: sparkplugString, strValue: metric.HostId},
+	)
+}
+
+// encodeSparkplugPayload encodes an org.eclipse.tahu.protobuf.Payload message. NDEATH
+// payloads carry no sequence number.
+func encodeSparkplugPayload(timestamp time.Time, seq uint64, metrics []sparkplugMetric, withSeq bool) []byte {
+	var out []byte
+	out = protowire.AppendTag(out, 1, protowire.VarintType)
+	out = protowire.AppendVarint(out, uint64(timestamp.UnixMilli()))
+
+	for _, m := range metrics {
+		var metric []byte
+		metric = protowire.AppendTag(metric, 1, protowire.BytesType)
+		metric = protowire.AppendString(metric, m.name)
+		metric = protowire.AppendTag(metric, 3, protowire.VarintType)
+		metric = protowire.AppendVarint(metric, uint64(m.timestamp.UnixMilli()))
+		metric = protowire.AppendTag(metric, 4, protowire.VarintType)
+		metric = protowire.AppendVarint(metric, uint64(m.dataType))
+
+		switch m.dataType {
+		case sparkplugBoolean:
+			metric = protowire.AppendTag(metric, 14, protowire.VarintType)
+			metric = protowire.AppendVarint(metric, protowire.EncodeBool(m.boolValue))
+		case sparkplugString:
+			metric = protowire.AppendTag(metric, 15, protowire.BytesType)
+			metric = protowire.AppendString(metric, m.strValue)
+		default:
+			// Int64 and UInt64 share long_value, signed values in two's complement
+			metric = protowire.AppendTag(metric, 11, protowire.VarintType)
+			metric = protowire.AppendVarint(metric, uint64(m.intValue))
+		}
+
+		out = protowire.AppendTag(out, 2, protowire.BytesType)
+		out = protowire.AppendBytes(out, metric)
+	}
+
+	if withSeq {
+		out = protowire.AppendTag(out, 3, protowire.VarintType)
+		out = protowire.AppendVarint(out, seq)
+	}
+	return out
+}
+
+// sparkplugRebirthRequested reports whether an NCMD payload sets the rebirth metric
+func sparkplugRebirthRequested(payload []byte) bool {
+	for len(payload) > 0 {
+		num, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return false
+		}
+		payload = payload[n:]
+
+		if num != 2 || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, payload)
+			if n < 0 {
+				return false
+			}
+			payload = payload[n:]
+			continue
+		}
+
+		metric, n := protowire.ConsumeBytes(payload)
+		if n < 0 {
+			return false
+		}
+		payload = payload[n:]
+		if name, value := parseSparkplugBoolMetric(metric); name == sparkplugRebirth && value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSparkplugBoolMetric returns the name and boolean value of an encoded metric
+func parseSparkplugBoolMetric(metric []byte) (string, bool) {
+	var name string
+	var value bool
+	for len(metric) > 0 {
+		num, typ, n := protowire.ConsumeTag(metric)
+		if n < 0 {
+			return "", false
+		}
+		metric = metric[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(metric)
+			if n < 0 {
+				return "", false
+			}
+			name = s
+			metric = metric[n:]
+		case num == 14 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(metric)
+			if n < 0 {
+				return "", false
+			}
+			value = v != 0
+			metric = metric[n:]
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, metric)
+			if n < 0 {
+				return "", false
+			}
+			metric = metric[n:]
+		}
+	}
+	return name, value
+}
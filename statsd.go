@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// statsdMaxPacket keeps datagrams below the common 1500 byte MTU
+const statsdMaxPacket = 1432
+
+// statsdTagReplacer removes the characters DogStatsD uses as separators from tag values
+var statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// StatsDWriter is a sink emitting WAN metrics as StatsD gauges over UDP. Plain StatsD
+// has no tags, so the siteId is part of the metric name; with DogStatsD it is a tag.
+type StatsDWriter struct {
+	conn      net.Conn
+	prefix    string
+	tags      []string
+	dogstatsd bool
+	logger    *logrus.Logger
+}
+
+// NewStatsDWriter creates a writer sending to --statsd-addr
+func NewStatsDWriter(cli *CLI, logger *logrus.Logger) (*StatsDWriter, error) {
+	if cli.StatsdAddr == "" {
+		return nil, fmt.Errorf("statsd address is required")
+	}
+	if len(cli.StatsdTags) > 0 && !cli.StatsdDogstatsd {
+		return nil, fmt.Errorf("statsd tags require --statsd-dogstatsd")
+	}
+
+	conn, err := net.Dial("udp", cli.StatsdAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve StatsD address: %w", err)
+	}
+
+	return &StatsDWriter{
+		conn:      conn,
+		prefix:    strings.TrimSuffix(cli.StatsdPrefix, "."),
+		tags:      cli.StatsdTags,
+		dogstatsd: cli.StatsdDogstatsd,
+		logger:    logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *StatsDWriter) Name() string {
+	return "statsd"
+}
+
+// Publish implements Sink
+func (w *StatsDWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink, packing the gauges of all metrics into as few
+// datagrams as possible
+func (w *StatsDWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	var packet bytes.Buffer
+	for _, metric := range metrics {
+		for _, line := range w.lines(metric) {
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+				if err := w.send(packet.Bytes()); err != nil {
+					return err
+				}
+				packet.Reset()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		}
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	return w.send(packet.Bytes())
+}
+
+// Close implements Sink
+func (w *StatsDWriter) Close() error {
+	return w.conn.Close()
+}
+
+// lines returns the gauge lines of a single metric
+func (w *StatsDWriter) lines(metric Metric) []string {
+	wan := metric.WAN
+	gauges := wanValues(wan)
+
+	name := w.prefix + ".wan."
+	suffix := ""
+	if w.dogstatsd {
+		tags := append([]string{
+			"site_id:" + metric.SiteId,
+			"host_id:" + metric.HostId,
+			"metric_type:" + metric.MetricType,
+		}, w.tags...)
+		if wan.ISPName != "" {
+			tags = append(tags, "isp_name:"+statsdTagReplacer.Replace(wan.ISPName))
+		}
+		if wan.ISPAsn != "" {
+			tags = append(tags, "isp_asn:"+wan.ISPAsn)
+		}
+		suffix = "|#" + strings.Join(tags, ",")
+	} else {
+		name = fmt.Sprintf("%s.%s.wan.", w.prefix, metric.SiteId)
+	}
+
+	lines := make([]string, 0, len(gauges))
+	for _, g := range gauges {
+		lines = append(lines, fmt.Sprintf("%s%s:%d|g%s", name, g.name, g.value, suffix))
+	}
+	return lines
+}
+
+// send writes a single datagram
+func (w *StatsDWriter) send(packet []byte) error {
+	if _, err := w.conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to send to StatsD: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StdoutWriter is a sink writing one JSON document per metric and line to stdout,
+// for piping into jq, Vector, Fluent Bit or Telegraf. Logs go to stderr.
+type StdoutWriter struct {
+	mu  sync.Mutex
+	out *bufio.Writer
+}
+
+// NewStdoutWriter creates a writer for out
+func NewStdoutWriter(out io.Writer) *StdoutWriter {
+	return &StdoutWriter{out: bufio.NewWriter(out)}
+}
+
+// Name implements Sink
+func (w *StdoutWriter) Name() string {
+	return "stdout"
+}
+
+// Publish implements Sink
+func (w *StdoutWriter) Publish(ctx context.Context, metric Metric) error {
+	return w.PublishBatch(ctx, []Metric{metric})
+}
+
+// PublishBatch implements BatchSink, flushing once all metrics are written so
+// readers see every poll as soon as it completes
+func (w *StdoutWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, metric := range metrics {
+		line, err := json.Marshal(newWANMetric(metric))
+		if err != nil {
+			return fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		w.out.Write(line)
+		w.out.WriteByte('\n')
+	}
+	if err := w.out.Flush(); err != nil {
+		return fmt.Errorf("failed to write to stdout: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (w *StdoutWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Flush()
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syslogQueueSize is the number of log entries queued while the syslog server is slow or unreachable
+const syslogQueueSize = 1000
+
+// syslogTimeout bounds connecting to and writing to the syslog server
+const syslogTimeout = 5 * time.Second
+
+// syslogSDID is the ID of the structured data element carrying the log fields, under
+// the enterprise number reserved for documentation by RFC 5612
+const syslogSDID = "fields@32473"
+
+// syslogFacilities are the facility codes by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities maps log levels onto syslog severities
+var syslogSeverities = map[logrus.Level]int{
+	logrus.PanicLevel: 1, // alert
+	logrus.FatalLevel: 2, // crit
+	logrus.ErrorLevel: 3, // err
+	logrus.WarnLevel:  4, // warning
+	logrus.InfoLevel:  6, // info
+	logrus.DebugLevel: 7, // debug
+	logrus.TraceLevel: 7,
+}
+
+// SyslogHook is a logrus hook sending log entries as RFC 5424 messages to a local or
+// remote syslog server, with their fields as structured data. Entries are queued and
+// sent in the background so a slow or unreachable server never blocks the poller;
+// they are dropped when the queue is full.
+type SyslogHook struct {
+	network   string
+	addr      string
+	tlsConfig *tls.Config
+	facility  int
+	hostname  string
+	appName   string
+	pid       int
+
+	queue     chan []byte
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	conn      net.Conn
+	failing   bool
+}
+
+// NewSyslogHook creates a hook sending to the --syslog URL: udp://host[:514],
+// tcp://host[:514], tls://host[:6514] or unix:///path
+func NewSyslogHook(cli *CLI) (*SyslogHook, error) {
+	network, addr, useTLS, err := parseSyslogURL(cli.Syslog)
+	if err != nil {
+		return nil, err
+	}
+	facility, ok := syslogFacilities[cli.SyslogFacility]
+	if !ok {
+		return nil, fmt.Errorf("unknown --syslog-facility %q", cli.SyslogFacility)
+	}
+
+	var tlsConfig *tls.Config
+	if useTLS {
+		if tlsConfig, err = newHTTPTLSConfig(cli.SyslogTLSCAFile, false); err != nil {
+			return nil, err
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	hook := &SyslogHook{
+		network:   network,
+		addr:      addr,
+		tlsConfig: tlsConfig,
+		facility:  facility,
+		hostname:  hostname,
+		appName:   syslogToken(cli.SyslogAppName, 48),
+		pid:       os.Getpid(),
+		queue:     make(chan []byte, syslogQueueSize),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go hook.run()
+	return hook, nil
+}
+
+// parseSyslogURL returns the network and address of a --syslog URL and whether it uses TLS
+func parseSyslogURL(raw string) (network, addr string, useTLS bool, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid --syslog URL: %w", err)
+	}
+
+	defaultPort := "514"
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return "", "", false, fmt.Errorf("--syslog %q has no socket path, e.g. unix:///dev/log", raw)
+		}
+		return "unixgram", u.Path, false, nil
+	case "udp", "tcp":
+		network = u.Scheme
+	case "tls":
+		network, useTLS, defaultPort = "tcp", true, "6514"
+	default:
+		return "", "", false, fmt.Errorf("unsupported --syslog scheme %q (use udp, tcp, tls or unix)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", "", false, fmt.Errorf("--syslog %q has no host", raw)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return network, net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// Levels implements logrus.Hook
+func (h *SyslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook, queueing the entry without waiting for the server.
+// Entries logged after Close are dropped.
+func (h *SyslogHook) Fire(entry *logrus.Entry) error {
+	select {
+	case <-h.done:
+		return nil
+	default:
+	}
+	select {
+	case h.queue <- h.format(entry):
+	default:
+	}
+	return nil
+}
+
+// Close sends the queued entries, waiting at most syslogTimeout, and closes the
+// connection. The queue stays open, as other goroutines may still be logging.
+func (h *SyslogHook) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+		select {
+		case <-h.stopped:
+		case <-time.After(syslogTimeout):
+		}
+	})
+}
+
+// format renders an entry as an RFC 5424 message
+func (h *SyslogHook) format(entry *logrus.Entry) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - ",
+		h.facility*8+syslogSeverities[entry.Level],
+		entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		h.hostname, h.appName, h.pid)
+
+	if len(entry.Data) == 0 {
+		b.WriteString("-")
+	} else {
+		keys := make([]string, 0, len(entry.Data))
+		for key := range entry.Data {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		b.WriteString("[" + syslogSDID)
+		for _, key := range keys {
+			value := entry.Data[key]
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			fmt.Fprintf(&b, ` %s="%s"`, syslogToken(key, 32), syslogParamEscaper.Replace(fmt.Sprint(value)))
+		}
+		b.WriteString("]")
+	}
+
+	b.WriteString(" " + entry.Message)
+	return []byte(b.String())
+}
+
+// syslogParamEscaper escapes the characters RFC 5424 reserves in structured data values
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogToken makes s a valid header field or parameter name of at most n printable
+// ASCII characters, without spaces or the characters reserved in structured data
+func syslogToken(s string, n int) string {
+	token := []byte(s)
+	for i, c := range token {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			token[i] = '_'
+		}
+	}
+	if len(token) > n {
+		token = token[:n]
+	}
+	if len(token) == 0 {
+		return "-"
+	}
+	return string(token)
+}
+
+// run sends the queued messages until the hook is closed, then the messages still
+// queued
+func (h *SyslogHook) run() {
+	defer close(h.stopped)
+	defer func() {
+		if h.conn != nil {
+			h.conn.Close()
+		}
+	}()
+	for {
+		select {
+		case message := <-h.queue:
+			h.deliver(message)
+		case <-h.done:
+			for {
+				select {
+				case message := <-h.queue:
+					h.deliver(message)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends a message, reconnecting once if the connection fails
+func (h *SyslogHook) deliver(message []byte) {
+	err := h.send(message)
+	if err != nil && h.conn != nil {
+		// The server may have closed an idle connection, so reconnect once
+		h.conn.Close()
+		h.conn = nil
+		err = h.send(message)
+	}
+	h.reportFailure(err)
+}
+
+// send writes a message, connecting first when needed. Stream connections frame
+// messages by octet counting (RFC 6587), datagrams carry one message each.
+func (h *SyslogHook) send(message []byte) error {
+	if h.conn == nil {
+		dialer := &net.Dialer{Timeout: syslogTimeout}
+		var err error
+		if h.tlsConfig != nil {
+			h.conn, err = tls.DialWithDialer(dialer, h.network, h.addr, h.tlsConfig)
+		} else {
+			h.conn, err = dialer.Dial(h.network, h.addr)
+		}
+		if err != nil {
+			h.conn = nil
+			return err
+		}
+	}
+
+	if h.network == "tcp" {
+		message = append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	}
+	h.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := h.conn.Write(message)
+	return err
+}
+
+// reportFailure writes to stderr when sending starts or stops failing, since the
+// logger itself cannot be used from within its hook
+func (h *SyslogHook) reportFailure(err error) {
+	switch {
+	case err != nil && !h.failing:
+		fmt.Fprintf(os.Stderr, "Failed to send logs to syslog at %s, dropping them until it is reachable: %v\n", h.addr, err)
+	case err == nil && h.failing:
+		fmt.Fprintf(os.Stderr, "Sending logs to syslog at %s again\n", h.addr)
+	}
+	h.failing = err != nil
+}
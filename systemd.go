@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SystemdNotifier sends service state notifications over $NOTIFY_SOCKET to systemd,
+// for units of Type=notify with an optional WatchdogSec. It does nothing when the
+// poller is not started by such a unit.
+type SystemdNotifier struct {
+	socket   string
+	watchdog time.Duration
+	logger   *logrus.Logger
+}
+
+// NewSystemdNotifier creates a notifier from the environment systemd passes to the service
+func NewSystemdNotifier(logger *logrus.Logger) *SystemdNotifier {
+	notifier := &SystemdNotifier{socket: os.Getenv("NOTIFY_SOCKET"), logger: logger}
+
+	// WATCHDOG_PID, when set, names the process the watchdog applies to
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if pid := os.Getenv("WATCHDOG_PID"); err == nil && usec > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		notifier.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return notifier
+}
+
+// Enabled reports whether the poller was started by a Type=notify unit
+func (n *SystemdNotifier) Enabled() bool {
+	return n.socket != ""
+}
+
+// WatchdogInterval returns how often the watchdog has to be pinged, half of
+// WatchdogSec as systemd recommends, or zero when the watchdog is disabled
+func (n *SystemdNotifier) WatchdogInterval() time.Duration {
+	if !n.Enabled() {
+		return 0
+	}
+	return n.watchdog / 2
+}
+
+// Notify sends the newline-separated state assignments, such as READY=1, logging
+// rather than returning a failure since systemd may not be listening
+func (n *SystemdNotifier) Notify(state ...string) {
+	if !n.Enabled() {
+		return
+	}
+	if err := n.send(strings.Join(state, "\n")); err != nil {
+		n.logger.WithError(err).WithField("state", state).Warn("Failed to notify systemd")
+	}
+}
+
+// send writes a notification datagram to the socket, which is in the abstract
+// namespace when it starts with @
+func (n *SystemdNotifier) send(state string) error {
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+	if strings.HasPrefix(addr.Name, "@") {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to $NOTIFY_SOCKET: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
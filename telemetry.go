@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// TelemetrySnapshot is the poller's own operational state
+type TelemetrySnapshot struct {
+	StartedAt     time.Time  `json:"startedAt"`
+	Polls         int        `json:"polls"`
+	PollsFailed   int        `json:"pollsFailed"`
+	APIErrors     int        `json:"apiErrors"`
+	PublishErrors int        `json:"publishErrors"`
+	Sites         int        `json:"sites"`
+	LastPoll      *time.Time `json:"lastPoll,omitempty"`
+	LastSuccess   *time.Time `json:"lastSuccess,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	// MQTTConnectionsLost and MQTTReconnects count broker disconnects and the
+	// reconnect attempts made after them
+	MQTTConnectionsLost int `json:"mqttConnectionsLost"`
+	MQTTReconnects      int `json:"mqttReconnects"`
+}
+
+// Telemetry counts poll cycles and their outcome. It is exported as Prometheus
+// metrics and feeds the health endpoints.
+type Telemetry struct {
+	startedAt time.Time
+
+	pollsDesc         *prometheus.Desc
+	pollsFailedDesc   *prometheus.Desc
+	apiErrorsDesc     *prometheus.Desc
+	publishErrorsDesc *prometheus.Desc
+	sitesDesc         *prometheus.Desc
+	lastPollDesc      *prometheus.Desc
+	lastSuccessDesc   *prometheus.Desc
+	mqttLostDesc      *prometheus.Desc
+	mqttReconnectDesc *prometheus.Desc
+
+	mu            sync.RWMutex
+	polls         int
+	pollsFailed   int
+	apiErrors     int
+	publishErrors int
+	sites         int
+	lastPoll      time.Time
+	lastSuccess   time.Time
+	lastError     string
+	mqttLost      int
+	mqttReconnect int
+}
+
+// NewTelemetry creates telemetry starting now
+func NewTelemetry() *Telemetry {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("ubipoller", "", name), help, nil, nil)
+	}
+
+	return &Telemetry{
+		startedAt:         time.Now(),
+		pollsDesc:         desc("polls_total", "Poll cycles run"),
+		pollsFailedDesc:   desc("polls_failed_total", "Poll cycles that did not complete successfully"),
+		apiErrorsDesc:     desc("api_errors_total", "Poll cycles whose Ubiquiti API request failed"),
+		publishErrorsDesc: desc("publish_errors_total", "Messages that failed to publish to a sink"),
+		sitesDesc:         desc("sites", "Sites returned by the last successful poll"),
+		lastPollDesc:      desc("last_poll_timestamp_seconds", "Unix time the last poll cycle completed"),
+		lastSuccessDesc:   desc("last_success_timestamp_seconds", "Unix time the last successful poll cycle completed"),
+		mqttLostDesc:      desc("mqtt_connections_lost_total", "Connections to the MQTT broker that were lost"),
+		mqttReconnectDesc: desc("mqtt_reconnect_attempts_total", "Attempts to reconnect to the MQTT broker"),
+	}
+}
+
+// Record counts a completed poll cycle. err is the error that aborted the cycle,
+// which is always an API failure; publish failures are counted in summary.Errors.
+func (t *Telemetry) Record(summary CycleSummary, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.polls++
+	t.lastPoll = summary.CompletedAt
+	if !summary.Success {
+		t.pollsFailed++
+	}
+
+	if err != nil {
+		t.apiErrors++
+		t.lastError = err.Error()
+		return
+	}
+
+	t.publishErrors += summary.Errors
+	t.sites = summary.SitesFetched
+	t.lastSuccess = summary.CompletedAt
+	t.lastError = ""
+}
+
+// RecordMQTTConnectionLost counts a lost broker connection
+func (t *Telemetry) RecordMQTTConnectionLost() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mqttLost++
+}
+
+// RecordMQTTReconnect counts an attempt to reconnect to the broker
+func (t *Telemetry) RecordMQTTReconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mqttReconnect++
+}
+
+// Snapshot returns the current telemetry
+func (t *Telemetry) Snapshot() TelemetrySnapshot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	snapshot := TelemetrySnapshot{
+		StartedAt:     t.startedAt,
+		Polls:         t.polls,
+		PollsFailed:   t.pollsFailed,
+		APIErrors:     t.apiErrors,
+		PublishErrors: t.publishErrors,
+		Sites:         t.sites,
+		LastError:     t.lastError,
+
+		MQTTConnectionsLost: t.mqttLost,
+		MQTTReconnects:      t.mqttReconnect,
+	}
+	if !t.lastPoll.IsZero() {
+		lastPoll := t.lastPoll
+		snapshot.LastPoll = &lastPoll
+	}
+	if !t.lastSuccess.IsZero() {
+		lastSuccess := t.lastSuccess
+		snapshot.LastSuccess = &lastSuccess
+	}
+	return snapshot
+}
+
+// Describe implements prometheus.Collector
+func (t *Telemetry) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.pollsDesc
+	ch <- t.pollsFailedDesc
+	ch <- t.apiErrorsDesc
+	ch <- t.publishErrorsDesc
+	ch <- t.sitesDesc
+	ch <- t.lastPollDesc
+	ch <- t.lastSuccessDesc
+	ch <- t.mqttLostDesc
+	ch <- t.mqttReconnectDesc
+}
+
+// Collect implements prometheus.Collector
+func (t *Telemetry) Collect(ch chan<- prometheus.Metric) {
+	s := t.Snapshot()
+
+	ch <- prometheus.MustNewConstMetric(t.pollsDesc, prometheus.CounterValue, float64(s.Polls))
+	ch <- prometheus.MustNewConstMetric(t.pollsFailedDesc, prometheus.CounterValue, float64(s.PollsFailed))
+	ch <- prometheus.MustNewConstMetric(t.apiErrorsDesc, prometheus.CounterValue, float64(s.APIErrors))
+	ch <- prometheus.MustNewConstMetric(t.publishErrorsDesc, prometheus.CounterValue, float64(s.PublishErrors))
+	ch <- prometheus.MustNewConstMetric(t.sitesDesc, prometheus.GaugeValue, float64(s.Sites))
+	ch <- prometheus.MustNewConstMetric(t.mqttLostDesc, prometheus.CounterValue, float64(s.MQTTConnectionsLost))
+	ch <- prometheus.MustNewConstMetric(t.mqttReconnectDesc, prometheus.CounterValue, float64(s.MQTTReconnects))
+	if s.LastPoll != nil {
+		ch <- prometheus.MustNewConstMetric(t.lastPollDesc, prometheus.GaugeValue, float64(s.LastPoll.Unix()))
+	}
+	if s.LastSuccess != nil {
+		ch <- prometheus.MustNewConstMetric(t.lastSuccessDesc, prometheus.GaugeValue, float64(s.LastSuccess.Unix()))
+	}
+}
+
+// PublishTelemetry publishes a retained telemetry snapshot to baseTopic/telemetry
+func (p *MQTTPublisher) PublishTelemetry(snapshot TelemetrySnapshot, baseTopic string) error {
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal telemetry: %w", err)
+	}
+
+	topic := fmt.Sprintf("%s/telemetry", baseTopic)
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"polls":        snapshot.Polls,
+		"payload_size": len(payload),
+	}).Debug("Publishing telemetry to MQTT")
+
+	if err := p.send(topic, 1, true, payload); err != nil {
+		return fmt.Errorf("failed to publish telemetry to MQTT: %w", err)
+	}
+
+	return nil
+}
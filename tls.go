@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// brokerUsesTLS reports whether the broker URL scheme implies a TLS connection
+func brokerUsesTLS(broker string) bool {
+	for _, scheme := range []string{"tls://", "ssl://", "mqtts://", "tcps://", "wss://"} {
+		if strings.HasPrefix(strings.ToLower(broker), scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyBrokerUsesTLS reports whether any of the broker URLs implies a TLS connection
+func anyBrokerUsesTLS(brokers []string) bool {
+	return slices.ContainsFunc(brokers, brokerUsesTLS)
+}
+
+// newMQTTTLSConfig builds the TLS configuration for the MQTT connection, or nil
+// when neither the broker scheme nor any TLS option asks for TLS
+func newMQTTTLSConfig(cli *CLI) (*tls.Config, error) {
+	if !anyBrokerUsesTLS(cli.MqttBroker) && cli.MqttTLSCAFile == "" && !cli.MqttTLSInsecure && cli.MqttTLSServerName == "" && cli.MqttTLSCertFile == "" && !cli.MqttAwsIot {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cli.MqttTLSServerName,
+		InsecureSkipVerify: cli.MqttTLSInsecure,
+	}
+
+	// AWS IoT Core only accepts MQTT with client certificates on 443 when negotiated over ALPN
+	if cli.MqttAwsIot && slices.ContainsFunc(cli.MqttBroker, func(broker string) bool { return brokerPort(broker) == "443" }) {
+		tlsConfig.NextProtos = []string{awsIoTALPN}
+	}
+
+	if cli.MqttTLSCAFile != "" {
+		pool, err := loadCertPool(cli.MqttTLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// Client certificate for brokers that require mutual TLS
+	if cli.MqttTLSCertFile != "" || cli.MqttTLSKeyFile != "" {
+		if cli.MqttTLSCertFile == "" || cli.MqttTLSKeyFile == "" {
+			return nil, fmt.Errorf("both a client certificate and key file are required for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(cli.MqttTLSCertFile, cli.MqttTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// newHTTPTLSConfig builds the TLS configuration of an HTTP client trusting the CA
+// certificates in caFile, or the system roots when it is empty
+func newHTTPTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecure,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// TopicData is the data made available to the per-site topic template
+type TopicData struct {
+	BaseTopic  string
+	Metric     string
+	MetricType string
+	SiteId     string
+	SiteName   string
+	HostId     string
+	HostName   string
+	ISPName    string
+	ISPAsn     string
+	Labels     map[string]string
+}
+
+// newTopicData builds the topic data of metric (latency, wan, plan, counters) for a site
+func newTopicData(baseTopic, metric string, site Metric) TopicData {
+	siteName := site.Labels["site_name"]
+	if siteName == "" {
+		siteName = site.SiteId
+	}
+	hostName := site.Labels["host_name"]
+	if hostName == "" {
+		hostName = site.HostId
+	}
+
+	return TopicData{
+		BaseTopic:  baseTopic,
+		Metric:     metric,
+		MetricType: site.MetricType,
+		SiteId:     site.SiteId,
+		SiteName:   siteName,
+		HostId:     site.HostId,
+		HostName:   hostName,
+		ISPName:    site.WAN.ISPName,
+		ISPAsn:     site.WAN.ISPAsn,
+		Labels:     site.Labels,
+	}
+}
+
+// parseTopicTemplate compiles the --mqtt-topic-template option
+func parseTopicTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("topic").Funcs(announceFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse topic template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderTopic executes the topic template and rejects topics that cannot be published to
+func renderTopic(tmpl *template.Template, data TopicData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render topic: %w", err)
+	}
+
+	topic := buf.String()
+	if topic == "" {
+		return "", fmt.Errorf("topic template rendered an empty topic for site %s", data.SiteId)
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return "", fmt.Errorf("topic %q for site %s contains a wildcard character", topic, data.SiteId)
+	}
+	return topic, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the poll/publish pipeline. It is a no-op until
+// setupTracing installs a tracer provider.
+var tracer = otel.Tracer("github.com/aaronwald/ubipoller")
+
+// setupTracing installs a global tracer provider exporting spans over OTLP/HTTP to
+// endpoint. The returned function flushes pending spans and must be called on exit.
+func setupTracing(ctx context.Context, endpoint, serviceName string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	res, err := newOtelResource(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// newOtelResource describes the poller in exported traces and metrics. Attributes
+// from OTEL_RESOURCE_ATTRIBUTES are merged in.
+func newOtelResource(ctx context.Context, serviceName string) (*resource.Resource, error) {
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OpenTelemetry resource: %w", err)
+	}
+	return res, nil
+}
+
+// initTracing sets up tracing when --otel-endpoint is configured and returns the
+// function flushing spans on shutdown
+func initTracing(cli *CLI, logger *logrus.Logger) (func(), error) {
+	if cli.OtelEndpoint == "" {
+		return func() {}, nil
+	}
+
+	shutdown, err := setupTracing(context.Background(), cli.OtelEndpoint, cli.OtelServiceName)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("endpoint", cli.OtelEndpoint).Info("Exporting traces over OTLP")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			logger.WithError(err).Warn("Failed to flush traces")
+		}
+	}, nil
+}
+
+// endSpan records err on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
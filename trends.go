@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TrendMetric carries trend context for every WAN value of a site's latest period
+type TrendMetric struct {
+	SiteId      string                `json:"siteId"`
+	HostId      string                `json:"hostId"`
+	Timestamp   string                `json:"timestamp"`
+	Window      string                `json:"window"`
+	Periods     int                   `json:"periods"`
+	Values      map[string]TrendValue `json:"values"`
+	PublishedAt time.Time             `json:"publishedAt"`
+}
+
+// TrendValue is one WAN value with its change since the previous period and its
+// moving average, minimum and maximum over the window
+type TrendValue struct {
+	Value   int     `json:"value"`
+	Change  *int    `json:"change"`
+	Average float64 `json:"average"`
+	Min     int     `json:"min"`
+	Max     int     `json:"max"`
+}
+
+// TrendTracker keeps the WAN data of every period seen per site within the window
+type TrendTracker struct {
+	name    string
+	window  time.Duration
+	periods map[string]map[time.Time]WANData
+}
+
+// NewTrendTracker creates a tracker for a window such as 1h or 1d
+func NewTrendTracker(window string) (*TrendTracker, error) {
+	d, err := parseDays(window)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid trend window %q, expected a duration such as 1h or 1d", window)
+	}
+	return &TrendTracker{
+		name:    window,
+		window:  d,
+		periods: make(map[string]map[time.Time]WANData),
+	}, nil
+}
+
+// Evaluate adds every period in metrics to its site's history and computes the
+// trends of each site's latest period. The change is nil until an earlier period
+// of the site has been seen.
+func (t *TrendTracker) Evaluate(metrics *ISPMetrics) []TrendMetric {
+	var trendMetrics []TrendMetric
+
+	for _, data := range metrics.Data {
+		if len(data.Periods) == 0 {
+			continue
+		}
+		latest, err := time.Parse(time.RFC3339, data.Periods[0].MetricTime)
+		if err != nil {
+			continue
+		}
+
+		periods, ok := t.periods[data.SiteId]
+		if !ok {
+			periods = make(map[time.Time]WANData)
+			t.periods[data.SiteId] = periods
+		}
+		for _, period := range data.Periods {
+			if metricTime, err := time.Parse(time.RFC3339, period.MetricTime); err == nil {
+				periods[metricTime] = period.Data.WAN
+			}
+		}
+
+		// The previous period is kept even when it falls outside the window
+		var previous time.Time
+		for metricTime := range periods {
+			if metricTime.Before(latest) && metricTime.After(previous) {
+				previous = metricTime
+			}
+		}
+		for metricTime := range periods {
+			if !metricTime.After(latest.Add(-t.window)) && !metricTime.Equal(previous) {
+				delete(periods, metricTime)
+			}
+		}
+
+		trendMetric := TrendMetric{
+			SiteId:      data.SiteId,
+			HostId:      data.HostId,
+			Timestamp:   data.Periods[0].MetricTime,
+			Window:      t.name,
+			Values:      make(map[string]TrendValue),
+			PublishedAt: time.Now(),
+		}
+
+		for _, v := range wanValues(periods[latest]) {
+			trend := TrendValue{Value: v.value, Min: v.value, Max: v.value}
+			if wan, ok := periods[previous]; ok && !previous.IsZero() {
+				prev, _ := lookupWANValue(wan, v.name)
+				change := v.value - prev
+				trend.Change = &change
+			}
+
+			sum, n := 0, 0
+			for metricTime, wan := range periods {
+				if !metricTime.After(latest.Add(-t.window)) {
+					continue
+				}
+				value, _ := lookupWANValue(wan, v.name)
+				sum += value
+				n++
+				trend.Min = min(trend.Min, value)
+				trend.Max = max(trend.Max, value)
+			}
+			trend.Average = math.Round(float64(sum)/float64(n)*100) / 100
+			trendMetric.Periods = n
+			trendMetric.Values[v.name] = trend
+		}
+
+		trendMetrics = append(trendMetrics, trendMetric)
+	}
+
+	return trendMetrics
+}
+
+// PublishTrends publishes a site's trend metric to the site's trends topic
+func (p *MQTTPublisher) PublishTrends(trendMetric TrendMetric, site Metric) error {
+	payload, err := json.Marshal(trendMetric)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trend metric: %w", err)
+	}
+
+	topic, err := p.siteTopic("trends", site)
+	if err != nil {
+		return err
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"siteId":       trendMetric.SiteId,
+		"periods":      trendMetric.Periods,
+		"payload_size": len(payload),
+	}).Debug("Publishing trend metric to MQTT")
+
+	if err := p.sendSite(topic, site, payload); err != nil {
+		return fmt.Errorf("failed to publish trends to MQTT: %w", err)
+	}
+
+	return nil
+}
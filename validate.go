@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// ValidateCmd checks the configuration and the credentials it holds without polling
+type ValidateCmd struct {
+	CLI `kong:"embed"`
+
+	Offline   bool `kong:"help='Only check the configuration, without calling the Ubiquiti API'"`
+	CheckMqtt bool `kong:"name='check-mqtt',help='Also connect to the MQTT broker'"`
+}
+
+// Run reports every problem found in the configuration, then verifies the API key
+// and, with --check-mqtt, the broker connection
+func (c *ValidateCmd) Run() error {
+	logger := newLogger(c.LogLevel, c.LogFormat)
+
+	errs := []error{c.CheckConfig()}
+	if !c.Offline {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := checkAPIKeys(ctx, &c.CLI, logger); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.CheckMqtt && hasSink(c.Sinks, "mqtt") {
+		// Every failover broker is checked, not just the first that accepts the connection
+		for _, broker := range c.MqttBroker {
+			if err := checkMQTTBroker(&c.CLI, broker, logger); err != nil {
+				errs = append(errs, err)
+			} else {
+				logger.WithField("broker", broker).Info("Connected to MQTT broker")
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	logger.WithField("sinks", c.Sinks).Info("Configuration is valid")
+	return nil
+}
+
+// checkAPIKeys checks the API key of every account, or the local controller credentials
+func checkAPIKeys(ctx context.Context, cli *CLI, logger *logrus.Logger) error {
+	accounts, err := newAccounts(cli, logger)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, account := range accounts {
+		switch err := checkAPIKey(ctx, cli, account); {
+		case err != nil:
+			errs = append(errs, err)
+		case cli.Source == "local":
+			logger.WithField("local_url", cli.LocalURL).Info("UniFi controller credentials accepted")
+		default:
+			logger.WithFields(logrus.Fields{"api_url": cli.ApiURL, "account": account.Name}).Info("Ubiquiti API key accepted")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkAPIKey makes a small metrics request of every metric type with the key of
+// account, translating failures into the option most likely at fault
+func checkAPIKey(ctx context.Context, cli *CLI, account Account) error {
+	var err error
+	var metricType string
+	switch client := account.Client.(type) {
+	case *UbiquitiClient:
+		check := *client
+		check.retry.MaxRetries = 0
+		end := time.Now()
+		for _, metricType = range cli.MetricTypes {
+			if _, err = check.GetISPMetricsRange(ctx, metricType, end.Add(-time.Hour), end); err != nil {
+				break
+			}
+		}
+	case *LocalClient:
+		// Logging in and listing the sites is all a controller check needs
+		var sites []localSite
+		if err := client.getJSON(ctx, "/api/self/sites", &sites); err != nil {
+			return fmt.Errorf("failed to query the controller at %s, check --local-url and the credentials: %w", cli.LocalURL, err)
+		}
+		return nil
+	}
+	if err == nil {
+		return nil
+	}
+
+	key := "--api-key"
+	if account.Name != "" {
+		key = fmt.Sprintf("the --accounts key of %s", account.Name)
+	}
+
+	var statusErr *APIStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("the Ubiquiti API rejected %s (status %d), create a new key in UniFi Site Manager", key, statusErr.StatusCode)
+		case http.StatusNotFound:
+			return fmt.Errorf("the Ubiquiti API returned 404 for metric type %q, check --api-url and --metric-type", metricType)
+		case http.StatusTooManyRequests:
+			return fmt.Errorf("the Ubiquiti API is rate limiting %s, try again later", key)
+		}
+	}
+	return fmt.Errorf("failed to query the Ubiquiti API at %s, check --api-url and network access: %w", cli.ApiURL, err)
+}
+
+// checkMQTTBroker connects to broker and disconnects again. A separate client ID is
+// used and nothing is published, so a running poller is neither disconnected nor
+// reported offline.
+func checkMQTTBroker(cli *CLI, broker string, logger *logrus.Logger) error {
+	// A persistent session would be kept by the broker for the validate client ID
+	check := *cli
+	check.MqttBroker = []string{broker}
+	check.MqttCleanSession = true
+	check.MqttSessionExpiry = 0
+	check.MqttConnectRetry = false
+	cli = &check
+
+	if cli.MqttVersion == "5" {
+		conn, err := newMQTT5Conn(cli, cli.MqttClientID+"-validate", nil, nil, &reconnectTracker{logger: logger})
+		if err != nil {
+			return fmt.Errorf("%w, check --mqtt-broker, credentials and TLS options", err)
+		}
+		conn.disconnect()
+		return nil
+	}
+
+	opts, err := newMQTTClientOptions(cli)
+	if err != nil {
+		return err
+	}
+	opts.SetClientID(cli.MqttClientID + "-validate")
+	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetAutoReconnect(false)
+	opts.SetConnectRetry(false)
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(15 * time.Second) {
+		return fmt.Errorf("timed out connecting to MQTT broker %s, check --mqtt-broker", broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker %s, check --mqtt-broker, credentials and TLS options: %w", broker, err)
+	}
+	client.Disconnect(250)
+	return nil
+}
+
+// CheckConfig loads every file and template referenced by the configuration and checks
+// that the options fit together, without opening connections or listeners. All
+// problems are returned together.
+func (cli *CLI) CheckConfig() error {
+	var errs []error
+
+	if _, err := newAccounts(cli, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if cli.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("--interval must be positive"))
+	}
+	if err := checkMetricTypes(cli); err != nil {
+		errs = append(errs, err)
+	}
+	if cli.Schedule != "" {
+		if _, err := ParseCronSchedule(cli.Schedule); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cli.Syslog != "" {
+		if _, _, _, err := parseSyslogURL(cli.Syslog); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(cli.Sinks) == 0 {
+		errs = append(errs, fmt.Errorf("at least one sink must be configured"))
+	}
+	for _, name := range cli.Sinks {
+		switch name {
+		case "mqtt":
+			if len(cli.MqttBroker) == 0 && !cli.DryRun {
+				errs = append(errs, fmt.Errorf("the mqtt sink requires --mqtt-broker"))
+			}
+			if cli.MqttBufferDir != "" && cli.MqttBufferSize <= 0 {
+				errs = append(errs, fmt.Errorf("--mqtt-buffer-dir requires a positive --mqtt-buffer-size"))
+			}
+		case "prometheus", "stdout":
+		case "influx":
+			if _, err := NewInfluxWriter(cli.InfluxURL, cli.InfluxOrg, cli.InfluxBucket, cli.InfluxToken, cli.InfluxMeasurement, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid influx sink: %w", err))
+			}
+		case "kafka":
+			if _, err := NewKafkaWriter(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid kafka sink: %w", err))
+			}
+		case "nats":
+			if cli.NatsURL == "" {
+				errs = append(errs, fmt.Errorf("the nats sink requires --nats-url"))
+			}
+		case "redis":
+			if _, err := NewRedisPublisher(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid redis sink: %w", err))
+			}
+		case "postgres":
+			if cli.PostgresURL == "" {
+				errs = append(errs, fmt.Errorf("the postgres sink requires --postgres-url"))
+			}
+		case "file":
+			if cli.FilePath == "" {
+				errs = append(errs, fmt.Errorf("the file sink requires --file-path"))
+			}
+		case "webhook":
+			if _, err := NewWebhookWriter(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid webhook sink: %w", err))
+			}
+		case "statsd":
+			if len(cli.StatsdTags) > 0 && !cli.StatsdDogstatsd {
+				errs = append(errs, fmt.Errorf("statsd tags require --statsd-dogstatsd"))
+			}
+		case "graphite":
+			if _, err := NewGraphiteWriter(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid graphite sink: %w", err))
+			}
+		case "cloudwatch":
+			if cli.CloudwatchNamespace == "" {
+				errs = append(errs, fmt.Errorf("the cloudwatch sink requires --cloudwatch-namespace"))
+			}
+		case "datadog":
+			if _, err := NewDatadogWriter(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid datadog sink: %w", err))
+			}
+		case "newrelic":
+			if _, err := NewNewRelicWriter(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid newrelic sink: %w", err))
+			}
+		case "remote-write":
+			if _, err := NewRemoteWriteWriter(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid remote-write sink: %w", err))
+			}
+		case "otlp":
+			if cli.OtlpMetricsEndpoint == "" {
+				errs = append(errs, fmt.Errorf("the otlp sink requires --otlp-metrics-endpoint"))
+			}
+		case "eventhubs":
+			if _, err := NewEventHubsWriter(cli, nil); err != nil {
+				errs = append(errs, fmt.Errorf("invalid eventhubs sink: %w", err))
+			}
+		case "sparkplug":
+			if len(cli.MqttBroker) == 0 {
+				errs = append(errs, fmt.Errorf("the sparkplug sink requires --mqtt-broker"))
+			}
+			if err := checkSparkplugIDs(cli); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown sink %q", name))
+		}
+	}
+
+	if !hasSink(cli.Sinks, "mqtt") && !cli.DryRun &&
+		(cli.AnnounceFile != "" || cli.HADiscovery || cli.SiteMetadata != "" || cli.PublishDeltas || cli.PublishCycles || cli.PublishTelemetry || cli.PublishHosts || cli.PublishTrends || cli.PublishSLA || cli.PublishRollups || cli.PublishISPChanges || cli.PublishOutages || cli.AlertRules != "" || cli.AnomalyDetection) {
+		errs = append(errs, fmt.Errorf("announcements, plan, delta, trend, SLA, rollup, cycle, telemetry, host, ISP change, outage, alert and anomaly messages require the mqtt sink"))
+	}
+	if cli.PublishHosts && cli.Source == "local" {
+		errs = append(errs, fmt.Errorf("--publish-hosts requires --source cloud"))
+	}
+	if cli.PublishTrends {
+		if _, err := NewTrendTracker(cli.TrendWindow); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cli.PublishSLA && len(cli.MetricTypes) > 0 {
+		if _, err := NewSLATracker(cli.SLAWindows, cli.MetricTypes[0], nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cli.PublishRollups && len(cli.MetricTypes) > 0 {
+		if _, err := NewRollupAggregator(cli.RollupIntervals, cli.MetricTypes[0]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cli.AlertRules != "" {
+		if _, err := LoadAlertRules(cli.AlertRules); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load alert rules: %w", err))
+		}
+	}
+	if cli.AnomalyDetection {
+		if _, err := NewAnomalyDetector(cli.AnomalyMetrics, cli.AnomalyAlpha, cli.AnomalyZThreshold, cli.AnomalyMinDeviation, cli.AnomalyWarmup, nil); err != nil {
+			errs = append(errs, err)
+		}
+		if cli.AnomalyAlpha <= 0 || cli.AnomalyAlpha > 1 {
+			errs = append(errs, fmt.Errorf("--anomaly-alpha must be greater than 0 and at most 1"))
+		}
+		if cli.AnomalyZThreshold <= 0 {
+			errs = append(errs, fmt.Errorf("--anomaly-z-threshold must be positive"))
+		}
+		if cli.AnomalyMinDeviation < 0 {
+			errs = append(errs, fmt.Errorf("--anomaly-min-deviation must not be negative"))
+		}
+		if cli.AnomalyWarmup < 2 {
+			errs = append(errs, fmt.Errorf("--anomaly-warmup must be at least 2 polls"))
+		}
+	}
+
+	if _, err := parseTopicTemplate(cli.MqttTopicTemplate); err != nil {
+		errs = append(errs, err)
+	}
+	if hasSink(cli.Sinks, "mqtt") || cli.DryRun {
+		if err := checkMetricTypeTopics(cli.MetricTypes, cli.MqttTopicTemplate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cli.MqttPayloadTemplates != "" {
+		if _, err := LoadPayloadTemplates(cli.MqttPayloadTemplates); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := newMQTTTLSConfig(cli); err != nil {
+		errs = append(errs, fmt.Errorf("failed to configure MQTT TLS: %w", err))
+	}
+	if cli.MqttGzipThreshold < 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-gzip-threshold must not be negative"))
+	}
+	if strings.ContainsAny(cli.MqttGzipTopicSuffix, "+#") {
+		errs = append(errs, fmt.Errorf("--mqtt-gzip-topic-suffix must not contain + or #"))
+	}
+	if cli.MqttAwsIot {
+		if err := checkAWSIoTConfig(cli); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cli.MqttVersion != "5" && cli.MqttMessageExpiry != 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-message-expiry requires --mqtt-version 5"))
+	}
+	if cli.MqttVersion != "5" && cli.MqttSessionExpiry != 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-session-expiry requires --mqtt-version 5, MQTT 3.1.1 brokers keep sessions by their own policy"))
+	}
+	if cli.MqttVersion == "5" && !cli.MqttCleanSession && cli.MqttSessionExpiry == 0 {
+		errs = append(errs, fmt.Errorf("--no-mqtt-clean-session requires --mqtt-session-expiry with --mqtt-version 5, the session otherwise ends with the connection"))
+	}
+	if cli.MqttSessionExpiry < 0 || cli.MqttSessionExpiry%time.Second != 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-session-expiry must be a non-negative number of whole seconds"))
+	}
+	if !cli.MqttCleanSession && cli.MqttClientID == "" {
+		errs = append(errs, fmt.Errorf("--no-mqtt-clean-session requires a fixed --mqtt-client-id"))
+	}
+	if cli.MqttMessageExpiry < 0 || cli.MqttMessageExpiry%time.Second != 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-message-expiry must be a non-negative number of whole seconds"))
+	}
+	if cli.MqttTopicAliases < 0 || cli.MqttTopicAliases > 65535 {
+		errs = append(errs, fmt.Errorf("--mqtt-topic-aliases must be between 0 and 65535"))
+	}
+	if _, err := newMQTTWebsocketConfig(cli); err != nil {
+		errs = append(errs, err)
+	}
+	if (cli.MqttWsProxy != "" || len(cli.MqttWsHeaders) > 0) && !slices.ContainsFunc(cli.MqttBroker, brokerUsesWebsocket) {
+		errs = append(errs, fmt.Errorf("--mqtt-ws-proxy and --mqtt-ws-header require a ws:// or wss:// --mqtt-broker"))
+	}
+	if cli.MqttConnectTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-connect-timeout must be positive"))
+	}
+	if cli.MqttConnectRetryInterval <= 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-connect-retry-interval must be positive"))
+	}
+	if cli.MqttMaxReconnectInterval <= 0 {
+		errs = append(errs, fmt.Errorf("--mqtt-max-reconnect-interval must be positive"))
+	}
+
+	var announceTemplates []AnnounceTemplate
+	if cli.AnnounceFile != "" {
+		templates, err := LoadAnnounceTemplates(cli.AnnounceFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to load announce templates: %w", err))
+		}
+		announceTemplates = templates
+	}
+	if cli.PublishPayloadSchema && !hasSink(cli.Sinks, "mqtt") {
+		errs = append(errs, fmt.Errorf("--publish-payload-schema requires the mqtt sink"))
+	}
+	if cli.PayloadFormat == "avro" {
+		for _, sink := range []string{"mqtt", "nats", "redis"} {
+			if hasSink(cli.Sinks, sink) {
+				errs = append(errs, fmt.Errorf("--payload-format avro is only supported by the kafka sink, not %s", sink))
+			}
+		}
+	}
+	if cli.HADiscovery && cli.PayloadFormat != "json" {
+		// Home Assistant's value templates read the wan state as JSON
+		errs = append(errs, fmt.Errorf("--ha-discovery requires --payload-format json"))
+	}
+	if cli.HADiscovery && cli.MqttTopicMode == "scalar" {
+		errs = append(errs, fmt.Errorf("--ha-discovery requires the wan topic, use --mqtt-topic-mode object or both"))
+	}
+	if cli.HADiscovery {
+		announceTemplates = append(announceTemplates, homeAssistantTemplates(cli.HAPrefix)...)
+	}
+	if _, err := compileAnnounceTemplates(announceTemplates); err != nil {
+		errs = append(errs, fmt.Errorf("invalid announce templates: %w", err))
+	}
+
+	var metadata *SiteMetadataFile
+	if cli.SiteMetadata != "" {
+		var err error
+		metadata, err = LoadSiteMetadata(cli.SiteMetadata)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to load site metadata: %w", err))
+		}
+	}
+	if _, err := newResolver(cli, nil, metadata, nil); err != nil {
+		errs = append(errs, fmt.Errorf("failed to create resolver: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
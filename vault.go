@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// vaultServiceAccountToken is the service account token of a pod, used by the kubernetes auth method
+const vaultServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultRetryDelay is the wait before retrying a failed token or lease renewal
+const vaultRetryDelay = 30 * time.Second
+
+// VaultClient reads the secret options from HashiCorp Vault at startup and then keeps
+// its token and the lease of the secret alive
+type VaultClient struct {
+	addr       string
+	auth       string
+	mount      string
+	role       string
+	secretID   string
+	path       string
+	httpClient *http.Client
+
+	token          string
+	tokenTTL       time.Duration
+	tokenRenewable bool
+	leaseID        string
+	leaseTTL       time.Duration
+}
+
+// vaultResponse is the body of a Vault API response
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+// newVaultClient creates the client of the Vault server at --vault-addr
+func newVaultClient(cli *CLI) (*VaultClient, error) {
+	if _, err := url.ParseRequestURI(cli.VaultAddr); err != nil {
+		return nil, fmt.Errorf("invalid --vault-addr: %w", err)
+	}
+	if cli.VaultPath == "" {
+		return nil, fmt.Errorf("--vault-addr requires --vault-path")
+	}
+
+	mount := cli.VaultAuthMount
+	switch cli.VaultAuth {
+	case "token":
+		if cli.VaultToken == "" {
+			return nil, fmt.Errorf("--vault-auth token requires --vault-token")
+		}
+	case "approle":
+		if cli.VaultRole == "" || cli.VaultSecretId == "" {
+			return nil, fmt.Errorf("--vault-auth approle requires --vault-role and --vault-secret-id")
+		}
+	case "kubernetes":
+		if cli.VaultRole == "" {
+			return nil, fmt.Errorf("--vault-auth kubernetes requires --vault-role")
+		}
+	}
+	if mount == "" {
+		mount = cli.VaultAuth
+	}
+
+	tlsConfig, err := newHTTPTLSConfig(cli.VaultTLSCAFile, false)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &VaultClient{
+		addr:       strings.TrimSuffix(cli.VaultAddr, "/"),
+		auth:       cli.VaultAuth,
+		mount:      strings.Trim(mount, "/"),
+		role:       cli.VaultRole,
+		secretID:   cli.VaultSecretId,
+		path:       strings.Trim(cli.VaultPath, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		token:      cli.VaultToken,
+	}, nil
+}
+
+// loadVaultSecrets logs in to Vault and sets the options that are still empty from
+// the keys of the secret at --vault-path, named like the options (api-key,
+// mqtt-username, mqtt-password, ...). Options set elsewhere take precedence.
+func (cli *CLI) loadVaultSecrets(ctx context.Context) error {
+	client, err := newVaultClient(cli)
+	if err != nil {
+		return err
+	}
+	if err := client.login(ctx); err != nil {
+		return err
+	}
+	secret, err := client.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	options := cli.secretOptions()
+	found := false
+	for key, value := range secret {
+		if key == "accounts" {
+			// The keys of --accounts as name=key pairs, one per line or separated by semicolons
+			found = true
+			if len(cli.Accounts) == 0 {
+				if cli.Accounts, err = parseAccounts(value); err != nil {
+					return fmt.Errorf("vault secret %s: invalid accounts: %w", client.path, err)
+				}
+			}
+			continue
+		}
+		option, ok := options[key]
+		if !ok {
+			continue
+		}
+		found = true
+		if *option == "" {
+			*option = value
+		}
+	}
+	if !found {
+		return fmt.Errorf("vault secret %s has none of the keys api-key, mqtt-username, mqtt-password, accounts or another secret option", client.path)
+	}
+
+	cli.vault = client
+	return nil
+}
+
+// login obtains a token with the auth method, or looks up the lifetime of --vault-token
+func (c *VaultClient) login(ctx context.Context) error {
+	if c.auth == "token" {
+		var resp vaultResponse
+		if err := c.do(ctx, http.MethodGet, "auth/token/lookup-self", nil, &resp); err != nil {
+			return fmt.Errorf("failed to look up vault token: %w", err)
+		}
+		ttl, _ := resp.Data["ttl"].(float64)
+		renewable, _ := resp.Data["renewable"].(bool)
+		c.tokenTTL, c.tokenRenewable = time.Duration(ttl)*time.Second, renewable
+		return nil
+	}
+
+	body := map[string]string{"role_id": c.role, "secret_id": c.secretID}
+	if c.auth == "kubernetes" {
+		jwt, err := readSecretFile(vaultServiceAccountToken)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		body = map[string]string{"role": c.role, "jwt": jwt}
+	}
+
+	c.token = ""
+	var resp vaultResponse
+	if err := c.do(ctx, http.MethodPost, "auth/"+c.mount+"/login", body, &resp); err != nil {
+		return fmt.Errorf("failed to log in to vault with %s auth: %w", c.auth, err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault %s login returned no token", c.auth)
+	}
+	c.token = resp.Auth.ClientToken
+	c.tokenTTL, c.tokenRenewable = time.Duration(resp.Auth.LeaseDuration)*time.Second, resp.Auth.Renewable
+	return nil
+}
+
+// read reads the string keys of the secret at the path, of a KV version 1 or 2 engine
+func (c *VaultClient) read(ctx context.Context) (map[string]string, error) {
+	var resp vaultResponse
+	if err := c.do(ctx, http.MethodGet, c.path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %w", c.path, err)
+	}
+
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+	secret := make(map[string]string, len(data))
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			secret[key] = s
+		}
+	}
+
+	if resp.Renewable {
+		c.leaseID, c.leaseTTL = resp.LeaseID, time.Duration(resp.LeaseDuration)*time.Second
+	}
+	return secret, nil
+}
+
+// Renew keeps a renewable lease of the secret, such as that of dynamic broker
+// credentials, and the token it belongs to alive by renewing them at two thirds of
+// the shorter TTL until ctx is done. A static KV secret has no lease and nothing is
+// renewed.
+func (c *VaultClient) Renew(ctx context.Context, logger *logrus.Logger) {
+	if c.leaseID == "" {
+		return
+	}
+
+	delay := c.renewDelay()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if c.tokenRenewable {
+			if err := c.renewToken(ctx); err != nil {
+				logger.WithError(err).Warn("Failed to renew vault token")
+				delay = vaultRetryDelay
+				continue
+			}
+		}
+		if err := c.renewLease(ctx); err != nil {
+			logger.WithError(err).WithField("lease_id", c.leaseID).Warn("Failed to renew vault lease")
+			delay = vaultRetryDelay
+			continue
+		}
+
+		delay = c.renewDelay()
+		logger.WithFields(logrus.Fields{
+			"lease_id":  c.leaseID,
+			"lease_ttl": c.leaseTTL,
+			"token_ttl": c.tokenTTL,
+		}).Debug("Renewed vault lease")
+	}
+}
+
+// renewDelay returns two thirds of the shorter TTL of the lease and the renewable token
+func (c *VaultClient) renewDelay() time.Duration {
+	ttl := c.leaseTTL
+	if c.tokenRenewable && c.tokenTTL > 0 && c.tokenTTL < ttl {
+		ttl = c.tokenTTL
+	}
+	if ttl <= 0 {
+		return vaultRetryDelay
+	}
+	return ttl * 2 / 3
+}
+
+// renewToken renews the token for another TTL
+func (c *VaultClient) renewToken(ctx context.Context) error {
+	var resp vaultResponse
+	if err := c.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]string{}, &resp); err != nil {
+		return err
+	}
+	if resp.Auth == nil {
+		return fmt.Errorf("vault returned no token lifetime")
+	}
+	c.tokenTTL, c.tokenRenewable = time.Duration(resp.Auth.LeaseDuration)*time.Second, resp.Auth.Renewable
+	return nil
+}
+
+// renewLease renews the lease of the secret for another TTL
+func (c *VaultClient) renewLease(ctx context.Context) error {
+	var resp vaultResponse
+	if err := c.do(ctx, http.MethodPut, "sys/leases/renew", map[string]string{"lease_id": c.leaseID}, &resp); err != nil {
+		return err
+	}
+	c.leaseTTL = time.Duration(resp.LeaseDuration) * time.Second
+	return nil
+}
+
+// do sends a request with the token to path below /v1 and decodes the response into out
+func (c *VaultClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			message = strings.Join(vaultErr.Errors, "; ")
+		}
+		return &APIStatusError{StatusCode: resp.StatusCode, Body: message}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
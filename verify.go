@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// fixtures is the corpus of anonymized recorded EA API responses
+//
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// knownPeriodVersions are the period versions of the recorded responses: version 1
+// lacks the ISP and throughput fields of version 2
+var knownPeriodVersions = []string{"1", "2"}
+
+// VerifyFixturesCmd decodes recorded API responses to detect struct drift
+type VerifyFixturesCmd struct {
+	Dir    string `kong:"help='Additional directory of recorded API responses (*.json) to verify'"`
+	Strict bool   `kong:"default='true',negatable,help='Fail on fields the decoder does not know about'"`
+}
+
+// Run verifies the embedded fixtures and any responses in --dir
+func (c *VerifyFixturesCmd) Run() error {
+	responses := map[string][]byte{}
+
+	embedded, err := fs.Glob(fixtures, "fixtures/*.json")
+	if err != nil {
+		return fmt.Errorf("failed to list embedded fixtures: %w", err)
+	}
+	for _, name := range embedded {
+		raw, err := fixtures.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("failed to read embedded fixture %s: %w", name, err)
+		}
+		responses["embedded:"+filepath.Base(name)] = raw
+	}
+
+	if c.Dir != "" {
+		paths, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", c.Dir, err)
+		}
+		for _, path := range paths {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			responses[path] = raw
+		}
+	}
+
+	names := make([]string, 0, len(responses))
+	for name := range responses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		if err := verifyResponse(responses[name], c.Strict); err != nil {
+			fmt.Printf("FAIL  %s: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok    %s\n", name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d responses failed verification", failed, len(names))
+	}
+	fmt.Printf("%d responses verified\n", len(names))
+	return nil
+}
+
+// verifyResponse decodes a raw API response and checks the fields the pipeline relies on
+func verifyResponse(raw []byte, strict bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	var metrics ISPMetrics
+	if err := decoder.Decode(&metrics); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	if metrics.Data == nil {
+		return fmt.Errorf("missing data array")
+	}
+
+	for i, data := range metrics.Data {
+		if data.SiteId == "" {
+			return fmt.Errorf("data[%d]: missing siteId", i)
+		}
+		if data.HostId == "" {
+			return fmt.Errorf("data[%d]: missing hostId", i)
+		}
+		if data.MetricType == "" {
+			return fmt.Errorf("data[%d]: missing metricType", i)
+		}
+		for j, period := range data.Periods {
+			if _, err := time.Parse(time.RFC3339, period.MetricTime); err != nil {
+				return fmt.Errorf("data[%d].periods[%d]: invalid metricTime: %w", i, j, err)
+			}
+			if !slices.Contains(knownPeriodVersions, period.Version) {
+				return fmt.Errorf("data[%d].periods[%d]: unknown version %q", i, j, period.Version)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WebhookStatusError is returned when the webhook responds with a non-2xx status
+type WebhookStatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *WebhookStatusError) Error() string {
+	return fmt.Sprintf("webhook request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether the webhook may accept the request when sent again
+func (e *WebhookStatusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// WebhookWriter is a sink sending each WAN metric as a JSON object to an HTTP endpoint
+type WebhookWriter struct {
+	url        string
+	method     string
+	headers    http.Header
+	retry      RetryPolicy
+	httpClient *http.Client
+	logger     *logrus.Logger
+}
+
+// NewWebhookWriter creates a writer for --webhook-url
+func NewWebhookWriter(cli *CLI, logger *logrus.Logger) (*WebhookWriter, error) {
+	if cli.WebhookURL == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	headers.Set("User-Agent", "ubipoller/"+version)
+	for _, header := range cli.WebhookHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid webhook header %q, expected 'Name: value'", header)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	switch {
+	case cli.WebhookBearerToken != "":
+		headers.Set("Authorization", "Bearer "+cli.WebhookBearerToken)
+	case cli.WebhookUsername != "":
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(cli.WebhookUsername, cli.WebhookPassword)
+		headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+
+	return &WebhookWriter{
+		url:     cli.WebhookURL,
+		method:  cli.WebhookMethod,
+		headers: headers,
+		retry: RetryPolicy{
+			MaxRetries: cli.WebhookRetries,
+			BaseDelay:  time.Second,
+			MaxDelay:   30 * time.Second,
+		},
+		httpClient: &http.Client{
+			Timeout: cli.WebhookTimeout,
+		},
+		logger: logger,
+	}, nil
+}
+
+// Name implements Sink
+func (w *WebhookWriter) Name() string {
+	return "webhook"
+}
+
+// Publish implements Sink by sending a single metric as a JSON object
+func (w *WebhookWriter) Publish(ctx context.Context, metric Metric) error {
+	body, err := json.Marshal(newWANMetric(metric))
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric: %w", err)
+	}
+	return w.send(ctx, body)
+}
+
+// Close implements Sink
+func (w *WebhookWriter) Close() error {
+	return nil
+}
+
+// WebhookBatchWriter is the WebhookWriter of --webhook-batch, sending all metrics of a
+// poll as one JSON array
+type WebhookBatchWriter struct {
+	*WebhookWriter
+}
+
+// PublishBatch implements BatchSink
+func (w *WebhookBatchWriter) PublishBatch(ctx context.Context, metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	payloads := make([]WANMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		payloads = append(payloads, newWANMetric(metric))
+	}
+	body, err := json.Marshal(payloads)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+	return w.send(ctx, body)
+}
+
+// send delivers body, retrying network errors, 429 and 5xx responses with backoff
+func (w *WebhookWriter) send(ctx context.Context, body []byte) error {
+	for attempt := 0; ; attempt++ {
+		err := w.sendOnce(ctx, body)
+		if err == nil || attempt >= w.retry.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := w.retry.backoff(attempt)
+		var statusErr *WebhookStatusError
+		if errors.As(err, &statusErr) {
+			if !statusErr.retryable() {
+				return err
+			}
+			if statusErr.RetryAfter > 0 {
+				delay = min(statusErr.RetryAfter, w.retry.MaxDelay)
+			}
+		}
+		w.logger.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Warn("Webhook request failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// sendOnce performs a single webhook request
+func (w *WebhookWriter) sendOnce(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = w.headers.Clone()
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &WebhookStatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
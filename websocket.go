@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// brokerUsesWebsocket reports whether the broker URL is a ws:// or wss:// URL
+func brokerUsesWebsocket(broker string) bool {
+	broker = strings.ToLower(broker)
+	return strings.HasPrefix(broker, "ws://") || strings.HasPrefix(broker, "wss://")
+}
+
+// mqttWebsocketConfig holds the HTTP options of connections to ws:// and wss:// brokers
+type mqttWebsocketConfig struct {
+	proxy  func(*http.Request) (*url.URL, error)
+	header http.Header
+}
+
+// newMQTTWebsocketConfig parses --mqtt-ws-proxy and --mqtt-ws-header. Without a proxy
+// --http-proxy, or else the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables apply.
+func newMQTTWebsocketConfig(cli *CLI) (*mqttWebsocketConfig, error) {
+	config := &mqttWebsocketConfig{
+		header: http.Header{},
+	}
+
+	var err error
+	if cli.MqttWsProxy != "" {
+		config.proxy, err = proxyFunc("--mqtt-ws-proxy", cli.MqttWsProxy)
+	} else {
+		config.proxy, err = proxyFunc("--http-proxy", cli.HttpProxy)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, header := range cli.MqttWsHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid MQTT WebSocket header %q, expected 'Name: value'", header)
+		}
+		config.header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return config, nil
+}
+
+// dialer returns the dialer of an MQTT 5 WebSocket connection
+func (c *mqttWebsocketConfig) dialer(u *url.URL, tlsConfig *tls.Config) *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.Proxy = c.proxy
+	d.TLSClientConfig = tlsConfig
+	d.Subprotocols = []string{"mqtt"}
+	return &d
+}
+
+// requestHeader returns the headers of an MQTT 5 WebSocket handshake
+func (c *mqttWebsocketConfig) requestHeader(u *url.URL, tlsConfig *tls.Config) http.Header {
+	return c.header.Clone()
+}